@@ -0,0 +1,49 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package clients
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// httpStatusPrefix starts the message of the error the isecl service clients return for a response with
+// an unexpected HTTP status code
+const httpStatusPrefix = "HTTP Status :"
+
+// Error is an error struct that contains error information thrown by the actual HVS or WLS
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("service-client: failed (HTTP Status Code: %d)\nMessage: %s", e.StatusCode, e.Message)
+}
+
+// HTTPStatusCode returns the HTTP status code of the failed response
+func (e Error) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+// statusError returns an Error carrying the HTTP status code of a failed response when err was returned by an
+// isecl service client for such a response, and err otherwise
+func statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	cause := errors.Cause(err).Error()
+	if !strings.HasPrefix(cause, httpStatusPrefix) {
+		return err
+	}
+	statusCode, convErr := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(cause, httpStatusPrefix)))
+	if convErr != nil {
+		return err
+	}
+	return Error{StatusCode: statusCode, Message: err.Error()}
+}
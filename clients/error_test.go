@@ -0,0 +1,28 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package clients
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusError(t *testing.T) {
+	assert.NoError(t, statusError(nil))
+
+	err := statusError(errors.Wrap(errors.Wrap(errors.New("HTTP Status :404"), "Error in response"), "Error from WLS API"))
+	statusErr, ok := err.(Error)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusNotFound, statusErr.HTTPStatusCode())
+
+	// a status code elsewhere in the message is not taken for the status of a response
+	requestErr := errors.New("dial tcp 10.0.0.404:5000: connection refused")
+	assert.Equal(t, requestErr, statusError(requestErr))
+	requestErr = errors.Wrap(errors.New("HTTP Status :unknown"), "Error in response")
+	assert.Equal(t, requestErr, statusError(requestErr))
+}
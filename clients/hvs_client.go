@@ -14,22 +14,14 @@ import (
 	csetup "intel/isecl/lib/common/v4/setup"
 	"intel/isecl/wlagent/v4/config"
 	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/metrics"
 	"os"
+	"time"
 )
 
 var log = cLog.GetDefaultLogger()
 var secLog = cLog.GetSecurityLogger()
 
-// Error is an error struct that contains error information thrown by the actual HVS
-type Error struct {
-	StatusCode int
-	Message    string
-}
-
-func (e Error) Error() string {
-	return fmt.Sprintf("hvs-client: failed (HTTP Status Code: %d)\nMessage: %s", e.StatusCode, e.Message)
-}
-
 // CertifyHostSigningKey sends a POST to /certify-host-signing-key to register signing key with HVS
 func CertifyHostSigningKey(key *wlaModel.RegisterKeyInfo) (*wlaModel.SigningKeyCert, error) {
 	log.Trace("clients/hvs_client:CertifyHostSigningKey() Entering")
@@ -87,10 +79,15 @@ func certifyHostKey(keyInfo *wlaModel.RegisterKeyInfo, keyUsage string) ([]byte,
 	}
 
 	var responseData []byte
+	start := time.Now()
 	if keyUsage == "signing" {
 		responseData, err = certifyHostKeysClient.CertifyHostSigningKey(keyInfo)
+		err = statusError(err)
+		metrics.ObserveServiceRequest(metrics.HVS, "CertifyHostSigningKey", start, err)
 	} else {
 		responseData, err = certifyHostKeysClient.CertifyHostBindingKey(keyInfo)
+		err = statusError(err)
+		metrics.ObserveServiceRequest(metrics.HVS, "CertifyHostBindingKey", start, err)
 	}
 	if err != nil {
 		return nil, errors.Wrap(err, "Error from response")
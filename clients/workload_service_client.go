@@ -5,17 +5,20 @@
 package clients
 
 import (
+	"context"
 	"github.com/intel-secl/intel-secl/v4/pkg/clients/wlsclient"
 	wlsModel "github.com/intel-secl/intel-secl/v4/pkg/model/wls"
 	"github.com/pkg/errors"
 	"intel/isecl/wlagent/v4/config"
 	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/metrics"
+	"net/http"
 	"net/url"
-	"strings"
+	"time"
 )
 
 // GetImageFlavorKey method is used to get the image flavor-key from the workload service
-func GetImageFlavorKey(imageUUID, hardwareUUID string) (wlsModel.FlavorKey, error) {
+func GetImageFlavorKey(ctx context.Context, imageUUID, hardwareUUID string) (wlsModel.FlavorKey, error) {
 	log.Trace("clients/workload_service_client:GetImageFlavorKey() Entering")
 	defer log.Trace("clients/workload_service_client:GetImageFlavorKey() Leaving")
 	var flavorKeyInfo wlsModel.FlavorKey
@@ -31,10 +34,24 @@ func GetImageFlavorKey(imageUUID, hardwareUUID string) (wlsModel.FlavorKey, erro
 		return flavorKeyInfo, errors.Wrap(err, "Error while instantiating FlavorsClient")
 	}
 
-	flavorKeyInfo, err = flavorsClient.GetImageFlavorKey(imageUUID, hardwareUUID)
+	done := make(chan struct{})
+	var requestErr error
+	go func() {
+		defer close(done)
+		start := time.Now()
+		var response wlsModel.FlavorKey
+		response, requestErr = flavorsClient.GetImageFlavorKey(imageUUID, hardwareUUID)
+		requestErr = statusError(requestErr)
+		metrics.ObserveServiceRequest(metrics.WLS, "GetImageFlavorKey", start, requestErr)
+		flavorKeyInfo = response
+	}()
+	if err = await(ctx, done); err != nil {
+		return wlsModel.FlavorKey{}, errors.Wrap(err, "Error while retrieving Flavor-Key")
+	}
+	err = requestErr
 	if err != nil {
 		// Return error as nil in case of http response code 404, to support docker images with no image flavor association
-		if strings.Contains(err.Error(), "404") {
+		if statusErr, ok := err.(Error); ok && statusErr.StatusCode == http.StatusNotFound {
 			return flavorKeyInfo, nil
 		}
 		return flavorKeyInfo, errors.Wrap(err, "Error while retrieving Flavor-Key")
@@ -62,7 +79,10 @@ func GetImageFlavor(imageID, flavorPart string) (wlsModel.SignedImageFlavor, err
 		return flavor, errors.Wrap(err, "Error while instantiating FlavorsClient")
 	}
 
+	start := time.Now()
 	flavor, err = flavorsClient.GetImageFlavor(imageID, flavorPart)
+	err = statusError(err)
+	metrics.ObserveServiceRequest(metrics.WLS, "GetImageFlavor", start, err)
 	if err != nil {
 		return flavor, errors.Wrap(err, "Error while getting ImageFlavor")
 	}
@@ -71,7 +91,7 @@ func GetImageFlavor(imageID, flavorPart string) (wlsModel.SignedImageFlavor, err
 }
 
 //PostVMReport method is used to upload the VM trust report to workload service
-func PostVMReport(report []byte) error {
+func PostVMReport(ctx context.Context, report []byte) error {
 	log.Trace("clients/workload_service_client:PostVMReport() Entering")
 	defer log.Trace("clients/workload_service_client:PostVMReport() Leaving")
 	var err error
@@ -88,7 +108,19 @@ func PostVMReport(report []byte) error {
 		return errors.Wrap(err, "Error while instantiating ReportsClient")
 	}
 
-	err = reportsClient.PostVMReport(report)
+	done := make(chan struct{})
+	var requestErr error
+	go func() {
+		defer close(done)
+		start := time.Now()
+		requestErr = reportsClient.PostVMReport(report)
+		requestErr = statusError(requestErr)
+		metrics.ObserveServiceRequest(metrics.WLS, "PostVMReport", start, requestErr)
+	}()
+	if err = await(ctx, done); err != nil {
+		return errors.Wrap(err, "Error creating instance trust report")
+	}
+	err = requestErr
 	if err != nil {
 		return errors.Wrap(err, "Error creating instance trust report")
 	}
@@ -96,7 +128,7 @@ func PostVMReport(report []byte) error {
 }
 
 // GetKeyWithURL method is used to get the image flavor-key from the workload service
-func GetKeyWithURL(keyUrl string, hardwareUUID string) (wlsModel.ReturnKey, error) {
+func GetKeyWithURL(ctx context.Context, keyUrl string, hardwareUUID string) (wlsModel.ReturnKey, error) {
 	log.Trace("clients/workload_service_client:GetKeyWithURL() Entering")
 	defer log.Trace("clients/workload_service_client:GetKeyWithURL() Leaving")
 	var retKey wlsModel.ReturnKey
@@ -122,10 +154,36 @@ func GetKeyWithURL(keyUrl string, hardwareUUID string) (wlsModel.ReturnKey, erro
 		return retKey, errors.Wrap(err, "Error while instantiating KeysClient")
 	}
 
-	retKey, err = keysClient.GetKeyWithURL(keyUrl, hardwareUUID)
+	done := make(chan struct{})
+	var requestErr error
+	go func() {
+		defer close(done)
+		start := time.Now()
+		var response wlsModel.ReturnKey
+		response, requestErr = keysClient.GetKeyWithURL(keyUrl, hardwareUUID)
+		requestErr = statusError(requestErr)
+		metrics.ObserveServiceRequest(metrics.WLS, "GetKeyWithURL", start, requestErr)
+		retKey = response
+	}()
+	if err = await(ctx, done); err != nil {
+		return wlsModel.ReturnKey{}, errors.Wrap(err, "Error while getting key")
+	}
+	err = requestErr
 	if err != nil {
 		return retKey, errors.Wrap(err, "Error while getting key")
 	}
 	log.Debug("client/workload_service_client:GetKeyWithURL() Successfully retrieved Key")
 	return retKey, nil
 }
+
+// await waits for done to be closed by the goroutine running a request of the isecl service clients, which take
+// no context. It returns the error of ctx when ctx is done first, the request is then abandoned rather than aborted
+// and the goroutine exits when the client times out
+func await(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -50,16 +50,47 @@ var Configuration struct {
 		BaseURL string
 	}
 	SkipFlavorSignatureVerification bool
+	FlavorCacheTTL                  *int
+	MetricsListenAddress            string
+	SocketGroup                     string
 	LogLevel                        logrus.Level
 	LogMaxLength                    int
 	ConfigComplete                  bool
 	LogEnableStdout                 bool
+	// PerVMKey gives the volume of each VM created from now on a key of its own instead of the key of its image
+	PerVMKey bool
+	// VMTimeouts are the timeouts in seconds of the VM lifecycle phases
+	VMTimeouts struct {
+		Prepare int
+		Start   int
+		Stop    int
+	}
+	// PeerAuthorization holds the rules of the processes allowed to call each method on the agent socket, by method name
+	PeerAuthorization map[string]PeerRule
+}
+
+// PeerRule allows a process connected to the agent socket to call a method when it runs with one of UIDs or GIDs, and
+// when Executables is not empty, from one of Executables. Executables only narrow the users of a rule, a rule without
+// UIDs, GIDs and Executables lets any process call the method
+type PeerRule struct {
+	UIDs        []uint32 `yaml:"uids,omitempty"`
+	GIDs        []uint32 `yaml:"gids,omitempty"`
+	Executables []string `yaml:"executables,omitempty"`
 }
 
 var (
 	configFilePath = consts.ConfigDirPath + consts.ConfigFileName
 )
 
+// FlavorCacheTTL returns the time in seconds a flavor and its key stay cached, the default TTL when the configuration
+// does not set one. A TTL of 0 disables the flavor cache
+func FlavorCacheTTL() int {
+	if Configuration.FlavorCacheTTL == nil {
+		return consts.DefaultFlavorCacheTTL
+	}
+	return *Configuration.FlavorCacheTTL
+}
+
 var secLog = cLog.GetSecurityLogger()
 var log = cLog.GetDefaultLogger()
 
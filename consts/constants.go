@@ -17,12 +17,23 @@ const (
 	LogLevelEnvVar       = "LOG_LEVEL"
 	LogEntryMaxlengthEnv = "LOG_ENTRY_MAXLENGTH"
 	EnableConsoleLogEnv  = "WLA_ENABLE_CONSOLE_LOG"
+	FlavorCacheTTLEnv    = "FLAVOR_CACHE_TTL"
+	MetricsListenAddrEnv = "METRICS_LISTEN_ADDRESS"
+	SocketGroupEnv       = "WLA_SOCKET_GROUP"
+	PrepareTimeoutEnv    = "VM_PREPARE_TIMEOUT"
+	StartTimeoutEnv      = "VM_START_TIMEOUT"
+	StopTimeoutEnv       = "VM_STOP_TIMEOUT"
+	PerVMKeyEnv          = "WLA_PER_VM_KEY"
 )
 
 const (
 	ExplicitServiceName                = "Workload Agent"
 	MinLogEntryMaxlength               = 100
 	DefaultLogEntryMaxlength           = 300
+	DefaultFlavorCacheTTL              = 300
+	DefaultPrepareTimeout              = 600
+	DefaultStartTimeout                = 120
+	DefaultStopTimeout                 = 120
 	SkipFlavorSignatureVerificationEnv = "SKIP_FLAVOR_SIGNATURE_VERIFICATION"
 	TAConfigDirEnvVar                  = "TRUSTAGENT_CONFIGURATION"
 	TAConfigAikSecretCmd               = "tagent config aik.secret"
@@ -42,8 +53,14 @@ const (
 	ConfigDirPath                      = "/etc/workload-agent/"
 	OptDirPath                         = "/opt/workload-agent/"
 	RunDirPath                         = "/var/run/workload-agent/"
+	LibDirPath                         = "/var/lib/workload-agent/"
 	LibvirtHookFilePath                = "/etc/libvirt/hooks/qemu"
 	RPCSocketFileName                  = "wlagent.sock"
+	FlavorCacheDirPath                 = RunDirPath + "flavor-cache/"
+	VMStateDirPath                     = LibDirPath + "vm-state/"
+	ImageRegistryFilePath              = ConfigDirPath + "image-registry.yml"
+	ImageVolumeDirPath                 = LibDirPath + "image-volumes/"
+	VMKeyDirPath                       = LibDirPath + "vm-keys/"
 	WlagentSymLink                     = "/usr/local/bin/wlagent"
 	ServiceStartCmd                    = "systemctl start wlagent"
 	ServiceStopCmd                     = "systemctl stop wlagent"
@@ -59,12 +76,13 @@ const (
 const (
 	QemuImgUtilPath = "/usr/bin/qemu-img"
 	// Fields for qemu-img info output
-	QemuImgInfoBackingFileField = "backing file"
-	QemuImgInfoVirtualSizeField = "virtual size"
-	QemuImgInfoFileFormatField  = "file format"
-	GetImgInfoCmd               = "info %s --force-share"
-	CreateVmDiskCmd             = "create -f %s -o backing_file=%s,backing_fmt=%s %s"
-	ResizeVmDiskCmd             = "resize %s %s"
+	QemuImgInfoBackingFileField   = "backing file"
+	QemuImgInfoBackingFormatField = "backing file format"
+	QemuImgInfoVirtualSizeField   = "virtual size"
+	QemuImgInfoFileFormatField    = "file format"
+	GetImgInfoCmd                 = "info %s --force-share"
+	CreateVmDiskCmd               = "create -f %s -o backing_file=%s,backing_fmt=%s %s"
+	ResizeVmDiskCmd               = "resize %s %s"
 )
 
 // Task Names
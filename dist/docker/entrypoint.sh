@@ -50,4 +50,4 @@ for i in "${secretFiles[@]}"; do
     unset $i
 done
 
-wlagent rungrpcservice
+wlagent runservice
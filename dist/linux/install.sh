@@ -215,6 +215,8 @@ directory_layout() {
   export WORKLOAD_AGENT_LOGS=/var/log/workload-agent
   export WORKLOAD_AGENT_HOME=/opt/workload-agent
   export WORKLOAD_AGENT_BIN=$WORKLOAD_AGENT_HOME/bin
+  export WORKLOAD_AGENT_LIB=/var/lib/workload-agent
+  export WORKLOAD_AGENT_VM_STATE=$WORKLOAD_AGENT_LIB/vm-state
 }
 directory_layout
 
@@ -235,6 +237,14 @@ done
 # log file permission change
 chmod 740 $WORKLOAD_AGENT_LOGS
 
+# the VM state survives a reboot, the libvirt hook run by root reads and writes it along with the VM volumes
+mkdir -p $WORKLOAD_AGENT_VM_STATE
+if [ $? -ne 0 ]; then
+  echo_failure "Cannot create directory: $WORKLOAD_AGENT_VM_STATE"
+  exit 1
+fi
+chmod 700 $WORKLOAD_AGENT_LIB $WORKLOAD_AGENT_VM_STATE
+
 # Copy workload agent installer to workload-agent bin directory and create a symlink
 cp -f wlagent $WORKLOAD_AGENT_BIN
 chown $TRUSTAGENT_USERNAME:$TRUSTAGENT_USERNAME $WORKLOAD_AGENT_BIN/wlagent
@@ -326,8 +336,6 @@ if [ "$WA_WITH_CONTAINER_SECURITY_CRIO" == "y" ] || [ "$WA_WITH_CONTAINER_SECURI
       exit 1
     fi
   fi
-
-  sed -i "s/runservice/rungrpcservice/g" wlagent.service
 else
   yum_packages=(libvirt cryptsetup)
   for i in ${yum_packages[*]}; do
@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package flavor
+
+import (
+	"context"
+	"encoding/json"
+	wlsModel "github.com/intel-secl/intel-secl/v4/pkg/model/wls"
+	"intel/isecl/lib/common/v4/validation"
+	wlsclient "intel/isecl/wlagent/v4/clients"
+	"intel/isecl/wlagent/v4/config"
+	"intel/isecl/wlagent/v4/consts"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	flavorCacheDirPath = consts.FlavorCacheDirPath
+	cacheMtx           sync.Mutex
+	hardwareUUIDRegex  = regexp.MustCompile("^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}$")
+)
+
+// cachedFlavorKey is the on-disk representation of a flavor cache entry
+type cachedFlavorKey struct {
+	ImageUUID    string             `json:"image_uuid"`
+	HardwareUUID string             `json:"hardware_uuid"`
+	CachedAt     time.Time          `json:"cached_at"`
+	FlavorKey    wlsModel.FlavorKey `json:"flavor_key"`
+}
+
+// GetImageFlavorKey returns the image flavor and TPM wrapped key for the image from the flavor cache,
+// and falls back to retrieving it from the workload service when there is no valid entry cached.
+// Entries are kept under the agent run directory, so each boot of the host goes back to WLS for the key
+func GetImageFlavorKey(ctx context.Context, imageUUID, hardwareUUID string) (wlsModel.FlavorKey, error) {
+	log.Trace("flavor/cache:GetImageFlavorKey() Entering")
+	defer log.Trace("flavor/cache:GetImageFlavorKey() Leaving")
+
+	ttl := time.Duration(config.FlavorCacheTTL()) * time.Second
+	cacheable := ttl > 0 && validateCacheKey(imageUUID, hardwareUUID) == nil
+	if cacheable {
+		flavorKeyInfo, ok := loadCachedFlavorKey(imageUUID, hardwareUUID, ttl)
+		if ok {
+			log.Debugf("flavor/cache:GetImageFlavorKey() Using cached image-flavor-key for image %s", imageUUID)
+			return flavorKeyInfo, nil
+		}
+	}
+
+	flavorKeyInfo, err := wlsclient.GetImageFlavorKey(ctx, imageUUID, hardwareUUID)
+	if err != nil {
+		return flavorKeyInfo, err
+	}
+
+	// nothing to cache, there is no flavor associated with the image
+	if flavorKeyInfo.Flavor.Meta.ID == "" {
+		return flavorKeyInfo, nil
+	}
+
+	if flavorKeyInfo.Flavor.EncryptionRequired && len(flavorKeyInfo.Key) == 0 {
+		// WLS refused to release the key, the host is not trusted anymore. Keys released while it
+		// was trusted must not be handed out from the cache either
+		secLog.Warnf("flavor/cache:GetImageFlavorKey() Key for image %s was not released by WLS, purging the flavor cache", imageUUID)
+		if err = PurgeCache(""); err != nil {
+			log.WithError(err).Error("flavor/cache:GetImageFlavorKey() Error purging the flavor cache")
+		}
+		return flavorKeyInfo, nil
+	}
+
+	if cacheable {
+		err = storeFlavorKey(imageUUID, hardwareUUID, flavorKeyInfo)
+		if err != nil {
+			log.WithError(err).Warnf("flavor/cache:GetImageFlavorKey() Could not cache image-flavor-key for image %s", imageUUID)
+		}
+	}
+	return flavorKeyInfo, nil
+}
+
+// PurgeCache removes the cached flavors of the given image. All the cached flavors are removed
+// when imageUUID is empty
+func PurgeCache(imageUUID string) error {
+	log.Trace("flavor/cache:PurgeCache() Entering")
+	defer log.Trace("flavor/cache:PurgeCache() Leaving")
+
+	pattern := "*.json"
+	if imageUUID != "" {
+		if err := validation.ValidateUUIDv4(imageUUID); err != nil {
+			return errors.Wrap(err, "flavor/cache:PurgeCache() Invalid image UUID")
+		}
+		pattern = imageUUID + "_*.json"
+	}
+
+	cacheMtx.Lock()
+	defer cacheMtx.Unlock()
+	entries, err := filepath.Glob(filepath.Join(flavorCacheDirPath, pattern))
+	if err != nil {
+		return errors.Wrap(err, "flavor/cache:PurgeCache() Error listing flavor cache entries")
+	}
+	for _, entry := range entries {
+		secLog.Infof("flavor/cache:PurgeCache() Removing flavor cache entry %s", entry)
+		if err = os.Remove(entry); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "flavor/cache:PurgeCache() Error removing flavor cache entry %s", entry)
+		}
+	}
+	return nil
+}
+
+func validateCacheKey(imageUUID, hardwareUUID string) error {
+	if err := validation.ValidateUUIDv4(imageUUID); err != nil {
+		return err
+	}
+	if !hardwareUUIDRegex.MatchString(hardwareUUID) {
+		return errors.New("flavor/cache:validateCacheKey() Invalid hardware UUID")
+	}
+	return nil
+}
+
+func cacheFilePath(imageUUID, hardwareUUID string) string {
+	return filepath.Join(flavorCacheDirPath, imageUUID+"_"+hardwareUUID+".json")
+}
+
+// loadCachedFlavorKey returns the cached flavor if it is present, not expired and its signature is still valid.
+// Any entry that fails these checks is removed from the cache.
+func loadCachedFlavorKey(imageUUID, hardwareUUID string, ttl time.Duration) (wlsModel.FlavorKey, bool) {
+	log.Trace("flavor/cache:loadCachedFlavorKey() Entering")
+	defer log.Trace("flavor/cache:loadCachedFlavorKey() Leaving")
+
+	cacheMtx.Lock()
+	defer cacheMtx.Unlock()
+
+	var entry cachedFlavorKey
+	entryPath := cacheFilePath(imageUUID, hardwareUUID)
+	content, err := ioutil.ReadFile(entryPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).Warnf("flavor/cache:loadCachedFlavorKey() Error reading flavor cache entry %s", entryPath)
+		}
+		return entry.FlavorKey, false
+	}
+
+	var reason string
+	if err = json.Unmarshal(content, &entry); err != nil {
+		reason = "entry is corrupted"
+	} else if entry.ImageUUID != imageUUID || entry.HardwareUUID != hardwareUUID {
+		reason = "entry does not belong to the image and host"
+	} else if time.Since(entry.CachedAt) > ttl || entry.CachedAt.After(time.Now()) {
+		reason = "entry has expired"
+	} else if err = verifyFlavorSignature(entry.FlavorKey); err != nil {
+		secLog.WithError(err).Errorf("flavor/cache:loadCachedFlavorKey() Signature verification failed for cached flavor of image %s", imageUUID)
+		reason = "flavor signature is invalid"
+	} else {
+		return entry.FlavorKey, true
+	}
+
+	log.Debugf("flavor/cache:loadCachedFlavorKey() Discarding flavor cache entry %s: %s", entryPath, reason)
+	if err = os.Remove(entryPath); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Warnf("flavor/cache:loadCachedFlavorKey() Error removing flavor cache entry %s", entryPath)
+	}
+	return wlsModel.FlavorKey{}, false
+}
+
+func storeFlavorKey(imageUUID, hardwareUUID string, flavorKeyInfo wlsModel.FlavorKey) error {
+	log.Trace("flavor/cache:storeFlavorKey() Entering")
+	defer log.Trace("flavor/cache:storeFlavorKey() Leaving")
+
+	content, err := json.Marshal(cachedFlavorKey{
+		ImageUUID:    imageUUID,
+		HardwareUUID: hardwareUUID,
+		CachedAt:     time.Now(),
+		FlavorKey:    flavorKeyInfo,
+	})
+	if err != nil {
+		return errors.Wrap(err, "flavor/cache:storeFlavorKey() Error marshalling flavor cache entry")
+	}
+
+	cacheMtx.Lock()
+	defer cacheMtx.Unlock()
+	if err = os.MkdirAll(flavorCacheDirPath, 0700); err != nil {
+		return errors.Wrapf(err, "flavor/cache:storeFlavorKey() Error creating flavor cache directory %s", flavorCacheDirPath)
+	}
+
+	// write to a temporary file and rename it so that a reader never sees a partially written entry
+	tmpFile, err := ioutil.TempFile(flavorCacheDirPath, ".tmp-")
+	if err != nil {
+		return errors.Wrap(err, "flavor/cache:storeFlavorKey() Error creating flavor cache entry")
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "flavor/cache:storeFlavorKey() Error writing flavor cache entry")
+	}
+	if err = os.Rename(tmpFile.Name(), cacheFilePath(imageUUID, hardwareUUID)); err != nil {
+		return errors.Wrap(err, "flavor/cache:storeFlavorKey() Error saving flavor cache entry")
+	}
+	return nil
+}
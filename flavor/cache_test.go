@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package flavor
+
+import (
+	wlsModel "github.com/intel-secl/intel-secl/v4/pkg/model/wls"
+	"intel/isecl/wlagent/v4/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testImageUUID    = "31ab5921-24fd-498c-8c9e-b20f61004fc0"
+	testHardwareUUID = "8032632b-8fa4-e811-906e-00163566263e"
+)
+
+func setupTestCache(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "flavor-cache")
+	assert.NoError(t, err)
+	flavorCacheDirPath = dir
+	config.Configuration.SkipFlavorSignatureVerification = true
+	return func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFlavorCacheStoreAndLoad(t *testing.T) {
+	defer setupTestCache(t)()
+
+	var flavorKeyInfo wlsModel.FlavorKey
+	flavorKeyInfo.Flavor.Meta.ID = "d6129610-4c8f-4ac4-8823-df4e925688c3"
+	flavorKeyInfo.Key = []byte("tpm-wrapped-key")
+	err := storeFlavorKey(testImageUUID, testHardwareUUID, flavorKeyInfo)
+	assert.NoError(t, err)
+
+	cached, ok := loadCachedFlavorKey(testImageUUID, testHardwareUUID, time.Minute)
+	assert.True(t, ok)
+	assert.Equal(t, flavorKeyInfo.Flavor.Meta.ID, cached.Flavor.Meta.ID)
+	assert.Equal(t, flavorKeyInfo.Key, cached.Key)
+
+	// the entry is keyed by hardware UUID as well
+	_, ok = loadCachedFlavorKey(testImageUUID, "00000000-0000-0000-0000-000000000000", time.Minute)
+	assert.False(t, ok)
+}
+
+func TestFlavorCacheExpiredEntryIsRemoved(t *testing.T) {
+	defer setupTestCache(t)()
+
+	var flavorKeyInfo wlsModel.FlavorKey
+	flavorKeyInfo.Flavor.Meta.ID = "d6129610-4c8f-4ac4-8823-df4e925688c3"
+	err := storeFlavorKey(testImageUUID, testHardwareUUID, flavorKeyInfo)
+	assert.NoError(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+	_, ok := loadCachedFlavorKey(testImageUUID, testHardwareUUID, time.Millisecond)
+	assert.False(t, ok)
+	_, err = os.Stat(cacheFilePath(testImageUUID, testHardwareUUID))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFlavorCacheCorruptedEntryIsRemoved(t *testing.T) {
+	defer setupTestCache(t)()
+
+	entryPath := cacheFilePath(testImageUUID, testHardwareUUID)
+	err := ioutil.WriteFile(entryPath, []byte("{\"image_uuid\": "), 0600)
+	assert.NoError(t, err)
+
+	_, ok := loadCachedFlavorKey(testImageUUID, testHardwareUUID, time.Minute)
+	assert.False(t, ok)
+	_, err = os.Stat(entryPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPurgeCache(t *testing.T) {
+	defer setupTestCache(t)()
+
+	otherImageUUID := "6f1ef5a4-8b5e-4e16-b6a7-f7cfb1b0b1a9"
+	var flavorKeyInfo wlsModel.FlavorKey
+	assert.NoError(t, storeFlavorKey(testImageUUID, testHardwareUUID, flavorKeyInfo))
+	assert.NoError(t, storeFlavorKey(otherImageUUID, testHardwareUUID, flavorKeyInfo))
+
+	assert.NoError(t, PurgeCache(testImageUUID))
+	entries, _ := filepath.Glob(filepath.Join(flavorCacheDirPath, "*.json"))
+	assert.Equal(t, []string{cacheFilePath(otherImageUUID, testHardwareUUID)}, entries)
+
+	assert.NoError(t, PurgeCache(""))
+	entries, _ = filepath.Glob(filepath.Join(flavorCacheDirPath, "*.json"))
+	assert.Empty(t, entries)
+
+	assert.Error(t, PurgeCache("../../etc"))
+}
@@ -5,12 +5,13 @@
 package flavor
 
 import (
+	"context"
 	"encoding/json"
 	wlsModel "github.com/intel-secl/intel-secl/v4/pkg/model/wls"
 	cLog "intel/isecl/lib/common/v4/log"
 	pinfo "intel/isecl/lib/platform-info/v4/platforminfo"
-	wlsclient "intel/isecl/wlagent/v4/clients"
 	"strings"
+	"sync"
 )
 
 var log = cLog.GetDefaultLogger()
@@ -22,15 +23,23 @@ var secLog = cLog.GetSecurityLogger()
 // which in turn usees the image uuid for fetching the flavor key
 var imageKeyID map[string]string
 
+// imageKeyIDMtx guards imageKeyID, flavors and keys are fetched concurrently over RPC
+var imageKeyIDMtx sync.RWMutex
+
 // OutFlavor is an struct containing return code and image flavor as output from RPC call
 type OutFlavor struct {
 	ReturnCode  bool
 	ImageFlavor string
 }
 
-func getKeyID(keyUrl string) string {
+// KeyID returns the ID of the image key from its transfer URL
+func KeyID(keyUrl string) string {
 
+	// the key URL ends with /keys/<key ID>/transfer
 	keyUrlSplit := strings.Split(keyUrl, "/")
+	if len(keyUrlSplit) < 2 {
+		return ""
+	}
 	keyID := keyUrlSplit[len(keyUrlSplit)-2]
 	return keyID
 }
@@ -43,7 +52,7 @@ func init() {
 // Input Parameters: imageID string, Hardware UUID
 // Return: returns a boolean value to the secure docker plugin.
 // true if the flavorkey is fetched successfully, else return false.
-func Fetch(imageID string) (string, bool) {
+func Fetch(ctx context.Context, imageID string) (string, bool) {
 	log.Trace("flavor/flavor:Fetch Entering")
 	defer log.Trace("flavor/flavor:Fetch Leaving")
 	var flavorKeyInfo wlsModel.FlavorKey
@@ -56,8 +65,8 @@ func Fetch(imageID string) (string, bool) {
 		return "", false
 	}
 	log.Debugf("The host hardware UUID is :%s", hardwareUUID)
-	// get image flavor key from workload service
-	flavorKeyInfo, err = wlsclient.GetImageFlavorKey(imageID, hardwareUUID)
+	// get image flavor key from the flavor cache or workload service
+	flavorKeyInfo, err = GetImageFlavorKey(ctx, imageID, hardwareUUID)
 	if err != nil {
 		secLog.WithError(err).Error("flavor/flavor:Fetch() Error while retrieving the image flavor")
 		return "", false
@@ -69,8 +78,11 @@ func Fetch(imageID string) (string, bool) {
 	}
 
 	if flavorKeyInfo.Flavor.EncryptionRequired {
-		keyID := getKeyID(flavorKeyInfo.Flavor.Encryption.KeyURL)
-		imageKeyID[keyID] = imageID
+		if keyID := KeyID(flavorKeyInfo.Flavor.Encryption.KeyURL); keyID != "" {
+			imageKeyIDMtx.Lock()
+			imageKeyID[keyID] = imageID
+			imageKeyIDMtx.Unlock()
+		}
 		if len(flavorKeyInfo.Key) == 0 {
 			secLog.Error("Could not retrieve flavor Key, Host is untrusted or key doesnt exist with associated flavor")
 			return "", false
@@ -0,0 +1,59 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package flavor
+
+import (
+	wlsModel "github.com/intel-secl/intel-secl/v4/pkg/model/wls"
+	"intel/isecl/lib/common/v4/pkg/instance"
+	"intel/isecl/lib/verifier/v4"
+	"intel/isecl/wlagent/v4/config"
+	"intel/isecl/wlagent/v4/consts"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// flavorIntegrityRule is the rule of the verifier that checks the flavor signature against the flavor signing
+// certificates that chain up to the trusted CA certificates
+const flavorIntegrityRule = "FlavorIntegrityMatches"
+
+// verifyFlavorSignature checks the flavor signature with the verifier library, as the instance trust report does.
+// Verification is skipped when it is disabled in the configuration.
+func verifyFlavorSignature(flavorKeyInfo wlsModel.FlavorKey) error {
+	log.Trace("flavor/flavor_signature:verifyFlavorSignature() Entering")
+	defer log.Trace("flavor/flavor_signature:verifyFlavorSignature() Leaving")
+
+	if config.Configuration.SkipFlavorSignatureVerification {
+		log.Debug("flavor/flavor_signature:verifyFlavorSignature() Flavor signature verification is disabled, skipping")
+		return nil
+	}
+
+	// there is no VM yet, only the result of the flavor integrity rule is looked at
+	signedFlavor := wlsModel.SignedImageFlavor{ImageFlavor: flavorKeyInfo.Flavor, Signature: flavorKeyInfo.Signature}
+	manifest := instance.Manifest{ImageEncrypted: flavorKeyInfo.Flavor.EncryptionRequired}
+	report, err := verifier.Verify(&manifest, &signedFlavor, consts.FlavorSigningCertDir, consts.TrustedCaCertsDir, false)
+	if err != nil {
+		return errors.Wrap(err, "flavor/flavor_signature:verifyFlavorSignature() Error verifying the image flavor")
+	}
+	trustReport, ok := report.(*verifier.InstanceTrustReport)
+	if !ok {
+		return errors.New("flavor/flavor_signature:verifyFlavorSignature() The verifier did not return an instance trust report")
+	}
+	for _, result := range trustReport.Results {
+		if result.Rule == nil || result.Rule.Name() != flavorIntegrityRule {
+			continue
+		}
+		if result.Trusted {
+			return nil
+		}
+		var faults []string
+		for _, fault := range result.Faults {
+			faults = append(faults, fault.Description)
+		}
+		return errors.Errorf("flavor/flavor_signature:verifyFlavorSignature() Flavor signature could not be verified: %s",
+			strings.Join(faults, ", "))
+	}
+	return errors.New("flavor/flavor_signature:verifyFlavorSignature() The verifier did not check the flavor signature")
+}
@@ -6,6 +6,7 @@
 package flavor
 
 import (
+	"context"
 	wlsModel "github.com/intel-secl/intel-secl/v4/pkg/model/wls"
 	pinfo "intel/isecl/lib/platform-info/v4/platforminfo"
 	wlsclient "intel/isecl/wlagent/v4/clients"
@@ -13,7 +14,7 @@ import (
 
 // RetrieveKey retrieves an Image decryption key
 // It uses the hardwareUUID that is fetched from the the Platform Info library
-func RetrieveKey(keyID string) ([]byte, bool) {
+func RetrieveKey(ctx context.Context, keyID string) ([]byte, bool) {
 	log.Trace("flavor/key_retrieval:RetrieveKey Entering")
 	defer log.Trace("flavor/key_retrieval:RetrieveKey Leaving")
 	//check if the key is cached by filtercriteria imageUUID
@@ -21,11 +22,13 @@ func RetrieveKey(keyID string) ([]byte, bool) {
 	var flavorKeyInfo wlsModel.FlavorKey
 	var tpmWrappedKey []byte
 
-	if imageKeyID[keyID] == "" {
+	imageKeyIDMtx.RLock()
+	imageUUID := imageKeyID[keyID]
+	imageKeyIDMtx.RUnlock()
+	if imageUUID == "" {
 		log.Errorf("flavor/key_retrieval:RetrieveKey() unable to get the image ID for given key ID %s", keyID)
 		return nil, false
 	}
-	imageUUID := imageKeyID[keyID]
 
 	// get host hardware UUID
 	log.Debug("Retrieving host hardware UUID...")
@@ -37,9 +40,9 @@ func RetrieveKey(keyID string) ([]byte, bool) {
 	}
 	log.Debugf("The host hardware UUID is :%s", hardwareUUID)
 
-	//get flavor-key from workload service
-	log.Infof("Retrieving image-flavor-key for image %s from WLS", imageUUID)
-	flavorKeyInfo, err = wlsclient.GetImageFlavorKey(imageUUID, hardwareUUID)
+	//get flavor-key from the flavor cache or workload service
+	log.Infof("Retrieving image-flavor-key for image %s", imageUUID)
+	flavorKeyInfo, err = GetImageFlavorKey(ctx, imageUUID, hardwareUUID)
 	if err != nil {
 		log.Errorf("flavor/key_retrieval:RetrieveKey() error retrieving the image flavor and key: %s", err.Error())
 		log.Tracef("%+v", err)
@@ -67,7 +70,7 @@ func RetrieveKey(keyID string) ([]byte, bool) {
 
 // RetrieveKeyWithURL retrieves an Image decryption key
 // It uses the hardwareUUID that is fetched from the the Platform Info library
-func RetrieveKeyWithURL(keyUrl string) ([]byte, bool) {
+func RetrieveKeyWithURL(ctx context.Context, keyUrl string) ([]byte, bool) {
 	log.Trace("flavor/key_retrieval:RetrieveKeyWithURL Entering")
 	defer log.Trace("flavor/key_retrieval:RetrieveKeyWithURL Leaving")
 	//check if the key is cached by filtercriteria imageUUID
@@ -86,7 +89,7 @@ func RetrieveKeyWithURL(keyUrl string) ([]byte, bool) {
 
 	//get flavor-key from workload service
 	log.Infof("Retrieving key %s with hardware UUID %s from WLS", keyUrl, hardwareUUID)
-	receivedKey, err = wlsclient.GetKeyWithURL(keyUrl, hardwareUUID)
+	receivedKey, err = wlsclient.GetKeyWithURL(ctx, keyUrl, hardwareUUID)
 	if err != nil {
 		log.Errorf("flavor/key_retrieval:RetrieveKeyWithURL() error retrieving key: %s", err.Error())
 		log.Tracef("%+v", err)
@@ -3,11 +3,14 @@ module intel/isecl/wlagent/v4
 require (
 	github.com/containers/ocicrypt v1.1.2
 	github.com/fsnotify/fsnotify v1.4.9
+	github.com/golang/protobuf v1.4.3
 	github.com/intel-secl/intel-secl/v4 v4.2.0-Beta
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.9.0
 	github.com/sirupsen/logrus v1.7.0
 	github.com/stretchr/testify v1.6.1
 	google.golang.org/grpc v1.33.2
+	google.golang.org/protobuf v1.25.0
 	gopkg.in/yaml.v2 v2.3.0
 	intel/isecl/lib/common/v4 v4.2.0-Beta
 	intel/isecl/lib/platform-info/v4 v4.2.0-Beta
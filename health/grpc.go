@@ -0,0 +1,53 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package health
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// ReadinessService is the service name to request in a gRPC health check to get the readiness of the
+	// agent, the empty service name requests its liveness
+	ReadinessService = "readiness"
+	// ReportHeader is the gRPC response header holding the JSON health report
+	ReportHeader = "wlagent-health-report"
+)
+
+// GRPCServer implements the standard gRPC health service, with the JSON health report sent in the
+// ReportHeader response header
+type GRPCServer struct {
+	healthpb.UnimplementedHealthServer
+}
+
+// Check answers the liveness of the agent for the empty service name, and its readiness for ReadinessService
+func (*GRPCServer) Check(ctx context.Context, request *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	log.Trace("health/grpc:Check() Entering")
+	defer log.Trace("health/grpc:Check() Leaving")
+
+	var report Report
+	switch request.GetService() {
+	case "":
+		report = Liveness()
+	case ReadinessService:
+		report = Readiness()
+	default:
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVICE_UNKNOWN}, nil
+	}
+
+	if err := grpc.SetHeader(ctx, metadata.Pairs(ReportHeader, report.JSON())); err != nil {
+		log.WithError(err).Warn("health/grpc:Check() Could not send the health report")
+	}
+	status := healthpb.HealthCheckResponse_SERVING
+	if report.Status != StatusPass {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return &healthpb.HealthCheckResponse{Status: status}, nil
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package health
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	cLog "intel/isecl/lib/common/v4/log"
+	"intel/isecl/lib/tpmprovider/v4"
+	"intel/isecl/wlagent/v4/config"
+	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/util"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var log = cLog.GetDefaultLogger()
+
+const (
+	// StatusPass is reported by a check, and by the whole report, when the agent is healthy
+	StatusPass = "pass"
+	// StatusFail is reported by a check, and by the whole report, when the agent is not healthy
+	StatusFail = "fail"
+
+	// CheckSocket is the name of the check reporting that the agent socket is serving requests
+	CheckSocket = "socket"
+
+	// dialTimeout bounds the time spent checking that a remote service is reachable
+	dialTimeout = 5 * time.Second
+)
+
+// CheckResult is the outcome of a single health check
+type CheckResult struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Report is the machine readable result of the health checks of the Workload Agent. Status is
+// StatusPass only when all the checks passed
+type Report struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// check is a named health check, returning nil when healthy
+type check struct {
+	name string
+	run  func() error
+}
+
+// Liveness returns the report of the liveness check, answered by the agent when its socket is serving
+func Liveness() Report {
+	return run([]check{socketServing()})
+}
+
+// Readiness checks that the agent can serve VM and key requests: the TPM opens, the binding and signing
+// keys exist and parse, the CA certificates are present and the Workload and Authentication services
+// are reachable
+func Readiness() Report {
+	log.Trace("health/health:Readiness() Entering")
+	defer log.Trace("health/health:Readiness() Leaving")
+
+	report := run([]check{
+		socketServing(),
+		{name: "tpm", run: checkTPM},
+		{name: "binding_key", run: func() error {
+			return checkKeyFile(consts.ConfigDirPath + consts.BindingKeyFileName)
+		}},
+		{name: "signing_key", run: func() error {
+			return checkKeyFile(consts.ConfigDirPath + consts.SigningKeyFileName)
+		}},
+		{name: "ca_certs", run: func() error {
+			return checkCACerts(consts.TrustedCaCertsDir)
+		}},
+		{name: "wls", run: func() error {
+			return checkReachable(config.Configuration.Wls.APIURL, dialTimeout)
+		}},
+		{name: "aas", run: func() error {
+			return checkReachable(config.Configuration.Aas.BaseURL, dialTimeout)
+		}},
+	})
+	if report.Status != StatusPass {
+		log.Warnf("health/health:Readiness() Workload Agent is not ready: %s", report.failures())
+	}
+	return report
+}
+
+// Failed returns the report of a client that could not get the health of the agent at all, e.g. when
+// the socket could not be dialed
+func Failed(name string, err error) Report {
+	return run([]check{{name: name, run: func() error { return err }}})
+}
+
+// JSON returns the indented JSON encoding of the report
+func (r Report) JSON() string {
+	out, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		// the report only holds strings, this cannot happen
+		return `{"status": "` + StatusFail + `"}`
+	}
+	return string(out)
+}
+
+// failures lists the checks that failed, for logging
+func (r Report) failures() string {
+	var failed []string
+	for _, result := range r.Checks {
+		if result.Status != StatusPass {
+			failed = append(failed, result.Name+": "+result.Message)
+		}
+	}
+	return strings.Join(failed, "; ")
+}
+
+// run runs the checks concurrently, so that a slow remote service does not delay the others, and
+// returns their results in order
+func run(checks []check) Report {
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = CheckResult{Name: checks[i].name, Status: StatusPass}
+			if err := checks[i].run(); err != nil {
+				results[i].Status = StatusFail
+				results[i].Message = err.Error()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusPass, Checks: results}
+	for _, result := range results {
+		if result.Status != StatusPass {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// socketServing is answered by the agent itself, so it passes whenever the agent gets to run it
+func socketServing() check {
+	return check{name: CheckSocket, run: func() error { return nil }}
+}
+
+func checkTPM() error {
+	// the check opens the TPM like a VM launch does, they must not use it at the same time
+	util.TpmMtx.Lock()
+	defer util.TpmMtx.Unlock()
+	t, err := util.GetTpmInstance()
+	if err != nil {
+		return err
+	}
+	t.Close()
+	return nil
+}
+
+// checkKeyFile checks that the TPM certified key file at keyPath exists and parses
+func checkKeyFile(keyPath string) error {
+	content, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return errors.Wrap(err, "could not read the key file")
+	}
+	var certifiedKey tpmprovider.CertifiedKey
+	if err = json.Unmarshal(content, &certifiedKey); err != nil {
+		return errors.Wrapf(err, "could not parse the key file %s", keyPath)
+	}
+	if len(certifiedKey.PublicKey) == 0 || len(certifiedKey.PrivateKey) == 0 {
+		return errors.Errorf("the key file %s does not hold a certified key", keyPath)
+	}
+	return nil
+}
+
+// checkCACerts checks that at least one PEM encoded CA certificate is present in caCertsDir
+func checkCACerts(caCertsDir string) error {
+	files, err := filepath.Glob(filepath.Join(caCertsDir, "*.pem"))
+	if err != nil {
+		return errors.Wrap(err, "could not list the CA certificates")
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if block, _ := pem.Decode(content); block != nil && block.Type == consts.PemCertificateHeader {
+			return nil
+		}
+	}
+	return errors.Errorf("no CA certificate found in %s", caCertsDir)
+}
+
+// checkReachable checks that a TCP connection can be opened to the host of serviceURL within timeout
+func checkReachable(serviceURL string, timeout time.Duration) error {
+	if strings.TrimSpace(serviceURL) == "" {
+		return errors.New("the service URL is not configured")
+	}
+	u, err := url.Parse(serviceURL)
+	if err != nil || u.Hostname() == "" {
+		return errors.Errorf("invalid service URL %s", serviceURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(u.Hostname(), port), timeout)
+	if err != nil {
+		return errors.Wrapf(err, "%s is not reachable", u.Host)
+	}
+	conn.Close()
+	return nil
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunReportsAllChecksInOrder(t *testing.T) {
+	report := run([]check{
+		{name: "first", run: func() error { return nil }},
+		{name: "second", run: func() error { return errors.New("broken") }},
+		{name: "third", run: func() error { return nil }},
+	})
+
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, []CheckResult{
+		{Name: "first", Status: StatusPass},
+		{Name: "second", Status: StatusFail, Message: "broken"},
+		{Name: "third", Status: StatusPass},
+	}, report.Checks)
+}
+
+func TestLivenessAndFailedReports(t *testing.T) {
+	live := Liveness()
+	assert.Equal(t, StatusPass, live.Status)
+	assert.Equal(t, []CheckResult{{Name: CheckSocket, Status: StatusPass}}, live.Checks)
+
+	failed := Failed(CheckSocket, errors.New("connection refused"))
+	assert.Equal(t, StatusFail, failed.Status)
+
+	var decoded Report
+	assert.NoError(t, json.Unmarshal([]byte(failed.JSON()), &decoded))
+	assert.Equal(t, failed, decoded)
+}
+
+func TestCheckKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "health")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Error(t, checkKeyFile(filepath.Join(dir, "missing.json")))
+
+	keyPath := filepath.Join(dir, "bindingkey.json")
+	assert.NoError(t, ioutil.WriteFile(keyPath, []byte("not json"), 0600))
+	assert.Error(t, checkKeyFile(keyPath))
+
+	assert.NoError(t, ioutil.WriteFile(keyPath, []byte(`{"Version": 2}`), 0600))
+	assert.Error(t, checkKeyFile(keyPath))
+
+	assert.NoError(t, ioutil.WriteFile(keyPath, []byte(`{"Version": 2, "PublicKey": "AQID", "PrivateKey": "BAUG"}`), 0600))
+	assert.NoError(t, checkKeyFile(keyPath))
+}
+
+func TestCheckCACerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "health")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Error(t, checkCACerts(dir))
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "garbage.pem"), []byte("not a certificate"), 0600))
+	assert.Error(t, checkCACerts(dir))
+
+	cert := "-----BEGIN CERTIFICATE-----\nAQID\n-----END CERTIFICATE-----\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "root.pem"), []byte(cert), 0600))
+	assert.NoError(t, checkCACerts(dir))
+}
+
+func TestCheckReachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := l.Addr().String()
+
+	assert.NoError(t, checkReachable("https://"+address+"/wls/v1", time.Second))
+	assert.Error(t, checkReachable("", time.Second))
+	assert.Error(t, checkReachable("not a url", time.Second))
+
+	l.Close()
+	assert.Error(t, checkReachable("https://"+address+"/wls/v1", time.Second))
+}
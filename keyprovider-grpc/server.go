@@ -9,6 +9,7 @@ import (
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"encoding/json"
 	"github.com/containers/ocicrypt/keywrap/keyprovider"
 	keyproviderpb "github.com/containers/ocicrypt/utils/keyprovider"
@@ -16,10 +17,21 @@ import (
 	"github.com/pkg/errors"
 	cLog "intel/isecl/lib/common/v4/log"
 	"intel/isecl/wlagent/v4/flavor"
+	"intel/isecl/wlagent/v4/metrics"
 	"intel/isecl/wlagent/v4/util"
+	"io"
+	"strings"
 	"sync"
 )
 
+const (
+	// keyUrlParameter prefixes the key URL in the key provider encryption parameters,
+	// e.g. skopeo copy --encryption-key provider:isecl:key-url:<key URL>
+	keyUrlParameter = "key-url:"
+	// wrapTypeAES is the wrap type of the annotation packets, the layer key is wrapped with AES-GCM
+	wrapTypeAES = "AES"
+)
+
 type GRPCServer struct {
 	keyproviderpb.UnimplementedKeyProviderServiceServer
 }
@@ -28,10 +40,34 @@ var log = cLog.GetDefaultLogger()
 var secLog = cLog.GetSecurityLogger()
 var mtx sync.Mutex
 
+// getKek retrieves the key at keyUrl from WLS and unwraps it with the TPM binding key
+var getKek = func(ctx context.Context, keyUrl string) ([]byte, error) {
+	wrappedKey, returnCode := flavor.RetrieveKeyWithURL(ctx, keyUrl)
+	if !returnCode {
+		return nil, errors.New("Error while retrieving wrapped kek")
+	}
+	util.TpmMtx.Lock()
+	symKey, err := util.UnwrapKey(ctx, wrappedKey)
+	util.TpmMtx.Unlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while unwrapping kek")
+	}
+	return symKey, nil
+}
+
 func (*GRPCServer) UnWrapKey(ctx context.Context, request *keyproviderpb.KeyProviderKeyWrapProtocolInput) (*keyproviderpb.KeyProviderKeyWrapProtocolOutput, error) {
 	log.Trace("keyprovider-grpc/server:UnWrapKey() Entering")
 	defer log.Trace("keyprovider-grpc/server:UnWrapKey() Leaving")
 
+	output, err := unWrapKey(ctx, request)
+	metrics.ObserveKeyUnwrap(err)
+	return output, err
+}
+
+func unWrapKey(ctx context.Context, request *keyproviderpb.KeyProviderKeyWrapProtocolInput) (*keyproviderpb.KeyProviderKeyWrapProtocolOutput, error) {
+	log.Trace("keyprovider-grpc/server:unWrapKey() Entering")
+	defer log.Trace("keyprovider-grpc/server:unWrapKey() Leaving")
+
 	var keyP keyprovider.KeyProviderKeyWrapProtocolInput
 	err := json.Unmarshal(request.KeyProviderKeyWrapProtocolInput, &keyP)
 	if err != nil {
@@ -44,13 +80,9 @@ func (*GRPCServer) UnWrapKey(ctx context.Context, request *keyproviderpb.KeyProv
 		return nil, errors.Wrap(err, "Error while unmarshalling annotation packet")
 	}
 
-	wrappedKey, returnCode := flavor.RetrieveKeyWithURL(apkt.KeyUrl)
-	if !returnCode {
-		return nil, errors.New("Error while retrieving wrapped kek")
-	}
-	symKey, err := util.UnwrapKey(wrappedKey)
+	symKey, err := getKek(ctx, apkt.KeyUrl)
 	if err != nil {
-		return nil, errors.Wrap(err, "Error while unwrapping kek")
+		return nil, err
 	}
 
 	unwrappedKey, err := aesDecrypt(symKey, apkt.WrappedKey)
@@ -103,8 +135,118 @@ func aesDecrypt(kek []byte, symKey []byte) ([]byte, error) {
 	return key, nil
 }
 
-// No operation
+func aesEncrypt(kek []byte, symKey []byte) ([]byte, error) {
+	log.Trace("keyprovider-grpc/server:aesEncrypt() Entering")
+	defer log.Trace("keyprovider-grpc/server:aesEncrypt() Leaving")
+
+	if len(kek) != 32 {
+		return nil, errors.New("Expected 256 bit key")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// a random nonce is safe as long as less than 2^32 layer keys are wrapped with the same kek
+	nonce := make([]byte, aesgcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "Unable to generate nonce")
+	}
+
+	aesp := ocicryptKeyprovider.AesPacket{
+		Ciphertext: aesgcm.Seal(nil, nonce, symKey, nil),
+		Nonce:      nonce,
+	}
+	return json.Marshal(aesp)
+}
+
+// WrapKey wraps the layer key of an image being encrypted with the key at the key URL given in the
+// encryption parameters, so that the image can be decrypted with UnWrapKey on hosts authorized for that key
 func (*GRPCServer) WrapKey(ctx context.Context, request *keyproviderpb.KeyProviderKeyWrapProtocolInput) (*keyproviderpb.KeyProviderKeyWrapProtocolOutput, error) {
+	log.Trace("keyprovider-grpc/server:WrapKey() Entering")
+	defer log.Trace("keyprovider-grpc/server:WrapKey() Leaving")
+
+	output, err := wrapKey(ctx, request)
+	metrics.ObserveKeyWrap(err)
+	return output, err
+}
+
+func wrapKey(ctx context.Context, request *keyproviderpb.KeyProviderKeyWrapProtocolInput) (*keyproviderpb.KeyProviderKeyWrapProtocolOutput, error) {
+	log.Trace("keyprovider-grpc/server:wrapKey() Entering")
+	defer log.Trace("keyprovider-grpc/server:wrapKey() Leaving")
+
+	var keyP keyprovider.KeyProviderKeyWrapProtocolInput
+	err := json.Unmarshal(request.KeyProviderKeyWrapProtocolInput, &keyP)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while unmarshalling KeyProviderKeyWrapProtocolInput")
+	}
+	if keyP.Operation != keyprovider.OpKeyWrap {
+		return nil, errors.Errorf("Operation %v not supported by WrapKey", keyP.Operation)
+	}
+
+	keyUrl, err := getKeyUrl(keyP.KeyWrapParams)
+	if err != nil {
+		return nil, err
+	}
+	secLog.Infof("keyprovider-grpc/server:wrapKey() Wrapping image layer key with key %s", keyUrl)
+
+	symKey, err := getKek(ctx, keyUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := aesEncrypt(symKey, keyP.KeyWrapParams.OptsData)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while encrypting key")
+	}
+
+	annotation, err := json.Marshal(ocicryptKeyprovider.AnnotationPacket{
+		KeyUrl:     keyUrl,
+		WrappedKey: wrappedKey,
+		WrapType:   wrapTypeAES,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while serializing annotation packet")
+	}
+
+	keyProviderOutput := keyprovider.KeyProviderKeyWrapProtocolOutput{
+		KeyWrapResults: keyprovider.KeyWrapResults{Annotation: annotation},
+	}
+	serializedKeyProviderOutput, err := json.Marshal(keyProviderOutput)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while serializing KeyProviderKeyWrapProtocolOutput")
+	}
+	return &keyproviderpb.KeyProviderKeyWrapProtocolOutput{KeyProviderKeyWrapProtocolOutput: serializedKeyProviderOutput}, nil
+}
 
-	return nil, nil
+// getKeyUrl returns the key URL given in the encryption parameters of the key provider. The parameters are
+// keyed by the key provider name configured in ocicrypt, so all of them are searched
+func getKeyUrl(params keyprovider.KeyWrapParams) (string, error) {
+	if params.Ec == nil {
+		return "", errors.New("Encryption parameters are missing")
+	}
+	keyUrl := ""
+	for _, values := range params.Ec.Parameters {
+		for _, value := range values {
+			param := string(value)
+			if !strings.HasPrefix(param, keyUrlParameter) {
+				continue
+			}
+			url := strings.TrimPrefix(param, keyUrlParameter)
+			if keyUrl != "" && keyUrl != url {
+				return "", errors.New("More than one key URL given in the encryption parameters")
+			}
+			keyUrl = url
+		}
+	}
+	if keyUrl == "" {
+		return "", errors.Errorf("Key URL not given in the encryption parameters, expected %s<key URL>", keyUrlParameter)
+	}
+	return keyUrl, nil
 }
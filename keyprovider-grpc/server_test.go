@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package keyprovider_grpc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/containers/ocicrypt/config"
+	"github.com/containers/ocicrypt/keywrap/keyprovider"
+	keyproviderpb "github.com/containers/ocicrypt/utils/keyprovider"
+	ocicryptKeyprovider "github.com/intel-secl/intel-secl/v4/pkg/model/ocicrypt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const testKeyUrl = "https://kbs.example.com:9443/kbs/v1/keys/5b0a0e0b-1b33-4c4a-8a4b-2bd0dbd4bd52/transfer"
+
+func stubKek() func() {
+	kek := make([]byte, 32)
+	for i := range kek {
+		kek[i] = byte(i)
+	}
+	orig := getKek
+	getKek = func(ctx context.Context, keyUrl string) ([]byte, error) {
+		if keyUrl != testKeyUrl {
+			return nil, errors.New("Error while retrieving wrapped kek")
+		}
+		return kek, nil
+	}
+	return func() { getKek = orig }
+}
+
+func wrapInput(t *testing.T, params map[string][][]byte, optsData []byte) *keyproviderpb.KeyProviderKeyWrapProtocolInput {
+	input, err := json.Marshal(keyprovider.KeyProviderKeyWrapProtocolInput{
+		Operation: keyprovider.OpKeyWrap,
+		KeyWrapParams: keyprovider.KeyWrapParams{
+			Ec:       &config.EncryptConfig{Parameters: params},
+			OptsData: optsData,
+		},
+	})
+	assert.NoError(t, err)
+	return &keyproviderpb.KeyProviderKeyWrapProtocolInput{KeyProviderKeyWrapProtocolInput: input}
+}
+
+func TestWrapKeyRoundTrip(t *testing.T) {
+	defer stubKek()()
+	optsData := []byte(`{"symkey":"c2VjcmV0","cipher":"AES_256_CTR_HMAC_SHA256"}`)
+
+	s := &GRPCServer{}
+	wrapped, err := s.WrapKey(context.Background(), wrapInput(t, map[string][][]byte{
+		"isecl": {[]byte("key-url:" + testKeyUrl)},
+	}, optsData))
+	assert.NoError(t, err)
+
+	var wrapOutput keyprovider.KeyProviderKeyWrapProtocolOutput
+	assert.NoError(t, json.Unmarshal(wrapped.KeyProviderKeyWrapProtocolOutput, &wrapOutput))
+	var packet ocicryptKeyprovider.AnnotationPacket
+	assert.NoError(t, json.Unmarshal(wrapOutput.KeyWrapResults.Annotation, &packet))
+	assert.Equal(t, testKeyUrl, packet.KeyUrl)
+	assert.Equal(t, "AES", packet.WrapType)
+	assert.NotContains(t, string(packet.WrappedKey), "c2VjcmV0")
+
+	unwrapInput, err := json.Marshal(keyprovider.KeyProviderKeyWrapProtocolInput{
+		Operation:       keyprovider.OpKeyUnwrap,
+		KeyUnwrapParams: keyprovider.KeyUnwrapParams{Annotation: wrapOutput.KeyWrapResults.Annotation},
+	})
+	assert.NoError(t, err)
+	unwrapped, err := s.UnWrapKey(context.Background(), &keyproviderpb.KeyProviderKeyWrapProtocolInput{KeyProviderKeyWrapProtocolInput: unwrapInput})
+	assert.NoError(t, err)
+
+	var unwrapOutput keyprovider.KeyProviderKeyWrapProtocolOutput
+	assert.NoError(t, json.Unmarshal(unwrapped.KeyProviderKeyWrapProtocolOutput, &unwrapOutput))
+	assert.Equal(t, optsData, unwrapOutput.KeyUnwrapResults.OptsData)
+}
+
+func TestWrapKeyInvalidParameters(t *testing.T) {
+	defer stubKek()()
+	s := &GRPCServer{}
+
+	_, err := s.WrapKey(context.Background(), wrapInput(t, map[string][][]byte{"isecl": {[]byte("key-id:1234")}}, []byte("opts")))
+	assert.Error(t, err)
+
+	_, err = s.WrapKey(context.Background(), wrapInput(t, map[string][][]byte{
+		"isecl": {[]byte("key-url:" + testKeyUrl), []byte("key-url:https://kbs.example.com/other")},
+	}, []byte("opts")))
+	assert.Error(t, err)
+
+	_, err = s.WrapKey(context.Background(), wrapInput(t, map[string][][]byte{
+		"isecl": {[]byte("key-url:https://kbs.example.com/unknown")},
+	}, []byte("opts")))
+	assert.Error(t, err)
+}
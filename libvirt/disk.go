@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package libvirt
+
+// const enumerates the disk source types and the disk devices of the domain XML
+const (
+	DiskTypeFile    = "file"
+	DiskTypeBlock   = "block"
+	DiskTypeNetwork = "network"
+	DiskTypeVolume  = "volume"
+
+	DiskDeviceDisk   = "disk"
+	DiskDeviceCDROM  = "cdrom"
+	DiskDeviceFloppy = "floppy"
+	DiskDeviceLUN    = "lun"
+)
+
+// Disk is used to represent a disk tag under devices
+type Disk struct {
+	Type         string        `xml:"type,attr"`
+	Device       string        `xml:"device,attr"`
+	Driver       Driver        `xml:"driver"`
+	Source       Source        `xml:"source"`
+	BackingStore *BackingStore `xml:"backingStore"`
+	Target       Target        `xml:"target"`
+	ReadOnly     *struct{}     `xml:"readonly"`
+}
+
+// Driver is used to represent the driver tag of a disk, Type is the format of the disk image
+type Driver struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// Target is used to represent the target tag of a disk, the device name the guest sees
+type Target struct {
+	Dev string `xml:"dev,attr"`
+	Bus string `xml:"bus,attr"`
+}
+
+// Format is used to represent the format tag of a backing store
+type Format struct {
+	Type string `xml:"type,attr"`
+}
+
+// BackingStore is used to represent a backingStore tag. The backing chain of a disk is a list of nested
+// backing stores, terminated by an empty backingStore tag
+type BackingStore struct {
+	Type         string        `xml:"type,attr"`
+	Format       Format        `xml:"format"`
+	Source       Source        `xml:"source"`
+	BackingStore *BackingStore `xml:"backingStore"`
+}
+
+// Path returns the path of the source, the file of a file source, the device of a block source,
+// protocol:name of a network source and pool/volume of a volume source
+func (s Source) Path() string {
+	switch {
+	case s.File != "":
+		return s.File
+	case s.Dev != "":
+		return s.Dev
+	case s.Name != "":
+		return s.Protocol + ":" + s.Name
+	case s.Volume != "":
+		return s.Pool + "/" + s.Volume
+	}
+	return ""
+}
+
+// Path returns the path of the disk source
+func (d Disk) Path() string {
+	return d.Source.Path()
+}
+
+// IsWritable returns true if the disk is a read-write disk of the VM, as opposed to a CD-ROM, a floppy
+// or a read only disk such as a config drive
+func (d Disk) IsWritable() bool {
+	return (d.Device == "" || d.Device == DiskDeviceDisk) && d.ReadOnly == nil
+}
+
+// BackingChain returns the backing stores of the disk, from its direct backing file to the base image
+func (d Disk) BackingChain() []BackingStore {
+	var chain []BackingStore
+	for backingStore := d.BackingStore; backingStore != nil && backingStore.Source.Path() != ""; backingStore = backingStore.BackingStore {
+		chain = append(chain, *backingStore)
+	}
+	return chain
+}
+
+// BackingFile returns the path of the direct backing file of the disk, or "" if the domain XML has none
+func (d Disk) BackingFile() string {
+	if chain := d.BackingChain(); len(chain) > 0 {
+		return chain[0].Source.Path()
+	}
+	return ""
+}
@@ -25,14 +25,18 @@ const (
 	Stop
 )
 
+// ISecLMetadataNamespace is the namespace of the image element under metadata, which names the image of a VM
+// that is not defined by Nova. It is repeated in the tag of Domain.Image.
+const ISecLMetadataNamespace = "http://intel.com/xmlns/libvirt/isecl/1.0"
+
 // Domain is used to represent root of domain xml
 type Domain struct {
-	XMLName            xml.Name `xml:"domain"`
-	UUID               string   `xml:"uuid"`
-	Root               Root     `xml:"metadata>instance>root"`
-	Disk               int      `xml:"metadata>instance>flavor>disk"`
-	Source             Source   `xml:"devices>disk>source"`
-	BackingStoreSource Source   `xml:"devices>disk>backingStore>source"`
+	XMLName xml.Name `xml:"domain"`
+	UUID    string   `xml:"uuid"`
+	Root    Root     `xml:"metadata>instance>root"`
+	Image   Image    `xml:"http://intel.com/xmlns/libvirt/isecl/1.0 metadata>image"`
+	Disk    int      `xml:"metadata>instance>flavor>disk"`
+	Disks   []Disk   `xml:"devices>disk"`
 }
 
 // Root is used to represent root tag under metadata
@@ -41,11 +45,21 @@ type Root struct {
 	UUID    string   `xml:"uuid,attr"`
 }
 
-// Source is used to represent source tags under devices
+// Image is used to represent the image tag in the ISecLMetadataNamespace under metadata
+type Image struct {
+	XMLName xml.Name `xml:"image"`
+	UUID    string   `xml:"uuid,attr"`
+}
+
+// Source is used to represent the source tag of a disk or of a backing store
 type Source struct {
-	XMLName xml.Name `xml:"source"`
-	File    string   `xml:"file,attr"`
-	Dev     string   `xml:"dev,attr"`
+	XMLName  xml.Name `xml:"source"`
+	File     string   `xml:"file,attr"`
+	Dev      string   `xml:"dev,attr"`
+	Protocol string   `xml:"protocol,attr"`
+	Name     string   `xml:"name,attr"`
+	Pool     string   `xml:"pool,attr"`
+	Volume   string   `xml:"volume,attr"`
 }
 
 // DomainParser is used to set the XML content, qemu intercept call and all the values
@@ -58,6 +72,7 @@ type DomainParser struct {
 	imageUUID         string
 	imagePath         string
 	size              int
+	disks             []Disk
 }
 
 // NewDomainParser method is used to get the DomainParser struct values
@@ -79,22 +94,50 @@ func NewDomainParser(domainXML string, qemuInterceptCall QemuIntercept) (*Domain
 	log.Info("libvirt/parse_domain_xml:NewDomainParser() Successfully parsed domain xml")
 	d.vmUUID = domain.UUID
 
-	d.vmPath = domain.Source.File
-
+	// Nova names the image in its own metadata, other tools in the metadata of the agent
 	d.imageUUID = domain.Root.UUID
-
-	if d.qemuInterceptCall == Prepare || d.qemuInterceptCall == Start {
-		d.imagePath = domain.BackingStoreSource.File
-		if d.imagePath == "" {
-			d.imagePath = domain.BackingStoreSource.Dev
-		}
+	if d.imageUUID == "" {
+		d.imageUUID = domain.Image.UUID
 	}
 
 	d.size = domain.Disk
 
+	// the VM path and the image path are taken from the first read-write disk until the caller picks
+	// the disk backed by the image
+	d.disks = domain.Disks
+	for _, disk := range d.disks {
+		if disk.IsWritable() && disk.Path() != "" {
+			d.UseDisk(disk)
+			break
+		}
+	}
+
 	return &d, nil
 }
 
+// UseDisk method is used to take the vm path and the image path from disk, the disk of the VM backed by the image
+func (d *DomainParser) UseDisk(disk Disk) {
+	log.Trace("libvirt/parse_domain_xml:UseDisk() Entering")
+	defer log.Trace("libvirt/parse_domain_xml:UseDisk() Leaving")
+	log.Debugf("libvirt/parse_domain_xml:UseDisk() Using disk %s at %s", disk.Target.Dev, disk.Path())
+
+	d.vmPath = disk.Path()
+	d.imagePath = ""
+	if d.qemuInterceptCall == Prepare || d.qemuInterceptCall == Start {
+		d.imagePath = disk.BackingFile()
+	}
+}
+
+// SetImageUUID method is used to set the image UUID of a VM whose domain XML does not name its image
+func (d *DomainParser) SetImageUUID(imageUUID string) {
+	d.imageUUID = imageUUID
+}
+
+// GetDisks method is used to get the disks of the VM, in the order of the domain XML
+func (d *DomainParser) GetDisks() []Disk {
+	return d.disks
+}
+
 // GetVMUUID method is used to get the vm UUID value from the domain XML
 func (d *DomainParser) GetVMUUID() string {
 	log.Trace("libvirt/parse_domain_xml:GetVMUUID() Entering")
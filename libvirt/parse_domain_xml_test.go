@@ -37,3 +37,65 @@ func TestGetItemFromDomainXML(t *testing.T) {
 	size := d.GetDiskSize()
 	assert.Equal(t, size, 1)
 }
+
+func TestDomainDisks(t *testing.T) {
+	domainXMLFileContent, err := ioutil.ReadFile("../test/domain_disks.xml")
+	assert.NoError(t, err)
+
+	d, err := NewDomainParser(string(domainXMLFileContent), Start)
+	assert.NoError(t, err)
+	disks := d.GetDisks()
+	if !assert.Len(t, disks, 5) {
+		return
+	}
+
+	// the config drive is read only, the block volume is the first read-write disk
+	assert.Equal(t, DiskDeviceCDROM, disks[0].Device)
+	assert.False(t, disks[0].IsWritable())
+	assert.Empty(t, disks[0].BackingChain())
+	assert.Equal(t, "/dev/disk/by-id/wwn-0x6001405b5c1a2e3d", d.GetVMPath())
+	assert.Equal(t, "", d.GetImagePath())
+
+	assert.Equal(t, DiskTypeBlock, disks[1].Type)
+	assert.Equal(t, "vdc", disks[1].Target.Dev)
+	assert.Equal(t, DiskTypeNetwork, disks[2].Type)
+	assert.Equal(t, "rbd:volumes/volume-6b1f0a44", disks[2].Path())
+
+	root := disks[4]
+	assert.Equal(t, "vda", root.Target.Dev)
+	assert.Equal(t, "qcow2", root.Driver.Type)
+	chain := root.BackingChain()
+	if assert.Len(t, chain, 2) {
+		assert.Equal(t, "qcow2", chain[0].Format.Type)
+		assert.Equal(t, "/var/lib/nova/instances/_base/dbee5739d526f9b742b8c7d4d829097965f4f718", chain[1].Source.Path())
+	}
+
+	d.UseDisk(root)
+	assert.Equal(t, "/var/lib/nova/instances/4c9c1c42-7d39-4d41-8a8c-41e4b0a0e0a1/disk", d.GetVMPath())
+	assert.Equal(t, "/mnt/workload-agent/crypto/31ab5921-24fd-498c-8c9e-b20f61004fc0/31ab5921-24fd-498c-8c9e-b20f61004fc0", d.GetImagePath())
+}
+
+func TestISecLImageMetadata(t *testing.T) {
+	domainXML := `<domain type='kvm'>
+  <uuid>4c9c1c42-7d39-4d41-8a8c-41e4b0a0e0a1</uuid>
+  <metadata>
+    <libosinfo:libosinfo xmlns:libosinfo="http://libosinfo.org/xmlns/libvirt/domain/1.0">
+      <libosinfo:os id="http://redhat.com/rhel/8.4"/>
+    </libosinfo:libosinfo>
+    <other:image xmlns:other="http://example.com/other" uuid="00000000-0000-0000-0000-000000000000"/>
+    <isecl:image xmlns:isecl="` + ISecLMetadataNamespace + `" uuid="31ab5921-24fd-498c-8c9e-b20f61004fc0"/>
+  </metadata>
+  <devices>
+    <disk type='file' device='disk'>
+      <source file='/var/lib/libvirt/images/vm.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+  </devices>
+</domain>`
+
+	d, err := NewDomainParser(domainXML, Prepare)
+	assert.NoError(t, err)
+	assert.Equal(t, "31ab5921-24fd-498c-8c9e-b20f61004fc0", d.GetImageUUID())
+	assert.Equal(t, "/var/lib/libvirt/images/vm.qcow2", d.GetVMPath())
+	assert.Equal(t, 0, d.GetDiskSize())
+}
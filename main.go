@@ -5,10 +5,16 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	keyproviderpb "github.com/containers/ocicrypt/utils/keyprovider"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"intel/isecl/lib/common/v4/exec"
 	cLog "intel/isecl/lib/common/v4/log"
 	"intel/isecl/lib/common/v4/log/message"
@@ -19,16 +25,22 @@ import (
 	"intel/isecl/wlagent/v4/config"
 	"intel/isecl/wlagent/v4/consts"
 	"intel/isecl/wlagent/v4/filewatch"
+	"intel/isecl/wlagent/v4/flavor"
+	"intel/isecl/wlagent/v4/health"
 	kpgrpc "intel/isecl/wlagent/v4/keyprovider-grpc"
+	"intel/isecl/wlagent/v4/metrics"
 	wlrpc "intel/isecl/wlagent/v4/rpc"
+	"intel/isecl/wlagent/v4/rpc/vmv1"
 	"intel/isecl/wlagent/v4/setup"
 	"intel/isecl/wlagent/v4/util"
+	"intel/isecl/wlagent/v4/wlavm"
+	"math"
 	"net"
 	"net/rpc"
 	"os"
-	"os/signal"
+	"sort"
 	"strings"
-	"syscall"
+	"text/tabwriter"
 	"time"
 )
 
@@ -43,6 +55,9 @@ var (
 	log, secLog       *logrus.Entry
 )
 
+// healthCheckTimeout bounds the time the health command waits for the agent to answer
+const healthCheckTimeout = 15 * time.Second
+
 func init() {
 	log = cLog.GetDefaultLogger()
 	secLog = cLog.GetSecurityLogger()
@@ -68,7 +83,20 @@ func printUsage() {
 	fmt.Printf("    start                  Start wlagent\n")
 	fmt.Printf("    stop                   Stop wlagent\n")
 	fmt.Printf("    status                 Reports the status of wlagent service\n")
+	fmt.Printf("    health                 Reports the liveness of the wlagent daemon as JSON, exits with 1 if not healthy\n")
+	fmt.Printf("                           - Option [--readiness] also checks the TPM, the keys, the CA certificates and that WLS and AAS are reachable\n")
+	fmt.Printf("                           - Option [--grpc] queries the standard gRPC health service instead of the net/rpc one\n")
 	fmt.Printf("    uninstall  [--purge]   Uninstall wlagent. --purge option needs to be applied to remove configuration and secureoverlay2 data files\n")
+	fmt.Printf("    reconcile              Close the dm-crypt volumes not used by any running VM and rebuild the image vm counts\n")
+	fmt.Printf("                           - Option [--dry-run] only reports what would be changed\n")
+	fmt.Printf("    cleanup --vm <UUID>|--image <UUID>\n")
+	fmt.Printf("                           Unmount and close the volume of a deleted VM or of an image, delete its sparse file and correct the image vm counts\n")
+	fmt.Printf("                           - Option [--dry-run] only reports what would be changed\n")
+	fmt.Printf("    vm list                List the VMs and the decrypted images managed by the wlagent daemon\n")
+	fmt.Printf("    vm show <UUID>         Show the details of a VM or of an image managed by the wlagent daemon\n")
+	fmt.Printf("                           - Option [--json] prints the output as JSON\n")
+	fmt.Printf("    purge-flavor-cache     Remove the cached image flavors\n")
+	fmt.Printf("                           - Option [image UUID] removes only the flavors cached for the given image\n")
 	fmt.Printf("    setup [task]           Run setup task\n")
 	fmt.Printf("Available Tasks for setup:\n")
 	fmt.Printf("    download_ca_cert       Download CMS root CA certificate\n")
@@ -94,6 +122,12 @@ func printUsage() {
 	fmt.Printf("                           - Environment variable WLA_SERVICE_USERNAME WLA Service Username\n")
 	fmt.Printf("                           - Environment variable WLA_SERVICE_PASSWORD WLA Service Password\n")
 	fmt.Printf("                           - Environment variable SKIP_FLAVOR_SIGNATURE_VERIFICATION=<true/false> Skip flavor signature verification if set to true\n")
+	fmt.Printf("                           - Environment variable FLAVOR_CACHE_TTL=<seconds> Time image flavors are cached for, 0 disables the cache\n")
+	fmt.Printf("                           - Environment variable METRICS_LISTEN_ADDRESS=<unix:/path or 127.0.0.1:port> Serve Prometheus metrics on this local address\n")
+	fmt.Printf("                           - Environment variable VM_PREPARE_TIMEOUT=<seconds> Timeout of the VM prepare hook, default 600\n")
+	fmt.Printf("                           - Environment variable VM_START_TIMEOUT=<seconds> Timeout of the VM start hook, default 120\n")
+	fmt.Printf("                           - Environment variable VM_STOP_TIMEOUT=<seconds> Timeout of the VM stop hook, default 120\n")
+	fmt.Printf("                           - Environment variable WLA_SOCKET_GROUP=<group name or ID> Group allowed to connect to the agent socket\n")
 	fmt.Printf("                           - Environment variable LOG_ENTRY_MAXLENGTH=Maximum length of each entry in a log\n")
 	fmt.Printf("                           - Environment variable WLA_ENABLE_CONSOLE_LOG=<true/false> Workload Agent Enable standard output\n")
 }
@@ -217,8 +251,10 @@ func main() {
 		runservice()
 
 	case "rungrpcservice":
+		// the daemon serves the gRPC key provider along with the VM service, this command is kept
+		// for the service files and container images that run it
 		config.LogConfiguration(config.Configuration.LogEnableStdout)
-		runGRPCService()
+		runservice()
 
 	case "start":
 		start()
@@ -239,6 +275,31 @@ func main() {
 			fmt.Println(stderr)
 		}
 
+	case "health":
+		readiness, useGRPC := false, false
+		for _, flag := range args[1:] {
+			switch flag {
+			case "--readiness":
+				readiness = true
+			case "--grpc":
+				useGRPC = true
+			default:
+				fmt.Fprintln(os.Stderr, "Unrecognized option for health:", flag)
+				printUsage()
+				os.Exit(1)
+			}
+		}
+		var report health.Report
+		if useGRPC {
+			report = checkGRPCHealth(readiness)
+		} else {
+			report = checkHealth(readiness)
+		}
+		fmt.Println(report.JSON())
+		if report.Status != health.StatusPass {
+			os.Exit(1)
+		}
+
 	case "start-vm":
 		config.LogConfiguration(config.Configuration.LogEnableStdout)
 		if len(args[1:]) < 1 {
@@ -266,17 +327,13 @@ func main() {
 		var args = wlrpc.DomainXML{
 			XML: args[1],
 		}
-		var startState bool
-		err = client.Call("VirtualMachine.Start", &args, &startState)
+		startResult, err := callVMMethod(client, "Start", wlavm.PhaseStart, &args)
 		if err != nil {
 			log.Error("main:main() start-vm: Client call failed")
-		}
-
-		if !startState {
+			fmt.Fprintln(os.Stderr, "wlagent start-vm: RPC call to wlagent service failed:", err)
 			os.Exit(1)
-		} else {
-			os.Exit(0)
 		}
+		exitWithVMResult("start-vm", startResult)
 
 	case "prepare-vm":
 		config.LogConfiguration(config.Configuration.LogEnableStdout)
@@ -305,17 +362,13 @@ func main() {
 		var args = wlrpc.DomainXML{
 			XML: args[1],
 		}
-		var prepareState bool
-		err = client.Call("VirtualMachine.Prepare", &args, &prepareState)
+		prepareResult, err := callVMMethod(client, "Prepare", wlavm.PhasePrepare, &args)
 		if err != nil {
 			log.Error("main:main() prepare-vm: Client call failed")
-		}
-
-		if !prepareState {
+			fmt.Fprintln(os.Stderr, "wlagent prepare-vm: RPC call to wlagent service failed:", err)
 			os.Exit(1)
-		} else {
-			os.Exit(0)
 		}
+		exitWithVMResult("prepare-vm", prepareResult)
 
 	case "stop-vm":
 		config.LogConfiguration(config.Configuration.LogEnableStdout)
@@ -342,18 +395,151 @@ func main() {
 		var args = wlrpc.DomainXML{
 			XML: args[1],
 		}
-		var stopState bool
-		err = client.Call("VirtualMachine.Stop", &args, &stopState)
+		stopResult, err := callVMMethod(client, "Stop", wlavm.PhaseStop, &args)
 		if err != nil {
 			log.Error("main:main() stop-vm: Client call failed")
+			fmt.Fprintln(os.Stderr, "wlagent stop-vm: RPC call to wlagent service failed:", err)
+			os.Exit(1)
+		}
+		exitWithVMResult("stop-vm", stopResult)
+
+	case "reconcile":
+		config.LogConfiguration(config.Configuration.LogEnableStdout)
+		secLog.Info("main:main() reconcile: wlagent reconcile called")
+		conn, err := net.Dial("unix", rpcSocketFilePath)
+		if err != nil {
+			secLog.Errorf("main:main() reconcile: Failed to dial wlagent.sock, %s", message.BadConnection)
+			fmt.Fprintln(os.Stderr, "wlagent reconcile: wlagent service is not reachable:", err)
+			os.Exit(1)
+		}
+		defer conn.Close()
+
+		client := rpc.NewClient(conn)
+		defer client.Close()
+		var reconcileArgs = wlrpc.ReconcileArgs{
+			DryRun: len(args) > 1 && args[1] == "--dry-run",
+		}
+		var report wlavm.ReconcileReport
+		err = client.Call("VirtualMachine.Reconcile", &reconcileArgs, &report)
+		if err != nil {
+			log.WithError(err).Error("main:main() reconcile: Client call failed")
+			fmt.Fprintln(os.Stderr, "wlagent reconcile:", err)
+			os.Exit(1)
+		}
+		printReconcileReport(report)
+		if len(report.ReconcileErrors) > 0 {
+			os.Exit(1)
+		}
+
+	case "cleanup":
+		config.LogConfiguration(config.Configuration.LogEnableStdout)
+		var cleanupArgs wlrpc.CleanupArgs
+		validArgs := true
+		for i := 1; i < len(args); i++ {
+			switch {
+			case args[i] == "--dry-run":
+				cleanupArgs.DryRun = true
+			case (args[i] == "--vm" || args[i] == "--image") && i+1 < len(args) && cleanupArgs.UUID == "":
+				cleanupArgs.Kind = wlavm.VolumeKind(strings.TrimPrefix(args[i], "--"))
+				cleanupArgs.UUID = args[i+1]
+				i++
+			default:
+				validArgs = false
+			}
+		}
+		if !validArgs || cleanupArgs.UUID == "" {
+			fmt.Fprintln(os.Stderr, "Usage: wlagent cleanup --vm <VM UUID>|--image <image UUID> [--dry-run]")
+			os.Exit(1)
+		}
+		secLog.Infof("main:main() cleanup: wlagent cleanup called for %s %s", cleanupArgs.Kind, cleanupArgs.UUID)
+		conn, err := net.Dial("unix", rpcSocketFilePath)
+		if err != nil {
+			secLog.Errorf("main:main() cleanup: Failed to dial wlagent.sock, %s", message.BadConnection)
+			fmt.Fprintln(os.Stderr, "wlagent cleanup: wlagent service is not reachable:", err)
+			os.Exit(1)
+		}
+		defer conn.Close()
+
+		client := rpc.NewClient(conn)
+		defer client.Close()
+		var report wlavm.CleanupReport
+		err = client.Call("VirtualMachine.Cleanup", &cleanupArgs, &report)
+		if err != nil {
+			log.WithError(err).Error("main:main() cleanup: Client call failed")
+			fmt.Fprintln(os.Stderr, "wlagent cleanup:", err)
+			os.Exit(1)
+		}
+		printCleanupReport(report)
+		if len(report.CleanupErrors) > 0 {
+			os.Exit(1)
+		}
+
+	case "vm":
+		config.LogConfiguration(config.Configuration.LogEnableStdout)
+		var vmArgs []string
+		jsonOutput := false
+		for _, vmArg := range args[1:] {
+			if vmArg == "--json" {
+				jsonOutput = true
+			} else {
+				vmArgs = append(vmArgs, vmArg)
+			}
+		}
+		if len(vmArgs) == 0 || !(vmArgs[0] == "list" && len(vmArgs) == 1 ||
+			(vmArgs[0] == "show" || vmArgs[0] == "inspect") && len(vmArgs) == 2) {
+			fmt.Fprintln(os.Stderr, "Usage: wlagent vm list [--json] | wlagent vm show|inspect <VM or image UUID> [--json]")
 			os.Exit(1)
 		}
 
-		if !stopState {
+		inventory, err := getInventory()
+		if err != nil {
+			log.WithError(err).Error("main:main() vm: Error getting the inventory from wlagent")
+			fmt.Fprintln(os.Stderr, "wlagent vm:", err)
+			os.Exit(1)
+		}
+		if vmArgs[0] == "list" {
+			if jsonOutput {
+				printJSON(inventory)
+			} else {
+				printInventory(inventory)
+			}
+			break
+		}
+
+		var entry interface{}
+		for _, vm := range inventory.VMs {
+			if strings.EqualFold(vm.VMUUID, vmArgs[1]) {
+				entry = vm
+			}
+		}
+		for _, image := range inventory.Images {
+			if entry == nil && strings.EqualFold(image.ImageUUID, vmArgs[1]) {
+				entry = image
+			}
+		}
+		if entry == nil {
+			fmt.Fprintf(os.Stderr, "wlagent vm: no VM or image %s managed by wlagent\n", vmArgs[1])
 			os.Exit(1)
+		}
+		if jsonOutput {
+			printJSON(entry)
 		} else {
-			os.Exit(0)
+			printInventoryEntry(entry)
+		}
+
+	case "purge-flavor-cache":
+		config.LogConfiguration(config.Configuration.LogEnableStdout)
+		imageUUID := ""
+		if len(args) > 1 {
+			imageUUID = args[1]
 		}
+		err := flavor.PurgeCache(imageUUID)
+		if err != nil {
+			log.WithError(err).Error("main:main() purge-flavor-cache: Error purging the flavor cache")
+			fmt.Fprintln(os.Stderr, "Error purging the flavor cache:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Flavor cache purged")
 
 	case "uninstall":
 		config.LogConfiguration(false)
@@ -382,6 +568,230 @@ func main() {
 	}
 }
 
+// callVMMethod calls the VirtualMachine method replying with a VMResult, or the method of the same operation replying
+// with a bool when the service still running is from an earlier release
+func callVMMethod(client *rpc.Client, method string, phase wlavm.Phase, args *wlrpc.DomainXML) (wlrpc.VMResult, error) {
+	var result wlrpc.VMResult
+	err := client.Call("VirtualMachine."+method+"VM", args, &result)
+	if err == nil || !strings.HasPrefix(err.Error(), "rpc: can't find method") {
+		return result, err
+	}
+	var success bool
+	err = client.Call("VirtualMachine."+method, args, &success)
+	if err != nil || success {
+		return wlrpc.VMResult{Success: success, Phase: string(phase)}, err
+	}
+	return wlrpc.VMResult{Phase: string(phase), Code: string(wlavm.InternalError),
+		Message: "the wlagent service did not report the reason, restart it to run the upgraded release"}, nil
+}
+
+// exitWithVMResult prints the reason of a failed VM lifecycle operation to stderr, so that it
+// is shown by libvirt as the hook failure reason, and exits with the corresponding status
+func exitWithVMResult(cmd string, result wlrpc.VMResult) {
+	if !result.Success {
+		log.Errorf("main:exitWithVMResult() %s: %s", cmd, result.String())
+		fmt.Fprintf(os.Stderr, "wlagent %s: %s\n", cmd, result.String())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// registerVolumeMetrics exports the number of open dm-crypt volumes and mounted images, counted when the metrics are collected
+func registerVolumeMetrics() {
+	volumeCount := func(mountedImages bool) func() float64 {
+		return func() float64 {
+			volumes, images, err := wlavm.CountOpenVolumes()
+			if err != nil {
+				log.WithError(err).Warn("main:registerVolumeMetrics() Could not count the open volumes")
+				return math.NaN()
+			}
+			if mountedImages {
+				return float64(images)
+			}
+			return float64(volumes)
+		}
+	}
+	err := metrics.RegisterGaugeFunc("open_dmcrypt_volumes", "Number of dm-crypt volumes opened by the agent", volumeCount(false))
+	if err == nil {
+		err = metrics.RegisterGaugeFunc("mounted_images", "Number of decrypted images mounted by the agent", volumeCount(true))
+	}
+	if err != nil {
+		log.WithError(err).Error("main:registerVolumeMetrics() Could not register the volume metrics")
+	}
+}
+
+// checkHealth gets the health report of the agent over net/rpc
+func checkHealth(readiness bool) health.Report {
+	conn, err := net.DialTimeout("unix", rpcSocketFilePath, healthCheckTimeout)
+	if err != nil {
+		return health.Failed(health.CheckSocket, err)
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(healthCheckTimeout)); err != nil {
+		return health.Failed(health.CheckSocket, err)
+	}
+
+	client := rpc.NewClient(conn)
+	defer client.Close()
+	var report health.Report
+	err = client.Call("Health.Check", &wlrpc.HealthArgs{Readiness: readiness}, &report)
+	if err != nil {
+		return health.Failed(health.CheckSocket, err)
+	}
+	return report
+}
+
+// dialGRPC connects to the gRPC services of the agent on its socket
+func dialGRPC(ctx context.Context) (*grpc.ClientConn, error) {
+	return grpc.DialContext(ctx, rpcSocketFilePath, grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", address)
+		}))
+}
+
+// checkGRPCHealth gets the health report of the agent from the standard gRPC health service
+func checkGRPCHealth(readiness bool) health.Report {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	conn, err := dialGRPC(ctx)
+	if err != nil {
+		return health.Failed(health.CheckSocket, err)
+	}
+	defer conn.Close()
+
+	request := &healthpb.HealthCheckRequest{}
+	if readiness {
+		request.Service = health.ReadinessService
+	}
+	var header metadata.MD
+	response, err := healthpb.NewHealthClient(conn).Check(ctx, request, grpc.Header(&header))
+	if err != nil {
+		return health.Failed(health.CheckSocket, err)
+	}
+	var report health.Report
+	if values := header.Get(health.ReportHeader); len(values) > 0 && json.Unmarshal([]byte(values[0]), &report) == nil {
+		return report
+	}
+	if response.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return health.Failed(health.CheckSocket, fmt.Errorf("agent health status is %s", response.GetStatus()))
+	}
+	return health.Liveness()
+}
+
+func printReconcileReport(report wlavm.ReconcileReport) {
+	closed, unmounted, corrected := "Closed", "Unmounted", "Corrected"
+	if report.DryRun {
+		closed, unmounted, corrected = "Would close", "Would unmount", "Would correct"
+	}
+	fmt.Printf("Running VMs: %d\n", len(report.RunningVMs))
+	for imageUUID, vmCount := range report.ImageVMCounts {
+		fmt.Printf("Image %s: %d VMs\n", imageUUID, vmCount)
+	}
+	for _, volume := range report.ClosedVolumes {
+		fmt.Printf("%s orphaned volume: %s\n", closed, volume)
+	}
+	for _, mountPoint := range report.UnmountedPaths {
+		fmt.Printf("%s orphaned mount point: %s\n", unmounted, mountPoint)
+	}
+	for _, imageUUID := range report.CorrectedCounts {
+		fmt.Printf("%s vm count of image: %s\n", corrected, imageUUID)
+	}
+	for _, reconcileErr := range report.ReconcileErrors {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", reconcileErr)
+	}
+}
+
+func printCleanupReport(report wlavm.CleanupReport) {
+	unmounted, closed, removed, corrected := "Unmounted", "Closed", "Removed", "Corrected"
+	if report.DryRun {
+		unmounted, closed, removed, corrected = "Would unmount", "Would close", "Would remove", "Would correct"
+	}
+	for _, mountPoint := range report.UnmountedPaths {
+		fmt.Printf("%s mount point: %s\n", unmounted, mountPoint)
+	}
+	for _, volume := range report.ClosedVolumes {
+		fmt.Printf("%s volume: %s\n", closed, volume)
+	}
+	for _, path := range report.RemovedFiles {
+		fmt.Printf("%s: %s\n", removed, path)
+	}
+	for _, imageUUID := range report.CorrectedCounts {
+		fmt.Printf("%s vm count of image: %s\n", corrected, imageUUID)
+	}
+	if len(report.UnmountedPaths)+len(report.ClosedVolumes)+len(report.RemovedFiles)+len(report.CorrectedCounts) == 0 {
+		fmt.Println("Nothing to clean up")
+	}
+	for _, cleanupErr := range report.CleanupErrors {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", cleanupErr)
+	}
+}
+
+// getInventory gets the VMs and the images managed by the agent over gRPC
+func getInventory() (wlavm.Inventory, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	conn, err := dialGRPC(ctx)
+	if err != nil {
+		return wlavm.Inventory{}, errors.Wrap(err, "wlagent service is not reachable")
+	}
+	defer conn.Close()
+
+	response, err := vmv1.NewVirtualMachineClient(conn).Inventory(ctx, &vmv1.InventoryRequest{})
+	if err != nil {
+		return wlavm.Inventory{}, err
+	}
+	return wlrpc.InventoryFromProto(response), nil
+}
+
+func printInventory(inventory wlavm.Inventory) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VM UUID\tIMAGE UUID\tPHASE\tDEVICE\tMOUNT POINT\tSPARSE FILE SIZE\tREPORT POSTED\tKEY ID\tERROR")
+	for _, vm := range inventory.VMs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%t\t%s\t%s\n", vm.VMUUID, vm.ImageUUID, vm.Phase, vm.Device, vm.MountPoint,
+			formatSize(vm.SparseFileAllocated, vm.SparseFileSize), vm.TrustReportPosted, vm.KeyID, vm.Error)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "IMAGE UUID\tVMS\tDEVICE\tMOUNT POINT\tSPARSE FILE SIZE\tKEY ID")
+	for _, image := range inventory.Images {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", image.ImageUUID, image.VMCount, image.Device, image.MountPoint,
+			formatSize(image.SparseFileAllocated, image.SparseFileSize), image.KeyID)
+	}
+	w.Flush()
+}
+
+// printInventoryEntry prints the fields of a VM or of an image, one per line
+func printInventoryEntry(entry interface{}) {
+	content, _ := json.Marshal(entry)
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	// keeps the sizes from being printed as floats
+	decoder.UseNumber()
+	_ = decoder.Decode(&fields)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s:\t%v\n", name, fields[name])
+	}
+	w.Flush()
+}
+
+func printJSON(v interface{}) {
+	content, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(content))
+}
+
+// formatSize returns the space allocated to a sparse file out of its apparent size
+func formatSize(allocated, size int64) string {
+	if size == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f/%.1f GiB", float64(allocated)/(1<<30), float64(size)/(1<<30))
+}
+
 func deleteFile(path string) {
 	log.Trace("main/main:deleteFile() Entering")
 	defer log.Trace("main/main:deleteFile() Leaving")
@@ -436,11 +846,15 @@ func runservice() {
 	log.Trace("main:runservice() Entering")
 	defer log.Trace("main:runservice() Leaving")
 
-	//check if the wlagent run directory path is already created
-	if _, err := os.Stat(consts.RunDirPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(consts.RunDirPath, 0600); err != nil {
-			log.WithError(err).Fatalf("main:runservice() could not create directory: %s, err: %s", consts.RunDirPath, err)
-		}
+	// the run directory holds the socket, set it up before anything is written in it
+	socketGID, err := wlrpc.SocketGroupID(config.Configuration.SocketGroup)
+	if err == nil {
+		err = wlrpc.SetupSocketDir(consts.RunDirPath, socketGID)
+	}
+	if err != nil {
+		log.WithError(err).Error("main:runservice() Could not set up the socket directory")
+		secLog.Error(message.AppRuntimeErr + " Insecure or invalid WLA Unix socket directory")
+		os.Exit(1)
 	}
 
 	loadIVAMapErr := util.LoadImageVMAssociation()
@@ -448,14 +862,25 @@ func runservice() {
 		log.WithError(loadIVAMapErr).Fatal("main:runservice() error loading ImageVMAssociation map")
 	}
 
+	// volumes and vm counts may be out of date after a crash of the agent or of libvirt
+	domainLister := wlavm.VirshDomainLister{}
+	if !domainLister.Available() {
+		log.Info("main:runservice() virsh is not installed, skipping the reconciliation of dm-crypt volumes")
+	} else if _, err := wlavm.Reconcile(domainLister, false); err != nil {
+		log.WithError(err).Error("main:runservice() Error reconciling dm-crypt volumes with the running VMs")
+	}
+
 	// open a connection to TPM
+	util.TpmMtx.Lock()
 	vtpmInstance, err := util.GetTpmInstance()
 	if err != nil {
+		util.TpmMtx.Unlock()
 		log.WithError(err).Error("main:runservice() Could not open a new connection to the TPM")
 		secLog.Info(message.AppRuntimeErr)
 		os.Exit(1)
 	}
 	vtpmInstance.Close()
+	util.TpmMtx.Unlock()
 
 	fileWatcher, err := filewatch.NewWatcher()
 	if err != nil {
@@ -476,57 +901,64 @@ func runservice() {
 		}
 	}()
 
-	if _, err = os.Stat(consts.RunDirPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(consts.RunDirPath, 0600); err != nil {
-			log.WithError(err).Fatalf("main:runservice() Could not create directory: %s, err: %s", consts.RunDirPath, err)
-		}
+	authorizer, err := wlrpc.NewAuthorizer(config.Configuration.PeerAuthorization)
+	if err != nil {
+		log.WithError(err).Error("main:runservice() Invalid peer authorization rules")
+		secLog.Info(message.AppRuntimeErr)
+		os.Exit(1)
 	}
+	log.Infof("main:runservice() Peer authorization rules for methods: %v", authorizer.Methods())
 
-	// Passing the false parameter to ensure that fileWatcher task is not added to the wait group if there is pending signal termination
-	_, err = proc.AddTask(false)
+	l, err := wlrpc.ListenSocket(rpcSocketFilePath, socketGID)
 	if err != nil {
-		log.WithError(err).Fatal("main:runservice() could not add the task for rpc service")
+		log.WithError(err).Error("main:runservice() Failed to listen on the socket")
+		secLog.Error(message.AppRuntimeErr + " Failed to initialize up WLA Unix socket")
+		os.Exit(1)
+	}
+	// the libvirt hook uses net/rpc, the ocicrypt key provider and the versioned VM API use gRPC, all on the same socket
+	mux := wlrpc.NewMux(l)
+
+	vmService := &wlrpc.VMService{
+		Watcher: fileWatcher,
+		Version: fmt.Sprintf("%s-%s", Version, GitHash),
 	}
+	s := grpc.NewServer(
+		grpc.Creds(wlrpc.PeerCredentials()),
+		grpc.UnaryInterceptor(authorizer.UnaryInterceptor),
+		grpc.StreamInterceptor(authorizer.StreamInterceptor),
+	)
+	keyproviderpb.RegisterKeyProviderServiceServer(s, &kpgrpc.GRPCServer{})
+	healthpb.RegisterHealthServer(s, &health.GRPCServer{})
+	vmv1.RegisterVirtualMachineServer(s, vmService)
+
+	// the serving go routines run until the listeners are closed after the quit signal, each
+	// request adds its own task so that WaitForQuitAndCleanup waits for the pending requests
 	go func() {
-		defer proc.TaskDone()
-		RPCSocketFilePath := consts.RunDirPath + consts.RPCSocketFileName
-		// When the socket is closed, the file handle on the socket file isn't handled.
-		// This code is added to manually remove any stale socket file before the connection
-		// is reopened; prevent error: bind address already in use
-		// ensure that the socket file exists before removal
-		if _, err = os.Stat(RPCSocketFilePath); err == nil {
-			err = os.Remove(RPCSocketFilePath)
-			if err != nil {
-				log.WithError(err).Error("main:runservice() Failed to remove socket file")
-			}
+		if err := wlrpc.ServeRPC(mux.RPC(), vmService, domainLister, authorizer); err != nil {
+			log.WithError(err).Debug("main:runservice() net/rpc server stopped")
 		}
-		l, err := net.Listen("unix", RPCSocketFilePath)
-		if err != nil {
-			log.Error(err)
-			secLog.Error(message.AppRuntimeErr + " Failed to initialize up WLA Unix socket")
-			return
+	}()
+	go func() {
+		if err := s.Serve(mux.GRPC()); err != nil {
+			log.WithError(err).Error("main:runservice() gRPC server stopped")
 		}
-		defer l.Close()
-
-		r := rpc.NewServer()
-		vm := &wlrpc.VirtualMachine{
-			Watcher: fileWatcher,
+	}()
+	go func() {
+		log.Trace("main:runservice() Listen and Serve enter")
+		if err := mux.Serve(); err != nil {
+			log.WithError(err).Debug("main:runservice() Listen and Serve exit")
 		}
+	}()
 
-		err = r.Register(vm)
+	if config.Configuration.MetricsListenAddress != "" {
+		registerVolumeMetrics()
+		metricsServer, err := metrics.Serve(config.Configuration.MetricsListenAddress)
 		if err != nil {
-			log.WithError(err).Error("main:runservice() Unable to Register vm watcher")
-			log.Tracef("%+v", err)
-			secLog.Info(message.AppRuntimeErr)
-			return
-		}
-		for {
-			log.Trace("main:runservice() Listen and Serve enter")
-			// block and loop, daemon doesnt need to run on go routine
-			r.Accept(l)
-			log.Trace("main:runservice() Listen and Serve exit")
+			log.WithError(err).Error("main:runservice() Could not start the metrics server")
+		} else {
+			defer metricsServer.Close()
 		}
-	}()
+	}
 	secLog.Info(message.ServiceStart)
 
 	// block until stop channel receives
@@ -534,68 +966,10 @@ func runservice() {
 	if err != nil {
 		log.WithError(err).Error("main:runservice() Error while clean up")
 	}
-	secLog.Info(message.ServiceStop)
-}
-
-func runGRPCService() {
-
-	RPCSocketFilePath := consts.RunDirPath + consts.RPCSocketFileName
-	// When the socket is closed, the file handle on the socket file isn't handled.
-	// This code is added to manually remove any stale socket file before the connection
-	// is reopened; prevent error: bind address already in use
-	// ensure that the socket file exists before removal
-	if _, err := os.Stat(RPCSocketFilePath); err == nil {
-		err = os.Remove(RPCSocketFilePath)
-		if err != nil {
-			log.WithError(err).Error("main:runGRPCService() Failed to remove socket file")
-			os.Exit(1)
-		}
-	}
-
-	if _, err := os.Stat(consts.RunDirPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(consts.RunDirPath, 0600); err != nil {
-			log.WithError(err).Fatalf("main:runGRPCService() Could not create directory: %s, err: %s", consts.RunDirPath, err)
-		}
-	}
-
-	l, err := net.Listen("unix", RPCSocketFilePath)
-	if err != nil {
-		log.Error(err)
-		secLog.Error(message.AppRuntimeErr + " Failed to initialize up WLA Unix socket")
-		return
-	}
-	defer l.Close()
-
-	s := grpc.NewServer()
-	if s == nil {
-		log.Error("Error creating grpc server")
-		os.Exit(1)
-	}
-	keyproviderpb.RegisterKeyProviderServiceServer(s, &kpgrpc.GRPCServer{})
-
-	stop := make(chan os.Signal)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-	_, err = proc.AddTask(false)
-	if err != nil {
-		log.WithError(err).Fatal("main:runGRPCService() could not add the task for rpc service")
-	}
-	// dispatch grpc go routine
-	go func() {
-		defer proc.TaskDone()
-		if err := s.Serve(l); err != nil {
-			log.WithError(err).Fatal("main:runGRPCService() Failed to start GRPC server")
-			stop <- syscall.SIGTERM
-		}
-	}()
-
-	log.Info(message.ServiceStart)
-	// block until stop channel receives
-	err = proc.WaitForQuitAndCleanup(10 * time.Second)
+	s.GracefulStop()
+	err = mux.Close()
 	if err != nil {
-		log.WithError(err).Error("main:runGRPCService() Error while clean up")
+		log.WithError(err).Debug("main:runservice() Error closing the socket")
 	}
-	s.GracefulStop()
-
-	log.Info(message.ServiceStop)
-
+	secLog.Info(message.ServiceStop)
 }
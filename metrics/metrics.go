@@ -0,0 +1,154 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package metrics
+
+import (
+	"errors"
+	cLog "intel/isecl/lib/common/v4/log"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var log = cLog.GetDefaultLogger()
+
+const namespace = "wlagent"
+
+// Services called by the agent, used as the service label of the service request metrics
+const (
+	WLS = "wls"
+	HVS = "hvs"
+)
+
+// Results used as the result label
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	vmOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "vm_operations_total",
+		Help:      "Number of VM prepare, start and stop operations by result, the result is the error code of failed operations",
+	}, []string{"operation", "result"})
+
+	vmOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "vm_operation_duration_seconds",
+		Help:      "Duration of VM prepare, start and stop operations, including image decryption",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1200},
+	}, []string{"operation"})
+
+	serviceRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "service_request_duration_seconds",
+		Help:      "Duration of requests to the workload and host verification services",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "operation"})
+
+	serviceRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "service_request_errors_total",
+		Help:      "Number of failed requests to the workload and host verification services by HTTP status code",
+	}, []string{"service", "operation", "code"})
+
+	tpmOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tpm_operation_duration_seconds",
+		Help:      "Duration of TPM unbind and sign operations",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+
+	keyUnwrapRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "keyprovider_unwrapkey_requests_total",
+		Help:      "Number of UnWrapKey requests served by the gRPC key provider by result",
+	}, []string{"result"})
+
+	keyWrapRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "keyprovider_wrapkey_requests_total",
+		Help:      "Number of WrapKey requests served by the gRPC key provider by result",
+	}, []string{"result"})
+)
+
+func init() {
+	registry.MustRegister(
+		vmOperations,
+		vmOperationDuration,
+		serviceRequestDuration,
+		serviceRequestErrors,
+		tpmOperationDuration,
+		keyUnwrapRequests,
+		keyWrapRequests,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+// ObserveVMOperation records the result and the duration of a VM lifecycle operation started at start
+func ObserveVMOperation(operation, result string, start time.Time) {
+	vmOperations.WithLabelValues(operation, result).Inc()
+	vmOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveServiceRequest records the duration of a request to service started at start, and its error code if it failed
+func ObserveServiceRequest(service, operation string, start time.Time, err error) {
+	serviceRequestDuration.WithLabelValues(service, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		serviceRequestErrors.WithLabelValues(service, operation, errorCode(err)).Inc()
+	}
+}
+
+// ObserveTPMOperation records the duration and the result of a TPM operation started at start
+func ObserveTPMOperation(operation string, start time.Time, err error) {
+	tpmOperationDuration.WithLabelValues(operation, result(err)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveKeyUnwrap records the result of an UnWrapKey request
+func ObserveKeyUnwrap(err error) {
+	keyUnwrapRequests.WithLabelValues(result(err)).Inc()
+}
+
+// ObserveKeyWrap records the result of a WrapKey request
+func ObserveKeyWrap(err error) {
+	keyWrapRequests.WithLabelValues(result(err)).Inc()
+}
+
+// RegisterGaugeFunc exports the value returned by fn when the metrics are collected
+func RegisterGaugeFunc(name, help string, fn func() float64) error {
+	return registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+func result(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+	return ResultSuccess
+}
+
+// StatusCodeError is implemented by the errors of the service clients that carry the HTTP status code of a failed response
+type StatusCodeError interface {
+	error
+	HTTPStatusCode() int
+}
+
+// errorCode returns the HTTP status code carried by the error of a service client. Errors without a status code,
+// such as connection failures, are reported as "error"
+func errorCode(err error) string {
+	var statusErr StatusCodeError
+	if errors.As(err, &statusErr) {
+		return strconv.Itoa(statusErr.HTTPStatusCode())
+	}
+	return "error"
+}
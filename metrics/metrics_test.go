@@ -0,0 +1,56 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package metrics
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateListenAddress(t *testing.T) {
+	assert.NoError(t, ValidateListenAddress("unix:/var/run/workload-agent/metrics.sock"))
+	assert.NoError(t, ValidateListenAddress("127.0.0.1:9100"))
+	assert.NoError(t, ValidateListenAddress("[::1]:9100"))
+	assert.NoError(t, ValidateListenAddress("localhost:9100"))
+
+	assert.Error(t, ValidateListenAddress("unix:"))
+	assert.Error(t, ValidateListenAddress("0.0.0.0:9100"))
+	assert.Error(t, ValidateListenAddress(":9100"))
+	assert.Error(t, ValidateListenAddress("10.1.2.3:9100"))
+}
+
+type testStatusError int
+
+func (e testStatusError) Error() string {
+	return "HTTP Status :" + strconv.Itoa(int(e))
+}
+
+func (e testStatusError) HTTPStatusCode() int {
+	return int(e)
+}
+
+func TestObserveServiceRequestErrorCode(t *testing.T) {
+	ObserveServiceRequest(WLS, "GetImageFlavorKey", time.Now(), errors.Wrap(testStatusError(401), "Error while retrieving Flavor-Key"))
+	// a number in the message of an error without a status code is not taken for one
+	ObserveServiceRequest(WLS, "GetImageFlavorKey", time.Now(), errors.New("dial tcp 10.0.0.200:5000: connection refused"))
+	ObserveServiceRequest(WLS, "GetImageFlavorKey", time.Now(), nil)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(serviceRequestErrors.WithLabelValues(WLS, "GetImageFlavorKey", "401")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(serviceRequestErrors.WithLabelValues(WLS, "GetImageFlavorKey", "error")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(serviceRequestErrors.WithLabelValues(WLS, "GetImageFlavorKey", "200")))
+}
+
+func TestObserveVMOperation(t *testing.T) {
+	ObserveVMOperation("prepare", ResultSuccess, time.Now())
+	ObserveVMOperation("prepare", "KEY_DENIED", time.Now())
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(vmOperations.WithLabelValues("prepare", ResultSuccess)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(vmOperations.WithLabelValues("prepare", "KEY_DENIED")))
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const unixAddressPrefix = "unix:"
+
+// Serve exports the metrics on /metrics of the given address, which is either a unix socket path prefixed
+// with "unix:" or a loopback TCP address. The metrics are not exposed on any other network interface.
+func Serve(address string) (*http.Server, error) {
+	log.Trace("metrics/server:Serve() Entering")
+	defer log.Trace("metrics/server:Serve() Leaving")
+
+	l, err := listen(address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Error("metrics/server:Serve() Metrics server stopped")
+		}
+	}()
+	log.Infof("metrics/server:Serve() Serving metrics on %s", address)
+	return server, nil
+}
+
+// ValidateListenAddress checks that address is a unix socket or a loopback TCP address
+func ValidateListenAddress(address string) error {
+	if strings.HasPrefix(address, unixAddressPrefix) {
+		if strings.TrimPrefix(address, unixAddressPrefix) == "" {
+			return errors.New("metrics listen address has an empty unix socket path")
+		}
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrapf(err, "invalid metrics listen address %s", address)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return errors.Errorf("metrics listen address %s is not a loopback address", address)
+	}
+	return nil
+}
+
+func listen(address string) (net.Listener, error) {
+	if err := ValidateListenAddress(address); err != nil {
+		return nil, errors.Wrap(err, "metrics/server:listen()")
+	}
+	if !strings.HasPrefix(address, unixAddressPrefix) {
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, errors.Wrapf(err, "metrics/server:listen() error listening on %s", address)
+		}
+		return l, nil
+	}
+
+	socketPath := strings.TrimPrefix(address, unixAddressPrefix)
+	// remove the socket file left behind by a previous run
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "metrics/server:listen() error removing stale socket %s", socketPath)
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "metrics/server:listen() error listening on %s", socketPath)
+	}
+	if err = os.Chmod(socketPath, 0660); err != nil {
+		l.Close()
+		return nil, errors.Wrapf(err, "metrics/server:listen() error setting permissions of %s", socketPath)
+	}
+	return l, nil
+}
@@ -0,0 +1,261 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package rpc
+
+import (
+	"context"
+	"intel/isecl/lib/common/v4/log/message"
+	"intel/isecl/wlagent/v4/config"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Names of the methods in the peer authorization rules. The gRPC methods are named after their method in the
+// service, the net/rpc VirtualMachine methods after the gRPC method they forward to
+const (
+	MethodPrepare     = "Prepare"
+	MethodStart       = "Start"
+	MethodStop        = "Stop"
+	MethodFetchFlavor = "FetchFlavor"
+	MethodRetrieveKey = "RetrieveKey"
+	MethodStatus      = "Status"
+	MethodListVolumes = "ListVolumes"
+	MethodInventory   = "Inventory"
+	MethodReconcile   = "Reconcile"
+	MethodCleanup     = "Cleanup"
+	MethodUnWrapKey   = "UnWrapKey"
+	MethodWrapKey     = "WrapKey"
+	MethodHealth      = "Health"
+	// MethodDefault is the rule of the methods that have no rule of their own
+	MethodDefault = "*"
+)
+
+// defaultPeerRules let anyone check the health of the agent and only root call the other methods
+var defaultPeerRules = map[string]config.PeerRule{
+	MethodDefault: {UIDs: []uint32{0}},
+	MethodHealth:  {},
+}
+
+// keyMethods release image keys, their rules must name the users allowed to call them
+var keyMethods = map[string]bool{
+	MethodRetrieveKey: true,
+	MethodUnWrapKey:   true,
+}
+
+// grpcMethods maps the full gRPC method names to the method names in the rules
+var grpcMethods = map[string]string{
+	"/keyprovider.KeyProviderService/UnWrapKey": MethodUnWrapKey,
+	"/keyprovider.KeyProviderService/WrapKey":   MethodWrapKey,
+	"/grpc.health.v1.Health/Check":              MethodHealth,
+	"/grpc.health.v1.Health/Watch":              MethodHealth,
+}
+
+func init() {
+	for _, method := range []string{MethodPrepare, MethodStart, MethodStop, MethodFetchFlavor, MethodRetrieveKey, MethodStatus, MethodListVolumes, MethodInventory} {
+		grpcMethods["/wlagent.vm.v1.VirtualMachine/"+method] = method
+	}
+}
+
+// Authorizer decides from the credentials of the process connected to the agent socket whether it may call a method
+type Authorizer struct {
+	rules map[string]config.PeerRule
+}
+
+// NewAuthorizer returns an Authorizer applying rules on top of the default rules. It fails on rules for unknown
+// methods, on relative executable paths, which would otherwise never match, on executables without UIDs or GIDs to
+// narrow and on rules of the key methods that do not name their users
+func NewAuthorizer(rules map[string]config.PeerRule) (*Authorizer, error) {
+	log.Trace("rpc/authorize:NewAuthorizer() Entering")
+	defer log.Trace("rpc/authorize:NewAuthorizer() Leaving")
+
+	known := map[string]bool{MethodDefault: true, MethodReconcile: true, MethodCleanup: true}
+	for _, method := range grpcMethods {
+		known[method] = true
+	}
+
+	a := &Authorizer{rules: make(map[string]config.PeerRule)}
+	for method, rule := range defaultPeerRules {
+		a.rules[method] = rule
+	}
+	for method, rule := range rules {
+		if !known[method] {
+			return nil, errors.Errorf("rpc/authorize:NewAuthorizer() Unknown method %s in the peer authorization rules", method)
+		}
+		if len(rule.UIDs) == 0 && len(rule.GIDs) == 0 && len(rule.Executables) != 0 {
+			return nil, errors.Errorf("rpc/authorize:NewAuthorizer() The executables of method %s are not restricted to UIDs or GIDs", method)
+		}
+		var executables []string
+		for _, exe := range rule.Executables {
+			if !filepath.IsAbs(exe) {
+				return nil, errors.Errorf("rpc/authorize:NewAuthorizer() Executable %s of method %s is not an absolute path", exe, method)
+			}
+			// the executable of the peer is read from /proc/<pid>/exe, where symbolic links are resolved
+			if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+				exe = resolved
+			}
+			executables = append(executables, filepath.Clean(exe))
+		}
+		rule.Executables = executables
+		a.rules[method] = rule
+	}
+	// the key methods without a rule of their own follow the default rule
+	for method := range keyMethods {
+		rule, ok := a.rules[method]
+		if !ok {
+			rule = a.rules[MethodDefault]
+		}
+		if len(rule.UIDs) == 0 && len(rule.GIDs) == 0 {
+			return nil, errors.Errorf("rpc/authorize:NewAuthorizer() The rule of method %s must name its UIDs or GIDs", method)
+		}
+	}
+	return a, nil
+}
+
+// Methods returns the methods with a rule of their own, sorted, for logging the rules in effect
+func (a *Authorizer) Methods() []string {
+	var methods []string
+	for method := range a.rules {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Authorize returns an error when peer may not call method. Denials are logged in the security log
+func (a *Authorizer) Authorize(method string, peer PeerCred) error {
+	rule, ok := a.rules[method]
+	if !ok {
+		rule = a.rules[MethodDefault]
+	}
+	if !allowed(rule, peer) {
+		secLog.Errorf("rpc/authorize:Authorize() %s, Peer %s is not allowed to call %s", message.UnauthorizedAccess, peer, method)
+		return errors.Errorf("peer is not allowed to call %s", method)
+	}
+	return nil
+}
+
+// allowed returns true when rule lets peer call its method. Executables only narrow the UIDs and GIDs of the rule, a
+// rule with executables and no UIDs or GIDs allows no one
+func allowed(rule config.PeerRule, peer PeerCred) bool {
+	userAllowed := len(rule.UIDs) == 0 && len(rule.GIDs) == 0 && len(rule.Executables) == 0
+	for _, uid := range rule.UIDs {
+		userAllowed = userAllowed || uid == peer.UID
+	}
+	for _, gid := range rule.GIDs {
+		userAllowed = userAllowed || gid == peer.GID
+		for _, group := range peer.Groups {
+			userAllowed = userAllowed || gid == group
+		}
+	}
+	if !userAllowed {
+		return false
+	}
+
+	if len(rule.Executables) == 0 {
+		return true
+	}
+	for _, exe := range rule.Executables {
+		if peer.Exe != "" && exe == peer.Exe {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeRPC authorizes a call of the net/rpc services, whose errors are returned as is to the client
+func (a *Authorizer) authorizeRPC(method string, peer PeerCred) error {
+	if err := a.Authorize(method, peer); err != nil {
+		return rpcError{StatusCode: http.StatusForbidden, Message: err.Error()}
+	}
+	return nil
+}
+
+// authorizeGRPC authorizes a call of the gRPC services with the peer credentials set by PeerCredentials
+func (a *Authorizer) authorizeGRPC(ctx context.Context, fullMethod string) error {
+	method, ok := grpcMethods[fullMethod]
+	if !ok {
+		method = strings.TrimPrefix(fullMethod, "/")
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.PermissionDenied, "peer credentials are unknown")
+	}
+	authInfo, ok := p.AuthInfo.(PeerAuthInfo)
+	if !ok {
+		return status.Error(codes.PermissionDenied, "peer credentials are unknown")
+	}
+	if err := a.Authorize(method, authInfo.PeerCred); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// UnaryInterceptor authorizes the unary gRPC calls
+func (a *Authorizer) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.authorizeGRPC(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor authorizes the streaming gRPC calls
+func (a *Authorizer) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.authorizeGRPC(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// PeerAuthInfo is the credentials.AuthInfo of the gRPC connections on the agent socket
+type PeerAuthInfo struct {
+	PeerCred
+}
+
+// AuthType returns the name of the PeerCredentials authentication
+func (PeerAuthInfo) AuthType() string {
+	return "peercred"
+}
+
+// PeerCredentials returns the gRPC transport credentials of the agent socket. They do not secure the connection,
+// which is local, but record the credentials of the connected process for the Authorizer interceptors
+func PeerCredentials() credentials.TransportCredentials {
+	return peerCredentials{}
+}
+
+type peerCredentials struct{}
+
+func (peerCredentials) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return conn, nil, nil
+}
+
+func (peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	cred, err := peerCred(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, PeerAuthInfo{PeerCred: cred}, nil
+}
+
+func (peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "peercred"}
+}
+
+func (c peerCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (peerCredentials) OverrideServerName(string) error {
+	return nil
+}
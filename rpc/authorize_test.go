@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package rpc
+
+import (
+	"context"
+	"intel/isecl/wlagent/v4/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	wlagentExe = "/opt/workload-agent/bin/wlagent"
+	crioExe    = "/usr/bin/crio"
+)
+
+func TestAuthorizeDefaultRules(t *testing.T) {
+	a, err := NewAuthorizer(nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, a.Authorize(MethodPrepare, PeerCred{UID: 0}))
+	assert.Error(t, a.Authorize(MethodPrepare, PeerCred{UID: 1000, GID: 0}))
+	assert.Error(t, a.Authorize(MethodRetrieveKey, PeerCred{UID: 1000}))
+	assert.NoError(t, a.Authorize(MethodHealth, PeerCred{UID: 1000}))
+}
+
+func TestAuthorizeRules(t *testing.T) {
+	a, err := NewAuthorizer(map[string]config.PeerRule{
+		MethodPrepare:   {UIDs: []uint32{0}, Executables: []string{wlagentExe}},
+		MethodUnWrapKey: {UIDs: []uint32{0}, Executables: []string{crioExe, "/usr/bin/conmon"}},
+		MethodStatus:    {GIDs: []uint32{990}},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, a.Authorize(MethodPrepare, PeerCred{UID: 0, Exe: wlagentExe}))
+	assert.Error(t, a.Authorize(MethodPrepare, PeerCred{UID: 0, Exe: crioExe}))
+	assert.Error(t, a.Authorize(MethodPrepare, PeerCred{UID: 0}))
+	assert.Error(t, a.Authorize(MethodPrepare, PeerCred{UID: 1000, Exe: wlagentExe}))
+	assert.NoError(t, a.Authorize(MethodUnWrapKey, PeerCred{UID: 0, Exe: crioExe}))
+	assert.Error(t, a.Authorize(MethodUnWrapKey, PeerCred{UID: 0, Exe: wlagentExe}))
+
+	// supplementary groups count as well as the primary group
+	assert.NoError(t, a.Authorize(MethodStatus, PeerCred{UID: 1000, GID: 990}))
+	assert.NoError(t, a.Authorize(MethodStatus, PeerCred{UID: 1000, GID: 1000, Groups: []uint32{10, 990}}))
+	assert.Error(t, a.Authorize(MethodStatus, PeerCred{UID: 0, GID: 0}))
+
+	// methods without a rule of their own fall back to the default rule
+	assert.NoError(t, a.Authorize(MethodStart, PeerCred{UID: 0}))
+	assert.Error(t, a.Authorize(MethodStart, PeerCred{UID: 1000}))
+}
+
+func TestNewAuthorizerInvalidRules(t *testing.T) {
+	_, err := NewAuthorizer(map[string]config.PeerRule{"Prepar": {UIDs: []uint32{0}}})
+	assert.Error(t, err)
+
+	_, err = NewAuthorizer(map[string]config.PeerRule{MethodPrepare: {UIDs: []uint32{0}, Executables: []string{"wlagent"}}})
+	assert.Error(t, err)
+
+	// executables only narrow the users of a rule
+	_, err = NewAuthorizer(map[string]config.PeerRule{MethodPrepare: {Executables: []string{wlagentExe}}})
+	assert.Error(t, err)
+
+	// the key methods can not be opened to any user
+	_, err = NewAuthorizer(map[string]config.PeerRule{MethodUnWrapKey: {Executables: []string{crioExe}}})
+	assert.Error(t, err)
+	_, err = NewAuthorizer(map[string]config.PeerRule{MethodRetrieveKey: {}})
+	assert.Error(t, err)
+	_, err = NewAuthorizer(map[string]config.PeerRule{MethodDefault: {}})
+	assert.Error(t, err)
+	_, err = NewAuthorizer(map[string]config.PeerRule{MethodDefault: {}, MethodRetrieveKey: {UIDs: []uint32{0}}, MethodUnWrapKey: {GIDs: []uint32{990}}})
+	assert.NoError(t, err)
+
+	// other methods can
+	_, err = NewAuthorizer(map[string]config.PeerRule{MethodStatus: {}})
+	assert.NoError(t, err)
+}
+
+func TestAllowedExecutablesWithoutUsers(t *testing.T) {
+	assert.True(t, allowed(config.PeerRule{}, PeerCred{UID: 1000}))
+	assert.False(t, allowed(config.PeerRule{Executables: []string{crioExe}}, PeerCred{UID: 1000, Exe: crioExe}))
+	assert.False(t, allowed(config.PeerRule{Executables: []string{crioExe}}, PeerCred{UID: 0, Exe: crioExe}))
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	a, err := NewAuthorizer(map[string]config.PeerRule{MethodUnWrapKey: {UIDs: []uint32{0}, Executables: []string{crioExe}}})
+	assert.NoError(t, err)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/keyprovider.KeyProviderService/UnWrapKey"}
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: PeerAuthInfo{PeerCred{UID: 0, Exe: crioExe}}})
+	reply, err := a.UnaryInterceptor(ctx, nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", reply)
+
+	// the executable of the peer does not stand in for its user
+	ctx = peer.NewContext(context.Background(), &peer.Peer{AuthInfo: PeerAuthInfo{PeerCred{UID: 1000, Exe: crioExe}}})
+	reply, err = a.UnaryInterceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Nil(t, reply)
+
+	ctx = peer.NewContext(context.Background(), &peer.Peer{AuthInfo: PeerAuthInfo{PeerCred{UID: 0, Exe: wlagentExe}}})
+	_, err = a.UnaryInterceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// connections without the peer credentials are denied
+	_, err = a.UnaryInterceptor(context.Background(), nil, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// unknown methods fall back to the default rule
+	info = &grpc.UnaryServerInfo{FullMethod: "/wlagent.vm.v2.VirtualMachine/Prepare"}
+	ctx = peer.NewContext(context.Background(), &peer.Peer{AuthInfo: PeerAuthInfo{PeerCred{UID: 1000}}})
+	_, err = a.UnaryInterceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
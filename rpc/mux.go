@@ -0,0 +1,165 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package rpc
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// http2Preface is sent first by the gRPC clients on every connection. The gob encoded requests of the
+// net/rpc clients never start with it
+var http2Preface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+// sniffTimeout bounds the time a client may take to send the first bytes of its request
+const sniffTimeout = 10 * time.Second
+
+var errMuxClosed = errors.New("rpc/mux: listener closed")
+
+// Mux serves the net/rpc and the gRPC services of the agent on one listener, so that the libvirt hook
+// and the ocicrypt key provider clients keep using the same socket. Each accepted connection is handed
+// to the GRPC or the RPC listener depending on the first bytes sent by the client
+type Mux struct {
+	root net.Listener
+	grpc *muxListener
+	rpc  *muxListener
+}
+
+// NewMux returns a Mux dispatching the connections accepted on l, Serve must be called to start accepting them
+func NewMux(l net.Listener) *Mux {
+	return &Mux{
+		root: l,
+		grpc: newMuxListener(l.Addr()),
+		rpc:  newMuxListener(l.Addr()),
+	}
+}
+
+// GRPC returns the listener of the connections of gRPC clients
+func (m *Mux) GRPC() net.Listener {
+	return m.grpc
+}
+
+// RPC returns the listener of the connections of net/rpc clients
+func (m *Mux) RPC() net.Listener {
+	return m.rpc
+}
+
+// Serve accepts connections until the root listener is closed, then closes the GRPC and RPC listeners
+func (m *Mux) Serve() error {
+	defer m.grpc.Close()
+	defer m.rpc.Close()
+	for {
+		conn, err := m.root.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				log.WithError(err).Warn("rpc/mux:Serve() Temporary error accepting a connection")
+				continue
+			}
+			return err
+		}
+		go m.dispatch(conn)
+	}
+}
+
+// Close closes the root listener, which stops Serve
+func (m *Mux) Close() error {
+	return m.root.Close()
+}
+
+func (m *Mux) dispatch(conn net.Conn) {
+	sniffed, isGRPC, err := sniff(conn)
+	if err != nil {
+		log.WithError(err).Debug("rpc/mux:dispatch() Closing connection without a request")
+		conn.Close()
+		return
+	}
+	target := m.rpc
+	if isGRPC {
+		target = m.grpc
+	}
+	target.deliver(&sniffedConn{Conn: conn, reader: io.MultiReader(bytes.NewReader(sniffed), conn)})
+}
+
+// sniff reads the first bytes sent on conn until they are known to be, or not to be, the HTTP/2 preface
+func sniff(conn net.Conn) ([]byte, bool, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(sniffTimeout)); err != nil {
+		return nil, false, err
+	}
+	buf := make([]byte, len(http2Preface))
+	n := 0
+	for n < len(buf) {
+		read, err := conn.Read(buf[n:])
+		n += read
+		if !bytes.HasPrefix(http2Preface, buf[:n]) {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, false, err
+	}
+	return buf[:n], bytes.Equal(buf[:n], http2Preface), nil
+}
+
+// sniffedConn replays the bytes read by sniff before reading from the connection
+type sniffedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// muxListener is a net.Listener accepting the connections dispatched to it by the Mux
+type muxListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newMuxListener(addr net.Addr) *muxListener {
+	return &muxListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *muxListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errMuxClosed
+	}
+}
+
+func (l *muxListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package rpc
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type echo struct{}
+
+func (*echo) Echo(args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+func TestMuxServesRPCAndGRPC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mux")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "wlagent.sock")
+
+	l, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+	mux := NewMux(l)
+
+	r := rpc.NewServer()
+	assert.NoError(t, r.RegisterName("Echo", &echo{}))
+	go r.Accept(mux.RPC())
+
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, grpchealth.NewServer())
+	go s.Serve(mux.GRPC())
+
+	served := make(chan error)
+	go func() {
+		served <- mux.Serve()
+	}()
+
+	// net/rpc client
+	conn, err := net.Dial("unix", socketPath)
+	assert.NoError(t, err)
+	client := rpc.NewClient(conn)
+	args, reply := "PRI * not quite HTTP/2", ""
+	assert.NoError(t, client.Call("Echo.Echo", &args, &reply))
+	assert.Equal(t, args, reply)
+	client.Close()
+
+	// gRPC client
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	grpcConn, err := grpc.DialContext(ctx, socketPath, grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", address)
+		}))
+	assert.NoError(t, err)
+	response, err := healthpb.NewHealthClient(grpcConn).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, response.GetStatus())
+	grpcConn.Close()
+
+	s.GracefulStop()
+	assert.NoError(t, mux.Close())
+	assert.Error(t, <-served)
+	_, err = mux.RPC().Accept()
+	assert.Equal(t, errMuxClosed, err)
+}
+
+func TestSniffClosedConnection(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		client.Write([]byte("PRI * HTTP"))
+		client.Close()
+	}()
+	_, _, err := sniff(server)
+	assert.Error(t, err)
+}
@@ -0,0 +1,99 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package rpc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// PeerCred holds the credentials of the process connected to the agent socket, as reported by the
+// kernel with SO_PEERCRED when the connection was made. Groups and Exe are read from /proc, they are empty
+// when the process is not visible in the pid namespace of the agent
+type PeerCred struct {
+	PID    int32
+	UID    uint32
+	GID    uint32
+	Groups []uint32
+	Exe    string
+}
+
+// procDir is where the supplementary groups and the executable of the peer are read from
+var procDir = "/proc"
+
+func (p PeerCred) String() string {
+	return fmt.Sprintf("pid=%d uid=%d gid=%d exe=%s", p.PID, p.UID, p.GID, p.Exe)
+}
+
+// peerCred returns the credentials of the process at the other end of a unix socket connection
+func peerCred(conn net.Conn) (PeerCred, error) {
+	if sniffed, ok := conn.(*sniffedConn); ok {
+		conn = sniffed.Conn
+	}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return PeerCred{}, errors.New("rpc/peer:peerCred() not a unix socket connection")
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return PeerCred{}, errors.Wrap(err, "rpc/peer:peerCred() error getting the socket")
+	}
+
+	var ucred *syscall.Ucred
+	var ucredErr error
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, ucredErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = ucredErr
+	}
+	if err != nil {
+		return PeerCred{}, errors.Wrap(err, "rpc/peer:peerCred() error reading the peer credentials")
+	}
+	peer := PeerCred{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}
+	if peer.PID > 0 {
+		peer.Groups, peer.Exe = procInfo(peer.PID)
+	}
+	return peer, nil
+}
+
+// procInfo returns the supplementary groups and the executable of the process pid, as much as can be read from /proc
+func procInfo(pid int32) ([]uint32, string) {
+	pidDir := filepath.Join(procDir, strconv.Itoa(int(pid)))
+	exe, err := os.Readlink(filepath.Join(pidDir, "exe"))
+	if err != nil {
+		log.WithError(err).Debugf("rpc/peer:procInfo() Could not read the executable of process %d", pid)
+	}
+
+	status, err := ioutil.ReadFile(filepath.Join(pidDir, "status"))
+	if err != nil {
+		log.WithError(err).Debugf("rpc/peer:procInfo() Could not read the status of process %d", pid)
+		return nil, exe
+	}
+	var groups []uint32
+	for _, line := range strings.Split(string(status), "\n") {
+		if !strings.HasPrefix(line, "Groups:") {
+			continue
+		}
+		for _, field := range strings.Fields(strings.TrimPrefix(line, "Groups:")) {
+			gid, err := strconv.ParseUint(field, 10, 32)
+			if err == nil {
+				groups = append(groups, uint32(gid))
+			}
+		}
+		break
+	}
+	return groups, exe
+}
@@ -0,0 +1,67 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package rpc
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerCred(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("unix", filepath.Join(dir, "wlagent.sock"))
+	assert.NoError(t, err)
+	defer l.Close()
+
+	client, err := net.Dial("unix", filepath.Join(dir, "wlagent.sock"))
+	assert.NoError(t, err)
+	defer client.Close()
+	conn, err := l.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	peer, err := peerCred(&sniffedConn{Conn: conn, reader: conn})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(os.Getpid()), peer.PID)
+	assert.Equal(t, uint32(os.Getuid()), peer.UID)
+	assert.Equal(t, uint32(os.Getgid()), peer.GID)
+
+	pipeClient, pipeServer := net.Pipe()
+	defer pipeClient.Close()
+	_, err = peerCred(pipeServer)
+	assert.Error(t, err)
+}
+
+func TestProcInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(dir string) { procDir = dir }(procDir)
+	procDir = dir
+
+	pidDir := filepath.Join(dir, "4242")
+	assert.NoError(t, os.Mkdir(pidDir, 0700))
+	assert.NoError(t, os.Symlink("/usr/bin/crio", filepath.Join(pidDir, "exe")))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(pidDir, "status"),
+		[]byte("Name:\tcrio\nUid:\t0\t0\t0\t0\nGroups:\t10 990 \nVmPeak:\t1 kB\n"), 0600))
+
+	groups, exe := procInfo(4242)
+	assert.Equal(t, []uint32{10, 990}, groups)
+	assert.Equal(t, "/usr/bin/crio", exe)
+
+	groups, exe = procInfo(4343)
+	assert.Empty(t, groups)
+	assert.Empty(t, exe)
+}
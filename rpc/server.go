@@ -5,14 +5,20 @@
 package rpc
 
 import (
+	"context"
 	"fmt"
 	cLog "intel/isecl/lib/common/v4/log"
 	"intel/isecl/lib/common/v4/log/message"
 	"intel/isecl/lib/common/v4/proc"
-	"intel/isecl/lib/common/v4/validation"
-	"intel/isecl/wlagent/v4/filewatch"
+	"intel/isecl/wlagent/v4/flavor"
+	"intel/isecl/wlagent/v4/health"
+	"intel/isecl/wlagent/v4/metrics"
+	"intel/isecl/wlagent/v4/rpc/vmv1"
 	"intel/isecl/wlagent/v4/wlavm"
-	"sync"
+	"net"
+	"net/http"
+	"net/rpc"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -20,8 +26,6 @@ import (
 var log = cLog.GetDefaultLogger()
 var secLog = cLog.GetSecurityLogger()
 
-var wlaMtx sync.Mutex
-
 // DomainXML is a struct containing domain XML as argument to allow invocation over RPC
 type DomainXML struct {
 	XML string
@@ -44,9 +48,46 @@ type KeyInfo struct {
 	ReturnCode bool
 }
 
-// VirtualMachine is type that defines the RPC functions for communicating with the Wlagent daemon Starting/Stopping a VM
+// VMResult is the reply of the VirtualMachine PrepareVM, StartVM and StopVM RPC methods. When Success is false,
+// Phase, Code and Message describe why the VM lifecycle operation failed
+type VMResult struct {
+	Success bool
+	Phase   string
+	Code    string
+	Message string
+}
+
+// VirtualMachine is type that defines the RPC functions for communicating with the Wlagent daemon Starting/Stopping a VM.
+// It is the net/rpc compatibility shim of the gRPC VMService. ServeRPC registers one per connection, with the
+// credentials of the connected process in Peer, which the Authorizer checks before each call
 type VirtualMachine struct {
-	Watcher *filewatch.Watcher
+	Service    *VMService
+	Lister     wlavm.DomainLister
+	Authorizer *Authorizer
+	Peer       PeerCred
+}
+
+// ReconcileArgs is a struct containing the reconcile options as argument to allow invocation over RPC
+type ReconcileArgs struct {
+	DryRun bool
+}
+
+// CleanupArgs is a struct containing the volume to clean up as argument to allow invocation over RPC
+type CleanupArgs struct {
+	Kind   wlavm.VolumeKind
+	UUID   string
+	DryRun bool
+}
+
+// HealthArgs is a struct containing the health check options as argument to allow invocation over RPC
+type HealthArgs struct {
+	Readiness bool
+}
+
+// Health is type that defines the RPC functions reporting the health of the Wlagent daemon
+type Health struct {
+	Authorizer *Authorizer
+	Peer       PeerCred
 }
 
 type rpcError struct {
@@ -69,73 +110,287 @@ func (e rpcError) Error() string {
 	return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
 }
 
-// Start forwards the RPC request to wlavm.Start
-func (vm *VirtualMachine) Start(args *DomainXML, reply *bool) error {
-	// Passing the false parameter to ensure the start vm task is not added to waitgroup if there is pending signal termination on rpc
-	_, err := proc.AddTask(false)
+// String formats the VMResult for printing by the libvirt hook commands
+func (r VMResult) String() string {
+	if r.Success {
+		return fmt.Sprintf("%s: success", r.Phase)
+	}
+	return fmt.Sprintf("%s (%s): %s", r.Code, r.Phase, r.Message)
+}
+
+// observe records the outcome of a VM lifecycle operation started at start in the metrics
+func (r VMResult) observe(phase wlavm.Phase, start time.Time) {
+	result := metrics.ResultSuccess
+	if !r.Success {
+		result = r.Code
+	}
+	metrics.ObserveVMOperation(string(phase), result, start)
+}
+
+func (r VMResult) toProto() *vmv1.VMResult {
+	return &vmv1.VMResult{
+		Success: r.Success,
+		Phase:   r.Phase,
+		Code:    r.Code,
+		Message: r.Message,
+	}
+}
+
+func vmResultFromProto(result *vmv1.VMResult) VMResult {
+	return VMResult{
+		Success: result.GetSuccess(),
+		Phase:   result.GetPhase(),
+		Code:    result.GetCode(),
+		Message: result.GetMessage(),
+	}
+}
+
+// newVMResult converts the error returned by wlavm into a VMResult reply
+func newVMResult(phase wlavm.Phase, err error) VMResult {
+	if err == nil {
+		return VMResult{Success: true, Phase: string(phase)}
+	}
+	if vmErr, ok := err.(*wlavm.VMError); ok {
+		msg := vmErr.Message
+		if vmErr.Err != nil {
+			msg = msg + ": " + vmErr.Err.Error()
+		}
+		return VMResult{
+			Phase:   string(vmErr.Phase),
+			Code:    string(vmErr.Code),
+			Message: msg,
+		}
+	}
+	return VMResult{
+		Phase:   string(phase),
+		Code:    string(wlavm.InternalError),
+		Message: err.Error(),
+	}
+}
+
+// ServeRPC serves the net/rpc services on the connections accepted on l until l is closed. The services are
+// registered for each connection, so that authorizer can check the credentials of the process they serve
+func ServeRPC(l net.Listener, service *VMService, lister wlavm.DomainLister, authorizer *Authorizer) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveRPCConn(conn, service, lister, authorizer)
+	}
+}
+
+func serveRPCConn(conn net.Conn, service *VMService, lister wlavm.DomainLister, authorizer *Authorizer) {
+	peer, err := peerCred(conn)
 	if err != nil {
-		return errors.Wrap(err, "rpc/server:Start() Could not add task for vm start")
+		log.WithError(err).Error("rpc/server:serveRPCConn() Could not get the credentials of the peer, closing the connection")
+		conn.Close()
+		return
 	}
-	defer proc.TaskDone()
-	log.Trace("rpc/server:Start() Entering")
-	defer log.Trace("rpc/server:Start() Leaving")
 
-	if err = validation.ValidateXMLString(args.XML); err != nil {
-		secLog.Errorf("rpc:server() Start: %s, Invalid domain XML format", message.InvalidInputBadParam)
-		return nil
+	r := rpc.NewServer()
+	err = r.Register(&VirtualMachine{Service: service, Lister: lister, Authorizer: authorizer, Peer: peer})
+	if err == nil {
+		err = r.Register(&Health{Authorizer: authorizer, Peer: peer})
+	}
+	if err != nil {
+		log.WithError(err).Error("rpc/server:serveRPCConn() Unable to register the RPC services")
+		conn.Close()
+		return
 	}
+	r.ServeConn(conn)
+}
 
-	// pass in vm.Watcher to get the instance to the File System Watcher
-	*reply = wlavm.Start(args.XML, vm.Watcher)
-	return nil
+// Start forwards the RPC request to VMService.Start. The reply is true if the VM was started, as read by the libvirt
+// hook of earlier releases
+func (vm *VirtualMachine) Start(args *DomainXML, reply *bool) error {
+	var result VMResult
+	err := vm.StartVM(args, &result)
+	*reply = result.Success
+	return err
 }
 
-// Prepare forwards the RPC request to wlavm.Prepare
+// Prepare forwards the RPC request to VMService.Prepare. The reply is true if the VM was prepared, as read by the
+// libvirt hook of earlier releases
 func (vm *VirtualMachine) Prepare(args *DomainXML, reply *bool) error {
-	// Passing the false parameter to ensure the prepare vm task is not added to waitgroup if there is pending signal termination on rpc
-	_, err := proc.AddTask(false)
+	var result VMResult
+	err := vm.PrepareVM(args, &result)
+	*reply = result.Success
+	return err
+}
+
+// Stop forwards the RPC request to VMService.Stop. The reply is true if the VM was stopped, as read by the libvirt
+// hook of earlier releases
+func (vm *VirtualMachine) Stop(args *DomainXML, reply *bool) error {
+	var result VMResult
+	err := vm.StopVM(args, &result)
+	*reply = result.Success
+	return err
+}
+
+// StartVM forwards the RPC request to VMService.Start, the reply describes why the VM was not started
+func (vm *VirtualMachine) StartVM(args *DomainXML, reply *VMResult) error {
+	log.Trace("rpc/server:StartVM() Entering")
+	defer log.Trace("rpc/server:StartVM() Leaving")
+
+	if err := vm.Authorizer.authorizeRPC(MethodStart, vm.Peer); err != nil {
+		return err
+	}
+	return vm.forward(vm.Service.Start, args, reply)
+}
+
+// PrepareVM forwards the RPC request to VMService.Prepare, the reply describes why the VM was not prepared
+func (vm *VirtualMachine) PrepareVM(args *DomainXML, reply *VMResult) error {
+	log.Trace("rpc/server:PrepareVM() Entering")
+	defer log.Trace("rpc/server:PrepareVM() Leaving")
+
+	if err := vm.Authorizer.authorizeRPC(MethodPrepare, vm.Peer); err != nil {
+		return err
+	}
+	return vm.forward(vm.Service.Prepare, args, reply)
+}
+
+// StopVM forwards the RPC request to VMService.Stop, the reply describes why the VM was not stopped
+func (vm *VirtualMachine) StopVM(args *DomainXML, reply *VMResult) error {
+	log.Trace("rpc/server:StopVM() Entering")
+	defer log.Trace("rpc/server:StopVM() Leaving")
+
+	if err := vm.Authorizer.authorizeRPC(MethodStop, vm.Peer); err != nil {
+		return err
+	}
+	return vm.forward(vm.Service.Stop, args, reply)
+}
+
+// forward calls a VM lifecycle method of the gRPC service and converts its result to the net/rpc reply
+func (vm *VirtualMachine) forward(method func(context.Context, *vmv1.DomainRequest) (*vmv1.VMResult, error), args *DomainXML, reply *VMResult) error {
+	result, err := method(context.Background(), &vmv1.DomainRequest{DomainXml: args.XML})
 	if err != nil {
-		return errors.Wrap(err, "rpc/server:Prepare() Could not add task for vm prepare")
+		return err
 	}
-	defer proc.TaskDone()
-	log.Trace("rpc/server:Prepare() Entering")
-	defer log.Trace("rpc/server:Prepare() Leaving")
+	*reply = vmResultFromProto(result)
+	return nil
+}
 
-	wlaMtx.Lock()
-	defer wlaMtx.Unlock()
+// FetchFlavor forwards the RPC request to VMService.FetchFlavor. ReturnCode is false when the flavor could not be
+// fetched, and the ImageFlavor is empty when no flavor exists for the image
+func (vm *VirtualMachine) FetchFlavor(args *FlavorInfo, reply *flavor.OutFlavor) error {
+	log.Trace("rpc/server:FetchFlavor() Entering")
+	defer log.Trace("rpc/server:FetchFlavor() Leaving")
 
-	if err = validation.ValidateXMLString(args.XML); err != nil {
-		secLog.Errorf("rpc:server() Prepare: %s, Invalid domain XML format", message.InvalidInputBadParam)
+	if err := vm.Authorizer.authorizeRPC(MethodFetchFlavor, vm.Peer); err != nil {
+		return err
+	}
+	response, err := vm.Service.FetchFlavor(context.Background(), &vmv1.FetchFlavorRequest{ImageId: args.ImageID})
+	if err != nil {
+		log.WithError(err).Errorf("rpc/server:FetchFlavor() Could not fetch the flavor of image %s", args.ImageID)
+		*reply = flavor.OutFlavor{ReturnCode: false}
 		return nil
 	}
+	*reply = flavor.OutFlavor{ReturnCode: true, ImageFlavor: response.GetFlavor()}
+	return nil
+}
 
-	// pass in vm.Watcher to get the instance to the File System Watcher
-	*reply = wlavm.Prepare(args.XML, vm.Watcher)
+// FetchKey returns the key of an image whose flavor was fetched with FetchFlavor, unwrapped with the TPM
+// binding key. It is authorized as RetrieveKey
+func (vm *VirtualMachine) FetchKey(args *KeyInfo, reply *KeyInfo) error {
+	log.Trace("rpc/server:FetchKey() Entering")
+	defer log.Trace("rpc/server:FetchKey() Leaving")
+
+	if err := vm.Authorizer.authorizeRPC(MethodRetrieveKey, vm.Peer); err != nil {
+		return err
+	}
+	response, err := vm.Service.RetrieveKey(context.Background(), &vmv1.RetrieveKeyRequest{
+		Key: &vmv1.RetrieveKeyRequest_KeyId{KeyId: args.KeyID},
+	})
+	if err != nil {
+		log.WithError(err).Errorf("rpc/server:FetchKey() Could not retrieve the key %s", args.KeyID)
+		*reply = KeyInfo{KeyID: args.KeyID, ReturnCode: false}
+		return nil
+	}
+	secLog.Infof("rpc/server:FetchKey() %s, Key %s released to peer %s", message.EncKeyUsed, args.KeyID, vm.Peer)
+	*reply = KeyInfo{KeyID: args.KeyID, Key: response.GetKey(), ReturnCode: true}
 	return nil
 }
 
-// Stop forwards the RPC request to wlavm.Stop
-func (vm *VirtualMachine) Stop(args *DomainXML, reply *bool) error {
-	// Passing the true parameter to ensure the stop vm task is added to waitgroup as this action needs to be completed
-	// even if there is pending signal termination on rpc
+// FetchKeyWithURL returns the key at the transfer URL, unwrapped with the TPM binding key. It is authorized as
+// RetrieveKey
+func (vm *VirtualMachine) FetchKeyWithURL(args *TransferURL, reply *KeyOnly) error {
+	log.Trace("rpc/server:FetchKeyWithURL() Entering")
+	defer log.Trace("rpc/server:FetchKeyWithURL() Leaving")
+
+	if err := vm.Authorizer.authorizeRPC(MethodRetrieveKey, vm.Peer); err != nil {
+		return err
+	}
+	response, err := vm.Service.RetrieveKey(context.Background(), &vmv1.RetrieveKeyRequest{
+		Key: &vmv1.RetrieveKeyRequest_KeyUrl{KeyUrl: args.URL},
+	})
+	if err != nil {
+		log.WithError(err).Errorf("rpc/server:FetchKeyWithURL() Could not retrieve the key %s", args.URL)
+		return rpcError{StatusCode: http.StatusInternalServerError, Message: "could not retrieve the key"}
+	}
+	secLog.Infof("rpc/server:FetchKeyWithURL() %s, Key %s released to peer %s", message.EncKeyUsed, args.URL, vm.Peer)
+	*reply = KeyOnly{KeyUrl: args.URL, Key: response.GetKey()}
+	return nil
+}
+
+// Reconcile forwards the RPC request to wlavm.Reconcile
+func (vm *VirtualMachine) Reconcile(args *ReconcileArgs, reply *wlavm.ReconcileReport) error {
+	if err := vm.Authorizer.authorizeRPC(MethodReconcile, vm.Peer); err != nil {
+		return err
+	}
 	_, err := proc.AddTask(true)
 	if err != nil {
-		return errors.Wrap(err, "rpc/server:Stop() Could not add task for vm stop")
+		return errors.Wrap(err, "rpc/server:Reconcile() Could not add task for reconcile")
 	}
 	defer proc.TaskDone()
 
-	log.Trace("rpc/server:Stop() Entering")
-	defer log.Trace("rpc/server:Stop() Leaving")
+	log.Trace("rpc/server:Reconcile() Entering")
+	defer log.Trace("rpc/server:Reconcile() Leaving")
 
-	wlaMtx.Lock()
-	defer wlaMtx.Unlock()
+	secLog.Info("rpc/server:Reconcile() Reconciling dm-crypt volumes and image vm associations")
+	*reply, err = wlavm.Reconcile(vm.Lister, args.DryRun)
+	if err != nil {
+		log.WithError(err).Error("rpc/server:Reconcile() Error while reconciling")
+		return err
+	}
+	return nil
+}
 
-	if err = validation.ValidateXMLString(args.XML); err != nil {
-		secLog.Errorf("rpc:server() Stop: %s, Invalid domain XML format", message.InvalidInputBadParam)
-		return nil
+// Cleanup forwards the RPC request to wlavm.Cleanup
+func (vm *VirtualMachine) Cleanup(args *CleanupArgs, reply *wlavm.CleanupReport) error {
+	if err := vm.Authorizer.authorizeRPC(MethodCleanup, vm.Peer); err != nil {
+		return err
 	}
+	_, err := proc.AddTask(true)
+	if err != nil {
+		return errors.Wrap(err, "rpc/server:Cleanup() Could not add task for cleanup")
+	}
+	defer proc.TaskDone()
+
+	log.Trace("rpc/server:Cleanup() Entering")
+	defer log.Trace("rpc/server:Cleanup() Leaving")
 
-	// pass in vm.Watcher to get the instance to the File System Watcher
-	*reply = wlavm.Stop(args.XML, vm.Watcher)
+	secLog.Infof("rpc/server:Cleanup() Cleaning up the volume of %s %s for peer %s", args.Kind, args.UUID, vm.Peer)
+	*reply, err = wlavm.Cleanup(vm.Lister, args.Kind, args.UUID, args.DryRun)
+	if err != nil {
+		log.WithError(err).Error("rpc/server:Cleanup() Error while cleaning up")
+		return err
+	}
+	return nil
+}
+
+// Check returns the liveness of the daemon, or its readiness when args.Readiness is set
+func (h *Health) Check(args *HealthArgs, reply *health.Report) error {
+	log.Trace("rpc/server:Check() Entering")
+	defer log.Trace("rpc/server:Check() Leaving")
+
+	if err := h.Authorizer.authorizeRPC(MethodHealth, h.Peer); err != nil {
+		return err
+	}
+	if args.Readiness {
+		*reply = health.Readiness()
+	} else {
+		*reply = health.Liveness()
+	}
 	return nil
 }
@@ -0,0 +1,35 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package rpc
+
+import (
+	"intel/isecl/wlagent/v4/wlavm"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewVMResult(t *testing.T) {
+	assert.Equal(t, VMResult{Success: true, Phase: string(wlavm.PhaseStart)}, newVMResult(wlavm.PhaseStart, nil))
+
+	result := newVMResult(wlavm.PhaseStart, &wlavm.VMError{Phase: wlavm.PhasePrepare, Code: wlavm.InvalidDomainXML,
+		Message: "invalid domain XML format", Err: errors.New("unexpected EOF")})
+	assert.Equal(t, VMResult{
+		Phase:   string(wlavm.PhasePrepare),
+		Code:    string(wlavm.InvalidDomainXML),
+		Message: "invalid domain XML format: unexpected EOF",
+	}, result)
+
+	result = newVMResult(wlavm.PhaseStop, errors.New("unexpected"))
+	assert.Equal(t, string(wlavm.InternalError), result.Code)
+	assert.Equal(t, string(wlavm.PhaseStop), result.Phase)
+}
+
+func TestVMResultProtoRoundTrip(t *testing.T) {
+	result := VMResult{Phase: "prepare", Code: "KEY_DENIED", Message: "host is not trusted"}
+	assert.Equal(t, result, vmResultFromProto(result.toProto()))
+	assert.Equal(t, VMResult{}, vmResultFromProto(nil))
+}
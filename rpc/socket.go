@@ -0,0 +1,122 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package rpc
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// socketDirMode lets the members of the socket group reach the socket, the directory also holds the
+	// flavor cache and the image vm associations which keep their own modes
+	socketDirMode  os.FileMode = 0750
+	socketFileMode os.FileMode = 0660
+)
+
+// SocketGroupID returns the ID of the group owning the agent socket, given by name or ID, or -1 when group is
+// empty and the socket keeps the group of the agent
+func SocketGroupID(group string) (int, error) {
+	if group == "" {
+		return -1, nil
+	}
+	if gid, err := strconv.Atoi(group); err == nil && gid >= 0 {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return -1, errors.Wrapf(err, "rpc/socket:SocketGroupID() Could not find the socket group %s", group)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return -1, errors.Wrapf(err, "rpc/socket:SocketGroupID() Invalid ID of the socket group %s", group)
+	}
+	return gid, nil
+}
+
+// SetupSocketDir creates the directory of the agent socket, or checks that the existing one is owned by the agent
+// user and is not world-writable, then sets its group to gid, unless it is -1, and its mode
+func SetupSocketDir(dir string, gid int) error {
+	log.Trace("rpc/socket:SetupSocketDir() Entering")
+	defer log.Trace("rpc/socket:SetupSocketDir() Leaving")
+
+	dir = filepath.Clean(dir)
+	info, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		if err = os.MkdirAll(dir, socketDirMode); err != nil {
+			return errors.Wrapf(err, "rpc/socket:SetupSocketDir() Could not create directory %s", dir)
+		}
+		info, err = os.Lstat(dir)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "rpc/socket:SetupSocketDir() Could not stat directory %s", dir)
+	}
+
+	if !info.IsDir() {
+		return errors.Errorf("rpc/socket:SetupSocketDir() %s is not a directory", dir)
+	}
+	if info.Mode().Perm()&0002 != 0 {
+		return errors.Errorf("rpc/socket:SetupSocketDir() Directory %s is world-writable", dir)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.Errorf("rpc/socket:SetupSocketDir() Could not get the owner of directory %s", dir)
+	}
+	if int(stat.Uid) != os.Geteuid() {
+		return errors.Errorf("rpc/socket:SetupSocketDir() Directory %s is owned by user %d", dir, stat.Uid)
+	}
+
+	if err = os.Chown(dir, -1, gid); err != nil {
+		return errors.Wrapf(err, "rpc/socket:SetupSocketDir() Could not set the group of directory %s", dir)
+	}
+	// MkdirAll applies the umask, the mode is set explicitly
+	if err = os.Chmod(dir, socketDirMode); err != nil {
+		return errors.Wrapf(err, "rpc/socket:SetupSocketDir() Could not set the mode of directory %s", dir)
+	}
+	return nil
+}
+
+// ListenSocket listens on the agent socket at path, in a directory set up by SetupSocketDir. A stale socket left
+// behind by a previous run is removed, and the socket group is set to gid, unless it is -1, and its mode
+func ListenSocket(path string, gid int) (net.Listener, error) {
+	log.Trace("rpc/socket:ListenSocket() Entering")
+	defer log.Trace("rpc/socket:ListenSocket() Leaving")
+
+	// When the socket is closed, the socket file isn't removed. It is removed before listening again to
+	// prevent error: bind address already in use
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.Errorf("rpc/socket:ListenSocket() %s exists and is not a socket", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, errors.Wrapf(err, "rpc/socket:ListenSocket() Could not remove stale socket %s", path)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "rpc/socket:ListenSocket() Could not stat socket %s", path)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "rpc/socket:ListenSocket() Could not listen on socket %s", path)
+	}
+	// the socket is created with the umask of the agent, which the directory mode makes harmless until it is set
+	if err = os.Chown(path, -1, gid); err == nil {
+		err = os.Chmod(path, socketFileMode)
+	}
+	if err != nil {
+		l.Close()
+		return nil, errors.Wrapf(err, "rpc/socket:ListenSocket() Could not set the permissions of socket %s", path)
+	}
+	return l, nil
+}
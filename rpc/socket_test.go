@@ -0,0 +1,90 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package rpc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocketGroupID(t *testing.T) {
+	gid, err := SocketGroupID("")
+	assert.NoError(t, err)
+	assert.Equal(t, -1, gid)
+
+	gid, err = SocketGroupID("990")
+	assert.NoError(t, err)
+	assert.Equal(t, 990, gid)
+
+	gid, err = SocketGroupID("root")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, gid)
+
+	_, err = SocketGroupID("no-such-wlagent-group")
+	assert.Error(t, err)
+}
+
+func TestSetupSocketDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "socket")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, "workload-agent")
+	assert.NoError(t, SetupSocketDir(dir+"/", -1))
+	info, err := os.Stat(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, socketDirMode, info.Mode().Perm())
+
+	// an existing directory with loose permissions is tightened, unless it is world-writable
+	assert.NoError(t, os.Chmod(dir, 0755))
+	assert.NoError(t, SetupSocketDir(dir, -1))
+	info, err = os.Stat(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, socketDirMode, info.Mode().Perm())
+
+	assert.NoError(t, os.Chmod(dir, 0777))
+	assert.Error(t, SetupSocketDir(dir, -1))
+
+	file := filepath.Join(tmp, "file")
+	assert.NoError(t, ioutil.WriteFile(file, nil, 0600))
+	assert.Error(t, SetupSocketDir(file, -1))
+
+	if os.Geteuid() == 0 {
+		assert.NoError(t, os.Chmod(dir, 0750))
+		assert.NoError(t, os.Chown(dir, 1000, -1))
+		assert.Error(t, SetupSocketDir(dir, -1))
+	}
+}
+
+func TestListenSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socket")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wlagent.sock")
+	l, err := ListenSocket(path, -1)
+	assert.NoError(t, err)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, socketFileMode, info.Mode().Perm())
+
+	// a socket left behind by a previous run is replaced
+	l.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	assert.NoError(t, l.Close())
+	l, err = ListenSocket(path, -1)
+	assert.NoError(t, err)
+	assert.NoError(t, l.Close())
+
+	file := filepath.Join(dir, "file")
+	assert.NoError(t, ioutil.WriteFile(file, nil, 0600))
+	_, err = ListenSocket(file, -1)
+	assert.Error(t, err)
+}
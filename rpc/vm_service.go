@@ -0,0 +1,273 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package rpc
+
+import (
+	"context"
+	"intel/isecl/lib/common/v4/log/message"
+	"intel/isecl/lib/common/v4/proc"
+	"intel/isecl/lib/common/v4/validation"
+	"intel/isecl/wlagent/v4/filewatch"
+	"intel/isecl/wlagent/v4/flavor"
+	"intel/isecl/wlagent/v4/rpc/vmv1"
+	"intel/isecl/wlagent/v4/util"
+	"intel/isecl/wlagent/v4/wlavm"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// VMService implements version 1 of the gRPC VirtualMachine service defined in vmv1/vm.proto
+type VMService struct {
+	vmv1.UnimplementedVirtualMachineServer
+	Watcher *filewatch.Watcher
+	Version string
+}
+
+// lifecycleFunc is one of wlavm.Prepare, wlavm.Start or wlavm.Stop
+type lifecycleFunc func(ctx context.Context, domainXMLContent string, filewatcher *filewatch.Watcher) error
+
+// Prepare forwards the request to wlavm.Prepare
+func (s *VMService) Prepare(ctx context.Context, request *vmv1.DomainRequest) (*vmv1.VMResult, error) {
+	log.Trace("rpc/vm_service:Prepare() Entering")
+	defer log.Trace("rpc/vm_service:Prepare() Leaving")
+
+	// Passing the false parameter to ensure the prepare vm task is not added to waitgroup if there is pending signal termination on rpc
+	return s.runLifecycle(ctx, wlavm.PhasePrepare, false, request.GetDomainXml(), wlavm.Prepare)
+}
+
+// Start forwards the request to wlavm.Start
+func (s *VMService) Start(ctx context.Context, request *vmv1.DomainRequest) (*vmv1.VMResult, error) {
+	log.Trace("rpc/vm_service:Start() Entering")
+	defer log.Trace("rpc/vm_service:Start() Leaving")
+
+	// Passing the false parameter to ensure the start vm task is not added to waitgroup if there is pending signal termination on rpc
+	return s.runLifecycle(ctx, wlavm.PhaseStart, false, request.GetDomainXml(), wlavm.Start)
+}
+
+// Stop forwards the request to wlavm.Stop
+func (s *VMService) Stop(ctx context.Context, request *vmv1.DomainRequest) (*vmv1.VMResult, error) {
+	log.Trace("rpc/vm_service:Stop() Entering")
+	defer log.Trace("rpc/vm_service:Stop() Leaving")
+
+	// Passing the true parameter to ensure the stop vm task is added to waitgroup as this action needs to be completed
+	// even if there is pending signal termination on rpc
+	return s.runLifecycle(ctx, wlavm.PhaseStop, true, request.GetDomainXml(), wlavm.Stop)
+}
+
+// runLifecycle runs a VM lifecycle phase within the timeout of the phase, or until ctx is done when the client
+// goes away first
+func (s *VMService) runLifecycle(ctx context.Context, phase wlavm.Phase, mustComplete bool, domainXML string, run lifecycleFunc) (*vmv1.VMResult, error) {
+	_, err := proc.AddTask(mustComplete)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not add task for vm %s: %v", phase, err)
+	}
+	defer proc.TaskDone()
+
+	start := time.Now()
+	var result VMResult
+	defer func() {
+		result.observe(phase, start)
+	}()
+
+	if err = validation.ValidateXMLString(domainXML); err != nil {
+		secLog.Errorf("rpc/vm_service:runLifecycle() %s: %s, Invalid domain XML format", phase, message.InvalidInputBadParam)
+		result = newVMResult(phase, &wlavm.VMError{Phase: phase, Code: wlavm.InvalidDomainXML,
+			Message: "invalid domain XML format", Err: err})
+		return result.toProto(), nil
+	}
+
+	ctx, cancel := wlavm.PhaseContext(ctx, phase)
+	defer cancel()
+	// pass in s.Watcher to get the instance to the File System Watcher
+	result = newVMResult(phase, run(ctx, domainXML, s.Watcher))
+	return result.toProto(), nil
+}
+
+// FetchFlavor returns the flavor of the image from the flavor cache or WLS
+func (s *VMService) FetchFlavor(ctx context.Context, request *vmv1.FetchFlavorRequest) (*vmv1.FetchFlavorResponse, error) {
+	log.Trace("rpc/vm_service:FetchFlavor() Entering")
+	defer log.Trace("rpc/vm_service:FetchFlavor() Leaving")
+
+	if err := validation.ValidateUUIDv4(request.GetImageId()); err != nil {
+		secLog.Errorf("rpc/vm_service:FetchFlavor() %s, Invalid image UUID format", message.InvalidInputBadParam)
+		return nil, status.Error(codes.InvalidArgument, "invalid image UUID format")
+	}
+
+	imageFlavor, ok := flavor.Fetch(ctx, request.GetImageId())
+	if !ok {
+		return nil, status.Errorf(codes.Unavailable, "could not fetch the flavor of image %s", request.GetImageId())
+	}
+	return &vmv1.FetchFlavorResponse{Found: imageFlavor != "", Flavor: imageFlavor}, nil
+}
+
+// RetrieveKey returns the key given by key ID or key URL, unwrapped with the TPM binding key
+func (s *VMService) RetrieveKey(ctx context.Context, request *vmv1.RetrieveKeyRequest) (*vmv1.RetrieveKeyResponse, error) {
+	log.Trace("rpc/vm_service:RetrieveKey() Entering")
+	defer log.Trace("rpc/vm_service:RetrieveKey() Leaving")
+
+	var wrappedKey []byte
+	var ok bool
+	switch key := request.GetKey().(type) {
+	case *vmv1.RetrieveKeyRequest_KeyId:
+		wrappedKey, ok = flavor.RetrieveKey(ctx, key.KeyId)
+	case *vmv1.RetrieveKeyRequest_KeyUrl:
+		wrappedKey, ok = flavor.RetrieveKeyWithURL(ctx, key.KeyUrl)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "key ID or key URL is required")
+	}
+	if !ok {
+		return nil, status.Error(codes.Unavailable, "could not retrieve the key")
+	}
+	if len(wrappedKey) == 0 {
+		return nil, status.Error(codes.NotFound, "no key exists for the image")
+	}
+
+	secLog.Infof("rpc/vm_service:RetrieveKey() %s, Unwrapping the retrieved key", message.SU)
+	util.TpmMtx.Lock()
+	key, err := util.UnwrapKey(ctx, wrappedKey)
+	util.TpmMtx.Unlock()
+	if err != nil {
+		log.WithError(err).Error("rpc/vm_service:RetrieveKey() Error while unwrapping the key")
+		return nil, status.Error(codes.Internal, "could not unwrap the key")
+	}
+	return &vmv1.RetrieveKeyResponse{Key: key}, nil
+}
+
+// Status returns the version of the agent, the number of volumes it opened and the image vm counts
+func (s *VMService) Status(ctx context.Context, request *vmv1.StatusRequest) (*vmv1.StatusResponse, error) {
+	log.Trace("rpc/vm_service:Status() Entering")
+	defer log.Trace("rpc/vm_service:Status() Leaving")
+
+	openVolumes, mountedImages, err := wlavm.CountOpenVolumes()
+	if err != nil {
+		log.WithError(err).Error("rpc/vm_service:Status() Error while counting the open volumes")
+		return nil, status.Error(codes.Internal, "could not count the open volumes")
+	}
+
+	response := &vmv1.StatusResponse{
+		Version:       s.Version,
+		OpenVolumes:   int32(openVolumes),
+		MountedImages: int32(mountedImages),
+		ImageVmCounts: make(map[string]int32),
+	}
+	util.MapMtx.RLock()
+	defer util.MapMtx.RUnlock()
+	for imageUUID, association := range util.ImageVMAssociations {
+		response.ImageVmCounts[imageUUID] = int32(association.VMCount)
+	}
+	return response, nil
+}
+
+// ListVolumes returns the dm-crypt volumes opened by the agent
+func (s *VMService) ListVolumes(ctx context.Context, request *vmv1.ListVolumesRequest) (*vmv1.ListVolumesResponse, error) {
+	log.Trace("rpc/vm_service:ListVolumes() Entering")
+	defer log.Trace("rpc/vm_service:ListVolumes() Leaving")
+
+	volumes, err := wlavm.ListVolumes()
+	if err != nil {
+		log.WithError(err).Error("rpc/vm_service:ListVolumes() Error while listing the volumes")
+		return nil, status.Error(codes.Internal, "could not list the volumes")
+	}
+
+	response := &vmv1.ListVolumesResponse{}
+	for _, volume := range volumes {
+		kind := vmv1.Volume_KIND_VM
+		if volume.Kind == wlavm.VolumeKindImage {
+			kind = vmv1.Volume_KIND_IMAGE
+		}
+		response.Volumes = append(response.Volumes, &vmv1.Volume{
+			Uuid:        volume.UUID,
+			Kind:        kind,
+			BackingFile: volume.BackingFile,
+			MountPoint:  volume.MountPoint,
+		})
+	}
+	return response, nil
+}
+
+// Inventory returns the VMs and the decrypted images managed by the agent
+func (s *VMService) Inventory(ctx context.Context, request *vmv1.InventoryRequest) (*vmv1.InventoryResponse, error) {
+	log.Trace("rpc/vm_service:Inventory() Entering")
+	defer log.Trace("rpc/vm_service:Inventory() Leaving")
+
+	inventory, err := wlavm.GetInventory()
+	if err != nil {
+		log.WithError(err).Error("rpc/vm_service:Inventory() Error while listing the VMs and images")
+		return nil, status.Error(codes.Internal, "could not list the VMs and images")
+	}
+
+	response := &vmv1.InventoryResponse{}
+	for _, vm := range inventory.VMs {
+		response.Vms = append(response.Vms, &vmv1.VMInventory{
+			VmUuid:              vm.VMUUID,
+			ImageUuid:           vm.ImageUUID,
+			Phase:               string(vm.Phase),
+			Error:               vm.Error,
+			Device:              vm.Device,
+			MountPoint:          vm.MountPoint,
+			SparseFile:          vm.SparseFile,
+			SparseFileSize:      vm.SparseFileSize,
+			SparseFileAllocated: vm.SparseFileAllocated,
+			TrustReportPosted:   vm.TrustReportPosted,
+			KeyId:               vm.KeyID,
+			PreparedAt:          vm.PreparedAt,
+			StartedAt:           vm.StartedAt,
+			StoppedAt:           vm.StoppedAt,
+		})
+	}
+	for _, image := range inventory.Images {
+		response.Images = append(response.Images, &vmv1.ImageInventory{
+			ImageUuid:           image.ImageUUID,
+			ImagePath:           image.ImagePath,
+			Device:              image.Device,
+			MountPoint:          image.MountPoint,
+			SparseFile:          image.SparseFile,
+			SparseFileSize:      image.SparseFileSize,
+			SparseFileAllocated: image.SparseFileAllocated,
+			VmCount:             int32(image.VMCount),
+			KeyId:               image.KeyID,
+		})
+	}
+	return response, nil
+}
+
+// InventoryFromProto returns the inventory in an Inventory response of the gRPC API
+func InventoryFromProto(response *vmv1.InventoryResponse) wlavm.Inventory {
+	var inventory wlavm.Inventory
+	for _, vm := range response.GetVms() {
+		inventory.VMs = append(inventory.VMs, wlavm.VMInventory{
+			VMUUID:              vm.GetVmUuid(),
+			ImageUUID:           vm.GetImageUuid(),
+			Phase:               wlavm.Phase(vm.GetPhase()),
+			Error:               vm.GetError(),
+			Device:              vm.GetDevice(),
+			MountPoint:          vm.GetMountPoint(),
+			SparseFile:          vm.GetSparseFile(),
+			SparseFileSize:      vm.GetSparseFileSize(),
+			SparseFileAllocated: vm.GetSparseFileAllocated(),
+			TrustReportPosted:   vm.GetTrustReportPosted(),
+			KeyID:               vm.GetKeyId(),
+			PreparedAt:          vm.GetPreparedAt(),
+			StartedAt:           vm.GetStartedAt(),
+			StoppedAt:           vm.GetStoppedAt(),
+		})
+	}
+	for _, image := range response.GetImages() {
+		inventory.Images = append(inventory.Images, wlavm.ImageInventory{
+			ImageUUID:           image.GetImageUuid(),
+			ImagePath:           image.GetImagePath(),
+			Device:              image.GetDevice(),
+			MountPoint:          image.GetMountPoint(),
+			SparseFile:          image.GetSparseFile(),
+			SparseFileSize:      image.GetSparseFileSize(),
+			SparseFileAllocated: image.GetSparseFileAllocated(),
+			VMCount:             int(image.GetVmCount()),
+			KeyID:               image.GetKeyId(),
+		})
+	}
+	return inventory
+}
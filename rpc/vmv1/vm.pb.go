@@ -0,0 +1,1516 @@
+//
+// Copyright (C) 2021 Intel Corporation
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Version 1 of the Workload Agent API, served over gRPC on /var/run/workload-agent/wlagent.sock.
+// Fields and methods may be added to this version, they are never removed nor renumbered. Incompatible
+// changes go to a new package wlagent.vm.v2, served alongside this one.
+//
+// Generate the Go code from the repository root with:
+//   protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative rpc/vmv1/vm.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.25.0
+// 	protoc        (unknown)
+// source: rpc/vmv1/vm.proto
+
+package vmv1
+
+import (
+	proto "github.com/golang/protobuf/proto"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// This is a compile-time assertion that a sufficiently up-to-date version
+// of the legacy proto package is being used.
+const _ = proto.ProtoPackageIsVersion4
+
+type Volume_Kind int32
+
+const (
+	Volume_KIND_UNSPECIFIED Volume_Kind = 0
+	Volume_KIND_VM          Volume_Kind = 1
+	Volume_KIND_IMAGE       Volume_Kind = 2
+)
+
+// Enum value maps for Volume_Kind.
+var (
+	Volume_Kind_name = map[int32]string{
+		0: "KIND_UNSPECIFIED",
+		1: "KIND_VM",
+		2: "KIND_IMAGE",
+	}
+	Volume_Kind_value = map[string]int32{
+		"KIND_UNSPECIFIED": 0,
+		"KIND_VM":          1,
+		"KIND_IMAGE":       2,
+	}
+)
+
+func (x Volume_Kind) Enum() *Volume_Kind {
+	p := new(Volume_Kind)
+	*p = x
+	return p
+}
+
+func (x Volume_Kind) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Volume_Kind) Descriptor() protoreflect.EnumDescriptor {
+	return file_rpc_vmv1_vm_proto_enumTypes[0].Descriptor()
+}
+
+func (Volume_Kind) Type() protoreflect.EnumType {
+	return &file_rpc_vmv1_vm_proto_enumTypes[0]
+}
+
+func (x Volume_Kind) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Volume_Kind.Descriptor instead.
+func (Volume_Kind) EnumDescriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{9, 0}
+}
+
+type DomainRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// libvirt domain XML of the VM
+	DomainXml string `protobuf:"bytes,1,opt,name=domain_xml,json=domainXml,proto3" json:"domain_xml,omitempty"`
+}
+
+func (x *DomainRequest) Reset() {
+	*x = DomainRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DomainRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DomainRequest) ProtoMessage() {}
+
+func (x *DomainRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DomainRequest.ProtoReflect.Descriptor instead.
+func (*DomainRequest) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *DomainRequest) GetDomainXml() string {
+	if x != nil {
+		return x.DomainXml
+	}
+	return ""
+}
+
+// VMResult is the outcome of a VM lifecycle operation. When success is false, phase, code and message
+// describe why the operation failed, code is one of the wlavm error codes such as KEY_DENIED
+type VMResult struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Success bool   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Phase   string `protobuf:"bytes,2,opt,name=phase,proto3" json:"phase,omitempty"`
+	Code    string `protobuf:"bytes,3,opt,name=code,proto3" json:"code,omitempty"`
+	Message string `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (x *VMResult) Reset() {
+	*x = VMResult{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *VMResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VMResult) ProtoMessage() {}
+
+func (x *VMResult) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VMResult.ProtoReflect.Descriptor instead.
+func (*VMResult) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *VMResult) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *VMResult) GetPhase() string {
+	if x != nil {
+		return x.Phase
+	}
+	return ""
+}
+
+func (x *VMResult) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *VMResult) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type FetchFlavorRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ImageId string `protobuf:"bytes,1,opt,name=image_id,json=imageId,proto3" json:"image_id,omitempty"`
+}
+
+func (x *FetchFlavorRequest) Reset() {
+	*x = FetchFlavorRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FetchFlavorRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FetchFlavorRequest) ProtoMessage() {}
+
+func (x *FetchFlavorRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FetchFlavorRequest.ProtoReflect.Descriptor instead.
+func (*FetchFlavorRequest) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *FetchFlavorRequest) GetImageId() string {
+	if x != nil {
+		return x.ImageId
+	}
+	return ""
+}
+
+type FetchFlavorResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// false when no flavor exists for the image
+	Found bool `protobuf:"varint,1,opt,name=found,proto3" json:"found,omitempty"`
+	// JSON encoded image flavor
+	Flavor string `protobuf:"bytes,2,opt,name=flavor,proto3" json:"flavor,omitempty"`
+}
+
+func (x *FetchFlavorResponse) Reset() {
+	*x = FetchFlavorResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FetchFlavorResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FetchFlavorResponse) ProtoMessage() {}
+
+func (x *FetchFlavorResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FetchFlavorResponse.ProtoReflect.Descriptor instead.
+func (*FetchFlavorResponse) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *FetchFlavorResponse) GetFound() bool {
+	if x != nil {
+		return x.Found
+	}
+	return false
+}
+
+func (x *FetchFlavorResponse) GetFlavor() string {
+	if x != nil {
+		return x.Flavor
+	}
+	return ""
+}
+
+type RetrieveKeyRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Key:
+	//	*RetrieveKeyRequest_KeyId
+	//	*RetrieveKeyRequest_KeyUrl
+	Key isRetrieveKeyRequest_Key `protobuf_oneof:"key"`
+}
+
+func (x *RetrieveKeyRequest) Reset() {
+	*x = RetrieveKeyRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RetrieveKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetrieveKeyRequest) ProtoMessage() {}
+
+func (x *RetrieveKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetrieveKeyRequest.ProtoReflect.Descriptor instead.
+func (*RetrieveKeyRequest) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{4}
+}
+
+func (m *RetrieveKeyRequest) GetKey() isRetrieveKeyRequest_Key {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (x *RetrieveKeyRequest) GetKeyId() string {
+	if x, ok := x.GetKey().(*RetrieveKeyRequest_KeyId); ok {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *RetrieveKeyRequest) GetKeyUrl() string {
+	if x, ok := x.GetKey().(*RetrieveKeyRequest_KeyUrl); ok {
+		return x.KeyUrl
+	}
+	return ""
+}
+
+type isRetrieveKeyRequest_Key interface {
+	isRetrieveKeyRequest_Key()
+}
+
+type RetrieveKeyRequest_KeyId struct {
+	// ID of the key of an image whose flavor was fetched before
+	KeyId string `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3,oneof"`
+}
+
+type RetrieveKeyRequest_KeyUrl struct {
+	// transfer URL of the key
+	KeyUrl string `protobuf:"bytes,2,opt,name=key_url,json=keyUrl,proto3,oneof"`
+}
+
+func (*RetrieveKeyRequest_KeyId) isRetrieveKeyRequest_Key() {}
+
+func (*RetrieveKeyRequest_KeyUrl) isRetrieveKeyRequest_Key() {}
+
+type RetrieveKeyResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key []byte `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+}
+
+func (x *RetrieveKeyResponse) Reset() {
+	*x = RetrieveKeyResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RetrieveKeyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetrieveKeyResponse) ProtoMessage() {}
+
+func (x *RetrieveKeyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetrieveKeyResponse.ProtoReflect.Descriptor instead.
+func (*RetrieveKeyResponse) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RetrieveKeyResponse) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+type StatusRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *StatusRequest) Reset() {
+	*x = StatusRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusRequest) ProtoMessage() {}
+
+func (x *StatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusRequest.ProtoReflect.Descriptor instead.
+func (*StatusRequest) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{6}
+}
+
+type StatusResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version       string `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	OpenVolumes   int32  `protobuf:"varint,2,opt,name=open_volumes,json=openVolumes,proto3" json:"open_volumes,omitempty"`
+	MountedImages int32  `protobuf:"varint,3,opt,name=mounted_images,json=mountedImages,proto3" json:"mounted_images,omitempty"`
+	// number of running VMs using each decrypted image, by image UUID
+	ImageVmCounts map[string]int32 `protobuf:"bytes,4,rep,name=image_vm_counts,json=imageVmCounts,proto3" json:"image_vm_counts,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+}
+
+func (x *StatusResponse) Reset() {
+	*x = StatusResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusResponse) ProtoMessage() {}
+
+func (x *StatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusResponse.ProtoReflect.Descriptor instead.
+func (*StatusResponse) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *StatusResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *StatusResponse) GetOpenVolumes() int32 {
+	if x != nil {
+		return x.OpenVolumes
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetMountedImages() int32 {
+	if x != nil {
+		return x.MountedImages
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetImageVmCounts() map[string]int32 {
+	if x != nil {
+		return x.ImageVmCounts
+	}
+	return nil
+}
+
+type ListVolumesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *ListVolumesRequest) Reset() {
+	*x = ListVolumesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListVolumesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListVolumesRequest) ProtoMessage() {}
+
+func (x *ListVolumesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListVolumesRequest.ProtoReflect.Descriptor instead.
+func (*ListVolumesRequest) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{8}
+}
+
+type Volume struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// UUID of the VM or of the image, also the name of the volume under /dev/mapper
+	Uuid string      `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	Kind Volume_Kind `protobuf:"varint,2,opt,name=kind,proto3,enum=wlagent.vm.v1.Volume_Kind" json:"kind,omitempty"`
+	// sparse file backing the volume
+	BackingFile string `protobuf:"bytes,3,opt,name=backing_file,json=backingFile,proto3" json:"backing_file,omitempty"`
+	// mount point of the volume, empty when not mounted
+	MountPoint string `protobuf:"bytes,4,opt,name=mount_point,json=mountPoint,proto3" json:"mount_point,omitempty"`
+}
+
+func (x *Volume) Reset() {
+	*x = Volume{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Volume) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Volume) ProtoMessage() {}
+
+func (x *Volume) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Volume.ProtoReflect.Descriptor instead.
+func (*Volume) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Volume) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+func (x *Volume) GetKind() Volume_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return Volume_KIND_UNSPECIFIED
+}
+
+func (x *Volume) GetBackingFile() string {
+	if x != nil {
+		return x.BackingFile
+	}
+	return ""
+}
+
+func (x *Volume) GetMountPoint() string {
+	if x != nil {
+		return x.MountPoint
+	}
+	return ""
+}
+
+type ListVolumesResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Volumes []*Volume `protobuf:"bytes,1,rep,name=volumes,proto3" json:"volumes,omitempty"`
+}
+
+func (x *ListVolumesResponse) Reset() {
+	*x = ListVolumesResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListVolumesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListVolumesResponse) ProtoMessage() {}
+
+func (x *ListVolumesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListVolumesResponse.ProtoReflect.Descriptor instead.
+func (*ListVolumesResponse) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListVolumesResponse) GetVolumes() []*Volume {
+	if x != nil {
+		return x.Volumes
+	}
+	return nil
+}
+
+type InventoryRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *InventoryRequest) Reset() {
+	*x = InventoryRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *InventoryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InventoryRequest) ProtoMessage() {}
+
+func (x *InventoryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InventoryRequest.ProtoReflect.Descriptor instead.
+func (*InventoryRequest) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{11}
+}
+
+type VMInventory struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	VmUuid    string `protobuf:"bytes,1,opt,name=vm_uuid,json=vmUuid,proto3" json:"vm_uuid,omitempty"`
+	ImageUuid string `protobuf:"bytes,2,opt,name=image_uuid,json=imageUuid,proto3" json:"image_uuid,omitempty"`
+	// last lifecycle phase of the VM, prepare, start or stop, empty for a VM without a state record
+	Phase string `protobuf:"bytes,3,opt,name=phase,proto3" json:"phase,omitempty"`
+	// reason the last phase failed, empty when it succeeded
+	Error string `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	// dm-crypt device of the VM disk, empty when the volume is not open
+	Device string `protobuf:"bytes,5,opt,name=device,proto3" json:"device,omitempty"`
+	// mount point of the volume, empty when not mounted
+	MountPoint string `protobuf:"bytes,6,opt,name=mount_point,json=mountPoint,proto3" json:"mount_point,omitempty"`
+	SparseFile string `protobuf:"bytes,7,opt,name=sparse_file,json=sparseFile,proto3" json:"sparse_file,omitempty"`
+	// apparent size of the sparse file and space allocated to it, in bytes
+	SparseFileSize      int64  `protobuf:"varint,8,opt,name=sparse_file_size,json=sparseFileSize,proto3" json:"sparse_file_size,omitempty"`
+	SparseFileAllocated int64  `protobuf:"varint,9,opt,name=sparse_file_allocated,json=sparseFileAllocated,proto3" json:"sparse_file_allocated,omitempty"`
+	TrustReportPosted   bool   `protobuf:"varint,10,opt,name=trust_report_posted,json=trustReportPosted,proto3" json:"trust_report_posted,omitempty"`
+	KeyId               string `protobuf:"bytes,11,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	// RFC 3339 times the VM was last prepared, started and stopped, empty if never
+	PreparedAt string `protobuf:"bytes,12,opt,name=prepared_at,json=preparedAt,proto3" json:"prepared_at,omitempty"`
+	StartedAt  string `protobuf:"bytes,13,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	StoppedAt  string `protobuf:"bytes,14,opt,name=stopped_at,json=stoppedAt,proto3" json:"stopped_at,omitempty"`
+}
+
+func (x *VMInventory) Reset() {
+	*x = VMInventory{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *VMInventory) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VMInventory) ProtoMessage() {}
+
+func (x *VMInventory) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VMInventory.ProtoReflect.Descriptor instead.
+func (*VMInventory) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *VMInventory) GetVmUuid() string {
+	if x != nil {
+		return x.VmUuid
+	}
+	return ""
+}
+
+func (x *VMInventory) GetImageUuid() string {
+	if x != nil {
+		return x.ImageUuid
+	}
+	return ""
+}
+
+func (x *VMInventory) GetPhase() string {
+	if x != nil {
+		return x.Phase
+	}
+	return ""
+}
+
+func (x *VMInventory) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *VMInventory) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+func (x *VMInventory) GetMountPoint() string {
+	if x != nil {
+		return x.MountPoint
+	}
+	return ""
+}
+
+func (x *VMInventory) GetSparseFile() string {
+	if x != nil {
+		return x.SparseFile
+	}
+	return ""
+}
+
+func (x *VMInventory) GetSparseFileSize() int64 {
+	if x != nil {
+		return x.SparseFileSize
+	}
+	return 0
+}
+
+func (x *VMInventory) GetSparseFileAllocated() int64 {
+	if x != nil {
+		return x.SparseFileAllocated
+	}
+	return 0
+}
+
+func (x *VMInventory) GetTrustReportPosted() bool {
+	if x != nil {
+		return x.TrustReportPosted
+	}
+	return false
+}
+
+func (x *VMInventory) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *VMInventory) GetPreparedAt() string {
+	if x != nil {
+		return x.PreparedAt
+	}
+	return ""
+}
+
+func (x *VMInventory) GetStartedAt() string {
+	if x != nil {
+		return x.StartedAt
+	}
+	return ""
+}
+
+func (x *VMInventory) GetStoppedAt() string {
+	if x != nil {
+		return x.StoppedAt
+	}
+	return ""
+}
+
+type ImageInventory struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ImageUuid string `protobuf:"bytes,1,opt,name=image_uuid,json=imageUuid,proto3" json:"image_uuid,omitempty"`
+	ImagePath string `protobuf:"bytes,2,opt,name=image_path,json=imagePath,proto3" json:"image_path,omitempty"`
+	// dm-crypt device of the decrypted image, empty when the volume is not open
+	Device string `protobuf:"bytes,3,opt,name=device,proto3" json:"device,omitempty"`
+	// mount point of the volume, empty when not mounted
+	MountPoint string `protobuf:"bytes,4,opt,name=mount_point,json=mountPoint,proto3" json:"mount_point,omitempty"`
+	SparseFile string `protobuf:"bytes,5,opt,name=sparse_file,json=sparseFile,proto3" json:"sparse_file,omitempty"`
+	// apparent size of the sparse file and space allocated to it, in bytes
+	SparseFileSize      int64 `protobuf:"varint,6,opt,name=sparse_file_size,json=sparseFileSize,proto3" json:"sparse_file_size,omitempty"`
+	SparseFileAllocated int64 `protobuf:"varint,7,opt,name=sparse_file_allocated,json=sparseFileAllocated,proto3" json:"sparse_file_allocated,omitempty"`
+	// number of running VMs using the image
+	VmCount int32  `protobuf:"varint,8,opt,name=vm_count,json=vmCount,proto3" json:"vm_count,omitempty"`
+	KeyId   string `protobuf:"bytes,9,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+}
+
+func (x *ImageInventory) Reset() {
+	*x = ImageInventory{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ImageInventory) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImageInventory) ProtoMessage() {}
+
+func (x *ImageInventory) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImageInventory.ProtoReflect.Descriptor instead.
+func (*ImageInventory) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ImageInventory) GetImageUuid() string {
+	if x != nil {
+		return x.ImageUuid
+	}
+	return ""
+}
+
+func (x *ImageInventory) GetImagePath() string {
+	if x != nil {
+		return x.ImagePath
+	}
+	return ""
+}
+
+func (x *ImageInventory) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+func (x *ImageInventory) GetMountPoint() string {
+	if x != nil {
+		return x.MountPoint
+	}
+	return ""
+}
+
+func (x *ImageInventory) GetSparseFile() string {
+	if x != nil {
+		return x.SparseFile
+	}
+	return ""
+}
+
+func (x *ImageInventory) GetSparseFileSize() int64 {
+	if x != nil {
+		return x.SparseFileSize
+	}
+	return 0
+}
+
+func (x *ImageInventory) GetSparseFileAllocated() int64 {
+	if x != nil {
+		return x.SparseFileAllocated
+	}
+	return 0
+}
+
+func (x *ImageInventory) GetVmCount() int32 {
+	if x != nil {
+		return x.VmCount
+	}
+	return 0
+}
+
+func (x *ImageInventory) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+type InventoryResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Vms    []*VMInventory    `protobuf:"bytes,1,rep,name=vms,proto3" json:"vms,omitempty"`
+	Images []*ImageInventory `protobuf:"bytes,2,rep,name=images,proto3" json:"images,omitempty"`
+}
+
+func (x *InventoryResponse) Reset() {
+	*x = InventoryResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_vmv1_vm_proto_msgTypes[14]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *InventoryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InventoryResponse) ProtoMessage() {}
+
+func (x *InventoryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_vmv1_vm_proto_msgTypes[14]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InventoryResponse.ProtoReflect.Descriptor instead.
+func (*InventoryResponse) Descriptor() ([]byte, []int) {
+	return file_rpc_vmv1_vm_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *InventoryResponse) GetVms() []*VMInventory {
+	if x != nil {
+		return x.Vms
+	}
+	return nil
+}
+
+func (x *InventoryResponse) GetImages() []*ImageInventory {
+	if x != nil {
+		return x.Images
+	}
+	return nil
+}
+
+var File_rpc_vmv1_vm_proto protoreflect.FileDescriptor
+
+var file_rpc_vmv1_vm_proto_rawDesc = []byte{
+	0x0a, 0x11, 0x72, 0x70, 0x63, 0x2f, 0x76, 0x6d, 0x76, 0x31, 0x2f, 0x76, 0x6d, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x12, 0x0d, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e,
+	0x76, 0x31, 0x22, 0x2e, 0x0a, 0x0d, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x1d, 0x0a, 0x0a, 0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x5f, 0x78, 0x6d,
+	0x6c, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x58,
+	0x6d, 0x6c, 0x22, 0x68, 0x0a, 0x08, 0x56, 0x4d, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x18,
+	0x0a, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x68, 0x61, 0x73,
+	0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x70, 0x68, 0x61, 0x73, 0x65, 0x12, 0x12,
+	0x0a, 0x04, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x63, 0x6f,
+	0x64, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x2f, 0x0a, 0x12,
+	0x46, 0x65, 0x74, 0x63, 0x68, 0x46, 0x6c, 0x61, 0x76, 0x6f, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x49, 0x64, 0x22, 0x43, 0x0a,
+	0x13, 0x46, 0x65, 0x74, 0x63, 0x68, 0x46, 0x6c, 0x61, 0x76, 0x6f, 0x72, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x66, 0x6f, 0x75, 0x6e, 0x64, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x08, 0x52, 0x05, 0x66, 0x6f, 0x75, 0x6e, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x66, 0x6c,
+	0x61, 0x76, 0x6f, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x66, 0x6c, 0x61, 0x76,
+	0x6f, 0x72, 0x22, 0x4f, 0x0a, 0x12, 0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x4b, 0x65,
+	0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x17, 0x0a, 0x06, 0x6b, 0x65, 0x79, 0x5f,
+	0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x48, 0x00, 0x52, 0x05, 0x6b, 0x65, 0x79, 0x49,
+	0x64, 0x12, 0x19, 0x0a, 0x07, 0x6b, 0x65, 0x79, 0x5f, 0x75, 0x72, 0x6c, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x48, 0x00, 0x52, 0x06, 0x6b, 0x65, 0x79, 0x55, 0x72, 0x6c, 0x42, 0x05, 0x0a, 0x03,
+	0x6b, 0x65, 0x79, 0x22, 0x27, 0x0a, 0x13, 0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x4b,
+	0x65, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65,
+	0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x22, 0x0f, 0x0a, 0x0d,
+	0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x90, 0x02,
+	0x0a, 0x0e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x21, 0x0a, 0x0c, 0x6f, 0x70,
+	0x65, 0x6e, 0x5f, 0x76, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x0b, 0x6f, 0x70, 0x65, 0x6e, 0x56, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x73, 0x12, 0x25, 0x0a,
+	0x0e, 0x6d, 0x6f, 0x75, 0x6e, 0x74, 0x65, 0x64, 0x5f, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0d, 0x6d, 0x6f, 0x75, 0x6e, 0x74, 0x65, 0x64, 0x49, 0x6d,
+	0x61, 0x67, 0x65, 0x73, 0x12, 0x58, 0x0a, 0x0f, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x5f, 0x76, 0x6d,
+	0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x30, 0x2e,
+	0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74,
+	0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x2e, 0x49, 0x6d, 0x61,
+	0x67, 0x65, 0x56, 0x6d, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52,
+	0x0d, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x56, 0x6d, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x73, 0x1a, 0x40,
+	0x0a, 0x12, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x56, 0x6d, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x73, 0x45,
+	0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01,
+	0x22, 0x14, 0x0a, 0x12, 0x4c, 0x69, 0x73, 0x74, 0x56, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x73, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0xcb, 0x01, 0x0a, 0x06, 0x56, 0x6f, 0x6c, 0x75, 0x6d,
+	0x65, 0x12, 0x12, 0x0a, 0x04, 0x75, 0x75, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x75, 0x75, 0x69, 0x64, 0x12, 0x2e, 0x0a, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0e, 0x32, 0x1a, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d,
+	0x2e, 0x76, 0x31, 0x2e, 0x56, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x2e, 0x4b, 0x69, 0x6e, 0x64, 0x52,
+	0x04, 0x6b, 0x69, 0x6e, 0x64, 0x12, 0x21, 0x0a, 0x0c, 0x62, 0x61, 0x63, 0x6b, 0x69, 0x6e, 0x67,
+	0x5f, 0x66, 0x69, 0x6c, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x62, 0x61, 0x63,
+	0x6b, 0x69, 0x6e, 0x67, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x6d, 0x6f, 0x75, 0x6e,
+	0x74, 0x5f, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x6d,
+	0x6f, 0x75, 0x6e, 0x74, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x22, 0x39, 0x0a, 0x04, 0x4b, 0x69, 0x6e,
+	0x64, 0x12, 0x14, 0x0a, 0x10, 0x4b, 0x49, 0x4e, 0x44, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43,
+	0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x0b, 0x0a, 0x07, 0x4b, 0x49, 0x4e, 0x44, 0x5f,
+	0x56, 0x4d, 0x10, 0x01, 0x12, 0x0e, 0x0a, 0x0a, 0x4b, 0x49, 0x4e, 0x44, 0x5f, 0x49, 0x4d, 0x41,
+	0x47, 0x45, 0x10, 0x02, 0x22, 0x46, 0x0a, 0x13, 0x4c, 0x69, 0x73, 0x74, 0x56, 0x6f, 0x6c, 0x75,
+	0x6d, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2f, 0x0a, 0x07, 0x76,
+	0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x15, 0x2e, 0x77,
+	0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e, 0x76, 0x31, 0x2e, 0x56, 0x6f, 0x6c,
+	0x75, 0x6d, 0x65, 0x52, 0x07, 0x76, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x73, 0x22, 0x12, 0x0a, 0x10,
+	0x49, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x22, 0xcf, 0x03, 0x0a, 0x0b, 0x56, 0x4d, 0x49, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79,
+	0x12, 0x17, 0x0a, 0x07, 0x76, 0x6d, 0x5f, 0x75, 0x75, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x76, 0x6d, 0x55, 0x75, 0x69, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x69, 0x6d, 0x61,
+	0x67, 0x65, 0x5f, 0x75, 0x75, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x69,
+	0x6d, 0x61, 0x67, 0x65, 0x55, 0x75, 0x69, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x68, 0x61, 0x73,
+	0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x70, 0x68, 0x61, 0x73, 0x65, 0x12, 0x14,
+	0x0a, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65,
+	0x72, 0x72, 0x6f, 0x72, 0x12, 0x16, 0x0a, 0x06, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x12, 0x1f, 0x0a, 0x0b,
+	0x6d, 0x6f, 0x75, 0x6e, 0x74, 0x5f, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0a, 0x6d, 0x6f, 0x75, 0x6e, 0x74, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x12, 0x1f, 0x0a,
+	0x0b, 0x73, 0x70, 0x61, 0x72, 0x73, 0x65, 0x5f, 0x66, 0x69, 0x6c, 0x65, 0x18, 0x07, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x0a, 0x73, 0x70, 0x61, 0x72, 0x73, 0x65, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x28,
+	0x0a, 0x10, 0x73, 0x70, 0x61, 0x72, 0x73, 0x65, 0x5f, 0x66, 0x69, 0x6c, 0x65, 0x5f, 0x73, 0x69,
+	0x7a, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x73, 0x70, 0x61, 0x72, 0x73, 0x65,
+	0x46, 0x69, 0x6c, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x32, 0x0a, 0x15, 0x73, 0x70, 0x61, 0x72,
+	0x73, 0x65, 0x5f, 0x66, 0x69, 0x6c, 0x65, 0x5f, 0x61, 0x6c, 0x6c, 0x6f, 0x63, 0x61, 0x74, 0x65,
+	0x64, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52, 0x13, 0x73, 0x70, 0x61, 0x72, 0x73, 0x65, 0x46,
+	0x69, 0x6c, 0x65, 0x41, 0x6c, 0x6c, 0x6f, 0x63, 0x61, 0x74, 0x65, 0x64, 0x12, 0x2e, 0x0a, 0x13,
+	0x74, 0x72, 0x75, 0x73, 0x74, 0x5f, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x5f, 0x70, 0x6f, 0x73,
+	0x74, 0x65, 0x64, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x08, 0x52, 0x11, 0x74, 0x72, 0x75, 0x73, 0x74,
+	0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x50, 0x6f, 0x73, 0x74, 0x65, 0x64, 0x12, 0x15, 0x0a, 0x06,
+	0x6b, 0x65, 0x79, 0x5f, 0x69, 0x64, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x6b, 0x65,
+	0x79, 0x49, 0x64, 0x12, 0x1f, 0x0a, 0x0b, 0x70, 0x72, 0x65, 0x70, 0x61, 0x72, 0x65, 0x64, 0x5f,
+	0x61, 0x74, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x70, 0x72, 0x65, 0x70, 0x61, 0x72,
+	0x65, 0x64, 0x41, 0x74, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x74, 0x61, 0x72, 0x74, 0x65, 0x64, 0x5f,
+	0x61, 0x74, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x73, 0x74, 0x61, 0x72, 0x74, 0x65,
+	0x64, 0x41, 0x74, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x74, 0x6f, 0x70, 0x70, 0x65, 0x64, 0x5f, 0x61,
+	0x74, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x73, 0x74, 0x6f, 0x70, 0x70, 0x65, 0x64,
+	0x41, 0x74, 0x22, 0xb8, 0x02, 0x0a, 0x0e, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x49, 0x6e, 0x76, 0x65,
+	0x6e, 0x74, 0x6f, 0x72, 0x79, 0x12, 0x1d, 0x0a, 0x0a, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x5f, 0x75,
+	0x75, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x69, 0x6d, 0x61, 0x67, 0x65,
+	0x55, 0x75, 0x69, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x5f, 0x70, 0x61,
+	0x74, 0x68, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x50,
+	0x61, 0x74, 0x68, 0x12, 0x16, 0x0a, 0x06, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x06, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x6d,
+	0x6f, 0x75, 0x6e, 0x74, 0x5f, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0a, 0x6d, 0x6f, 0x75, 0x6e, 0x74, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x12, 0x1f, 0x0a, 0x0b,
+	0x73, 0x70, 0x61, 0x72, 0x73, 0x65, 0x5f, 0x66, 0x69, 0x6c, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0a, 0x73, 0x70, 0x61, 0x72, 0x73, 0x65, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x28, 0x0a,
+	0x10, 0x73, 0x70, 0x61, 0x72, 0x73, 0x65, 0x5f, 0x66, 0x69, 0x6c, 0x65, 0x5f, 0x73, 0x69, 0x7a,
+	0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x73, 0x70, 0x61, 0x72, 0x73, 0x65, 0x46,
+	0x69, 0x6c, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x32, 0x0a, 0x15, 0x73, 0x70, 0x61, 0x72, 0x73,
+	0x65, 0x5f, 0x66, 0x69, 0x6c, 0x65, 0x5f, 0x61, 0x6c, 0x6c, 0x6f, 0x63, 0x61, 0x74, 0x65, 0x64,
+	0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x13, 0x73, 0x70, 0x61, 0x72, 0x73, 0x65, 0x46, 0x69,
+	0x6c, 0x65, 0x41, 0x6c, 0x6c, 0x6f, 0x63, 0x61, 0x74, 0x65, 0x64, 0x12, 0x19, 0x0a, 0x08, 0x76,
+	0x6d, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x76,
+	0x6d, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x15, 0x0a, 0x06, 0x6b, 0x65, 0x79, 0x5f, 0x69, 0x64,
+	0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x6b, 0x65, 0x79, 0x49, 0x64, 0x22, 0x78, 0x0a,
+	0x11, 0x49, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x12, 0x2c, 0x0a, 0x03, 0x76, 0x6d, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x1a, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e, 0x76, 0x31, 0x2e,
+	0x56, 0x4d, 0x49, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x03, 0x76, 0x6d, 0x73,
+	0x12, 0x35, 0x0a, 0x06, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x1d, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e, 0x76, 0x31,
+	0x2e, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x49, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x52,
+	0x06, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x32, 0xea, 0x04, 0x0a, 0x0e, 0x56, 0x69, 0x72, 0x74,
+	0x75, 0x61, 0x6c, 0x4d, 0x61, 0x63, 0x68, 0x69, 0x6e, 0x65, 0x12, 0x40, 0x0a, 0x07, 0x50, 0x72,
+	0x65, 0x70, 0x61, 0x72, 0x65, 0x12, 0x1c, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e,
+	0x76, 0x6d, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x1a, 0x17, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d,
+	0x2e, 0x76, 0x31, 0x2e, 0x56, 0x4d, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x3e, 0x0a, 0x05,
+	0x53, 0x74, 0x61, 0x72, 0x74, 0x12, 0x1c, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e,
+	0x76, 0x6d, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x1a, 0x17, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d,
+	0x2e, 0x76, 0x31, 0x2e, 0x56, 0x4d, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x3d, 0x0a, 0x04,
+	0x53, 0x74, 0x6f, 0x70, 0x12, 0x1c, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76,
+	0x6d, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x17, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e,
+	0x76, 0x31, 0x2e, 0x56, 0x4d, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x54, 0x0a, 0x0b, 0x46,
+	0x65, 0x74, 0x63, 0x68, 0x46, 0x6c, 0x61, 0x76, 0x6f, 0x72, 0x12, 0x21, 0x2e, 0x77, 0x6c, 0x61,
+	0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x65, 0x74, 0x63, 0x68,
+	0x46, 0x6c, 0x61, 0x76, 0x6f, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x22, 0x2e,
+	0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x65,
+	0x74, 0x63, 0x68, 0x46, 0x6c, 0x61, 0x76, 0x6f, 0x72, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x54, 0x0a, 0x0b, 0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x4b, 0x65, 0x79,
+	0x12, 0x21, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e, 0x76, 0x31,
+	0x2e, 0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x4b, 0x65, 0x79, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x1a, 0x22, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d,
+	0x2e, 0x76, 0x31, 0x2e, 0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x4b, 0x65, 0x79, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x45, 0x0a, 0x06, 0x53, 0x74, 0x61, 0x74, 0x75,
+	0x73, 0x12, 0x1c, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e, 0x76,
+	0x31, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x1d, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e, 0x76, 0x31, 0x2e,
+	0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x54,
+	0x0a, 0x0b, 0x4c, 0x69, 0x73, 0x74, 0x56, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x73, 0x12, 0x21, 0x2e,
+	0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x69,
+	0x73, 0x74, 0x56, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x22, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e, 0x76, 0x31,
+	0x2e, 0x4c, 0x69, 0x73, 0x74, 0x56, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x4e, 0x0a, 0x09, 0x49, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72,
+	0x79, 0x12, 0x1f, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e, 0x76,
+	0x31, 0x2e, 0x49, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x20, 0x2e, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2e, 0x76, 0x6d, 0x2e,
+	0x76, 0x31, 0x2e, 0x49, 0x6e, 0x76, 0x65, 0x6e, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x42, 0x21, 0x5a, 0x1f, 0x69, 0x6e, 0x74, 0x65, 0x6c, 0x2f, 0x69, 0x73,
+	0x65, 0x63, 0x6c, 0x2f, 0x77, 0x6c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x2f, 0x76, 0x34, 0x2f, 0x72,
+	0x70, 0x63, 0x2f, 0x76, 0x6d, 0x76, 0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_rpc_vmv1_vm_proto_rawDescOnce sync.Once
+	file_rpc_vmv1_vm_proto_rawDescData = file_rpc_vmv1_vm_proto_rawDesc
+)
+
+func file_rpc_vmv1_vm_proto_rawDescGZIP() []byte {
+	file_rpc_vmv1_vm_proto_rawDescOnce.Do(func() {
+		file_rpc_vmv1_vm_proto_rawDescData = protoimpl.X.CompressGZIP(file_rpc_vmv1_vm_proto_rawDescData)
+	})
+	return file_rpc_vmv1_vm_proto_rawDescData
+}
+
+var file_rpc_vmv1_vm_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_rpc_vmv1_vm_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
+var file_rpc_vmv1_vm_proto_goTypes = []interface{}{
+	(Volume_Kind)(0),            // 0: wlagent.vm.v1.Volume.Kind
+	(*DomainRequest)(nil),       // 1: wlagent.vm.v1.DomainRequest
+	(*VMResult)(nil),            // 2: wlagent.vm.v1.VMResult
+	(*FetchFlavorRequest)(nil),  // 3: wlagent.vm.v1.FetchFlavorRequest
+	(*FetchFlavorResponse)(nil), // 4: wlagent.vm.v1.FetchFlavorResponse
+	(*RetrieveKeyRequest)(nil),  // 5: wlagent.vm.v1.RetrieveKeyRequest
+	(*RetrieveKeyResponse)(nil), // 6: wlagent.vm.v1.RetrieveKeyResponse
+	(*StatusRequest)(nil),       // 7: wlagent.vm.v1.StatusRequest
+	(*StatusResponse)(nil),      // 8: wlagent.vm.v1.StatusResponse
+	(*ListVolumesRequest)(nil),  // 9: wlagent.vm.v1.ListVolumesRequest
+	(*Volume)(nil),              // 10: wlagent.vm.v1.Volume
+	(*ListVolumesResponse)(nil), // 11: wlagent.vm.v1.ListVolumesResponse
+	(*InventoryRequest)(nil),    // 12: wlagent.vm.v1.InventoryRequest
+	(*VMInventory)(nil),         // 13: wlagent.vm.v1.VMInventory
+	(*ImageInventory)(nil),      // 14: wlagent.vm.v1.ImageInventory
+	(*InventoryResponse)(nil),   // 15: wlagent.vm.v1.InventoryResponse
+	nil,                         // 16: wlagent.vm.v1.StatusResponse.ImageVmCountsEntry
+}
+var file_rpc_vmv1_vm_proto_depIdxs = []int32{
+	16, // 0: wlagent.vm.v1.StatusResponse.image_vm_counts:type_name -> wlagent.vm.v1.StatusResponse.ImageVmCountsEntry
+	0,  // 1: wlagent.vm.v1.Volume.kind:type_name -> wlagent.vm.v1.Volume.Kind
+	10, // 2: wlagent.vm.v1.ListVolumesResponse.volumes:type_name -> wlagent.vm.v1.Volume
+	13, // 3: wlagent.vm.v1.InventoryResponse.vms:type_name -> wlagent.vm.v1.VMInventory
+	14, // 4: wlagent.vm.v1.InventoryResponse.images:type_name -> wlagent.vm.v1.ImageInventory
+	1,  // 5: wlagent.vm.v1.VirtualMachine.Prepare:input_type -> wlagent.vm.v1.DomainRequest
+	1,  // 6: wlagent.vm.v1.VirtualMachine.Start:input_type -> wlagent.vm.v1.DomainRequest
+	1,  // 7: wlagent.vm.v1.VirtualMachine.Stop:input_type -> wlagent.vm.v1.DomainRequest
+	3,  // 8: wlagent.vm.v1.VirtualMachine.FetchFlavor:input_type -> wlagent.vm.v1.FetchFlavorRequest
+	5,  // 9: wlagent.vm.v1.VirtualMachine.RetrieveKey:input_type -> wlagent.vm.v1.RetrieveKeyRequest
+	7,  // 10: wlagent.vm.v1.VirtualMachine.Status:input_type -> wlagent.vm.v1.StatusRequest
+	9,  // 11: wlagent.vm.v1.VirtualMachine.ListVolumes:input_type -> wlagent.vm.v1.ListVolumesRequest
+	12, // 12: wlagent.vm.v1.VirtualMachine.Inventory:input_type -> wlagent.vm.v1.InventoryRequest
+	2,  // 13: wlagent.vm.v1.VirtualMachine.Prepare:output_type -> wlagent.vm.v1.VMResult
+	2,  // 14: wlagent.vm.v1.VirtualMachine.Start:output_type -> wlagent.vm.v1.VMResult
+	2,  // 15: wlagent.vm.v1.VirtualMachine.Stop:output_type -> wlagent.vm.v1.VMResult
+	4,  // 16: wlagent.vm.v1.VirtualMachine.FetchFlavor:output_type -> wlagent.vm.v1.FetchFlavorResponse
+	6,  // 17: wlagent.vm.v1.VirtualMachine.RetrieveKey:output_type -> wlagent.vm.v1.RetrieveKeyResponse
+	8,  // 18: wlagent.vm.v1.VirtualMachine.Status:output_type -> wlagent.vm.v1.StatusResponse
+	11, // 19: wlagent.vm.v1.VirtualMachine.ListVolumes:output_type -> wlagent.vm.v1.ListVolumesResponse
+	15, // 20: wlagent.vm.v1.VirtualMachine.Inventory:output_type -> wlagent.vm.v1.InventoryResponse
+	13, // [13:21] is the sub-list for method output_type
+	5,  // [5:13] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_rpc_vmv1_vm_proto_init() }
+func file_rpc_vmv1_vm_proto_init() {
+	if File_rpc_vmv1_vm_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_rpc_vmv1_vm_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DomainRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*VMResult); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FetchFlavorRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FetchFlavorResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RetrieveKeyRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RetrieveKeyResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StatusRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StatusResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListVolumesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Volume); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListVolumesResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*InventoryRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*VMInventory); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ImageInventory); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_vmv1_vm_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*InventoryResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_rpc_vmv1_vm_proto_msgTypes[4].OneofWrappers = []interface{}{
+		(*RetrieveKeyRequest_KeyId)(nil),
+		(*RetrieveKeyRequest_KeyUrl)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_rpc_vmv1_vm_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   16,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_rpc_vmv1_vm_proto_goTypes,
+		DependencyIndexes: file_rpc_vmv1_vm_proto_depIdxs,
+		EnumInfos:         file_rpc_vmv1_vm_proto_enumTypes,
+		MessageInfos:      file_rpc_vmv1_vm_proto_msgTypes,
+	}.Build()
+	File_rpc_vmv1_vm_proto = out.File
+	file_rpc_vmv1_vm_proto_rawDesc = nil
+	file_rpc_vmv1_vm_proto_goTypes = nil
+	file_rpc_vmv1_vm_proto_depIdxs = nil
+}
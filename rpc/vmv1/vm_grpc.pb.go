@@ -0,0 +1,365 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package vmv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion7
+
+// VirtualMachineClient is the client API for VirtualMachine service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type VirtualMachineClient interface {
+	// Prepare creates the encrypted volumes of a VM before libvirt starts it
+	Prepare(ctx context.Context, in *DomainRequest, opts ...grpc.CallOption) (*VMResult, error)
+	// Start records the start of a VM and sends its instance trust report to WLS
+	Start(ctx context.Context, in *DomainRequest, opts ...grpc.CallOption) (*VMResult, error)
+	// Stop closes the volumes of a stopped VM, and of its image when no other VM uses it
+	Stop(ctx context.Context, in *DomainRequest, opts ...grpc.CallOption) (*VMResult, error)
+	// FetchFlavor returns the signed flavor of an image from WLS or the flavor cache
+	FetchFlavor(ctx context.Context, in *FetchFlavorRequest, opts ...grpc.CallOption) (*FetchFlavorResponse, error)
+	// RetrieveKey returns an image key, unwrapped with the TPM binding key
+	RetrieveKey(ctx context.Context, in *RetrieveKeyRequest, opts ...grpc.CallOption) (*RetrieveKeyResponse, error)
+	// Status returns the version of the agent and the state of its volumes
+	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	// ListVolumes lists the dm-crypt volumes opened by the agent
+	ListVolumes(ctx context.Context, in *ListVolumesRequest, opts ...grpc.CallOption) (*ListVolumesResponse, error)
+	// Inventory lists the VMs and the decrypted images managed by the agent
+	Inventory(ctx context.Context, in *InventoryRequest, opts ...grpc.CallOption) (*InventoryResponse, error)
+}
+
+type virtualMachineClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVirtualMachineClient(cc grpc.ClientConnInterface) VirtualMachineClient {
+	return &virtualMachineClient{cc}
+}
+
+func (c *virtualMachineClient) Prepare(ctx context.Context, in *DomainRequest, opts ...grpc.CallOption) (*VMResult, error) {
+	out := new(VMResult)
+	err := c.cc.Invoke(ctx, "/wlagent.vm.v1.VirtualMachine/Prepare", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *virtualMachineClient) Start(ctx context.Context, in *DomainRequest, opts ...grpc.CallOption) (*VMResult, error) {
+	out := new(VMResult)
+	err := c.cc.Invoke(ctx, "/wlagent.vm.v1.VirtualMachine/Start", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *virtualMachineClient) Stop(ctx context.Context, in *DomainRequest, opts ...grpc.CallOption) (*VMResult, error) {
+	out := new(VMResult)
+	err := c.cc.Invoke(ctx, "/wlagent.vm.v1.VirtualMachine/Stop", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *virtualMachineClient) FetchFlavor(ctx context.Context, in *FetchFlavorRequest, opts ...grpc.CallOption) (*FetchFlavorResponse, error) {
+	out := new(FetchFlavorResponse)
+	err := c.cc.Invoke(ctx, "/wlagent.vm.v1.VirtualMachine/FetchFlavor", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *virtualMachineClient) RetrieveKey(ctx context.Context, in *RetrieveKeyRequest, opts ...grpc.CallOption) (*RetrieveKeyResponse, error) {
+	out := new(RetrieveKeyResponse)
+	err := c.cc.Invoke(ctx, "/wlagent.vm.v1.VirtualMachine/RetrieveKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *virtualMachineClient) Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, "/wlagent.vm.v1.VirtualMachine/Status", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *virtualMachineClient) ListVolumes(ctx context.Context, in *ListVolumesRequest, opts ...grpc.CallOption) (*ListVolumesResponse, error) {
+	out := new(ListVolumesResponse)
+	err := c.cc.Invoke(ctx, "/wlagent.vm.v1.VirtualMachine/ListVolumes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *virtualMachineClient) Inventory(ctx context.Context, in *InventoryRequest, opts ...grpc.CallOption) (*InventoryResponse, error) {
+	out := new(InventoryResponse)
+	err := c.cc.Invoke(ctx, "/wlagent.vm.v1.VirtualMachine/Inventory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// VirtualMachineServer is the server API for VirtualMachine service.
+// All implementations must embed UnimplementedVirtualMachineServer
+// for forward compatibility
+type VirtualMachineServer interface {
+	// Prepare creates the encrypted volumes of a VM before libvirt starts it
+	Prepare(context.Context, *DomainRequest) (*VMResult, error)
+	// Start records the start of a VM and sends its instance trust report to WLS
+	Start(context.Context, *DomainRequest) (*VMResult, error)
+	// Stop closes the volumes of a stopped VM, and of its image when no other VM uses it
+	Stop(context.Context, *DomainRequest) (*VMResult, error)
+	// FetchFlavor returns the signed flavor of an image from WLS or the flavor cache
+	FetchFlavor(context.Context, *FetchFlavorRequest) (*FetchFlavorResponse, error)
+	// RetrieveKey returns an image key, unwrapped with the TPM binding key
+	RetrieveKey(context.Context, *RetrieveKeyRequest) (*RetrieveKeyResponse, error)
+	// Status returns the version of the agent and the state of its volumes
+	Status(context.Context, *StatusRequest) (*StatusResponse, error)
+	// ListVolumes lists the dm-crypt volumes opened by the agent
+	ListVolumes(context.Context, *ListVolumesRequest) (*ListVolumesResponse, error)
+	// Inventory lists the VMs and the decrypted images managed by the agent
+	Inventory(context.Context, *InventoryRequest) (*InventoryResponse, error)
+	mustEmbedUnimplementedVirtualMachineServer()
+}
+
+// UnimplementedVirtualMachineServer must be embedded to have forward compatible implementations.
+type UnimplementedVirtualMachineServer struct {
+}
+
+func (UnimplementedVirtualMachineServer) Prepare(context.Context, *DomainRequest) (*VMResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Prepare not implemented")
+}
+func (UnimplementedVirtualMachineServer) Start(context.Context, *DomainRequest) (*VMResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Start not implemented")
+}
+func (UnimplementedVirtualMachineServer) Stop(context.Context, *DomainRequest) (*VMResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stop not implemented")
+}
+func (UnimplementedVirtualMachineServer) FetchFlavor(context.Context, *FetchFlavorRequest) (*FetchFlavorResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchFlavor not implemented")
+}
+func (UnimplementedVirtualMachineServer) RetrieveKey(context.Context, *RetrieveKeyRequest) (*RetrieveKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RetrieveKey not implemented")
+}
+func (UnimplementedVirtualMachineServer) Status(context.Context, *StatusRequest) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedVirtualMachineServer) ListVolumes(context.Context, *ListVolumesRequest) (*ListVolumesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVolumes not implemented")
+}
+func (UnimplementedVirtualMachineServer) Inventory(context.Context, *InventoryRequest) (*InventoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Inventory not implemented")
+}
+func (UnimplementedVirtualMachineServer) mustEmbedUnimplementedVirtualMachineServer() {}
+
+// UnsafeVirtualMachineServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VirtualMachineServer will
+// result in compilation errors.
+type UnsafeVirtualMachineServer interface {
+	mustEmbedUnimplementedVirtualMachineServer()
+}
+
+func RegisterVirtualMachineServer(s grpc.ServiceRegistrar, srv VirtualMachineServer) {
+	s.RegisterService(&_VirtualMachine_serviceDesc, srv)
+}
+
+func _VirtualMachine_Prepare_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DomainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VirtualMachineServer).Prepare(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wlagent.vm.v1.VirtualMachine/Prepare",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VirtualMachineServer).Prepare(ctx, req.(*DomainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VirtualMachine_Start_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DomainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VirtualMachineServer).Start(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wlagent.vm.v1.VirtualMachine/Start",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VirtualMachineServer).Start(ctx, req.(*DomainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VirtualMachine_Stop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DomainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VirtualMachineServer).Stop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wlagent.vm.v1.VirtualMachine/Stop",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VirtualMachineServer).Stop(ctx, req.(*DomainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VirtualMachine_FetchFlavor_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchFlavorRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VirtualMachineServer).FetchFlavor(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wlagent.vm.v1.VirtualMachine/FetchFlavor",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VirtualMachineServer).FetchFlavor(ctx, req.(*FetchFlavorRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VirtualMachine_RetrieveKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetrieveKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VirtualMachineServer).RetrieveKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wlagent.vm.v1.VirtualMachine/RetrieveKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VirtualMachineServer).RetrieveKey(ctx, req.(*RetrieveKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VirtualMachine_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VirtualMachineServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wlagent.vm.v1.VirtualMachine/Status",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VirtualMachineServer).Status(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VirtualMachine_ListVolumes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVolumesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VirtualMachineServer).ListVolumes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wlagent.vm.v1.VirtualMachine/ListVolumes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VirtualMachineServer).ListVolumes(ctx, req.(*ListVolumesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VirtualMachine_Inventory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InventoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VirtualMachineServer).Inventory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wlagent.vm.v1.VirtualMachine/Inventory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VirtualMachineServer).Inventory(ctx, req.(*InventoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _VirtualMachine_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "wlagent.vm.v1.VirtualMachine",
+	HandlerType: (*VirtualMachineServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Prepare",
+			Handler:    _VirtualMachine_Prepare_Handler,
+		},
+		{
+			MethodName: "Start",
+			Handler:    _VirtualMachine_Start_Handler,
+		},
+		{
+			MethodName: "Stop",
+			Handler:    _VirtualMachine_Stop_Handler,
+		},
+		{
+			MethodName: "FetchFlavor",
+			Handler:    _VirtualMachine_FetchFlavor_Handler,
+		},
+		{
+			MethodName: "RetrieveKey",
+			Handler:    _VirtualMachine_RetrieveKey_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _VirtualMachine_Status_Handler,
+		},
+		{
+			MethodName: "ListVolumes",
+			Handler:    _VirtualMachine_ListVolumes_Handler,
+		},
+		{
+			MethodName: "Inventory",
+			Handler:    _VirtualMachine_Inventory_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "rpc/vmv1/vm.proto",
+}
@@ -13,6 +13,7 @@ import (
 	"intel/isecl/wlagent/v4/common"
 	"intel/isecl/wlagent/v4/config"
 	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/flavor"
 	"os"
 
 	"github.com/pkg/errors"
@@ -51,6 +52,12 @@ func (bk BindingKey) Run(c csetup.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "setup/create_binding_key:Run() Error while generating tpm certified binding key")
 	}
+
+	// keys cached for the previous binding key can not be unbound anymore
+	err = flavor.PurgeCache("")
+	if err != nil {
+		log.WithError(err).Warn("setup/create_binding_key:Run() Error while purging the flavor cache")
+	}
 	return nil
 }
 
@@ -10,6 +10,8 @@ import (
 	csetup "intel/isecl/lib/common/v4/setup"
 	"intel/isecl/wlagent/v4/config"
 	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/metrics"
+	"intel/isecl/wlagent/v4/rpc"
 	"strconv"
 	"strings"
 
@@ -83,6 +85,49 @@ func (uc Update_Service_Config) Run(c csetup.Context) error {
 		config.Configuration.LogMaxLength = consts.DefaultLogEntryMaxlength
 	}
 
+	setFlavorCacheTTL(c)
+
+	metricsListenAddress, err := c.GetenvString(consts.MetricsListenAddrEnv, "Metrics listen address")
+	if err == nil && metricsListenAddress != "" {
+		if err = metrics.ValidateListenAddress(metricsListenAddress); err != nil {
+			return errors.Wrapf(err, "%s is not valid", consts.MetricsListenAddrEnv)
+		}
+		config.Configuration.MetricsListenAddress = metricsListenAddress
+	}
+
+	socketGroup, err := c.GetenvString(consts.SocketGroupEnv, "Group owning the agent socket")
+	if err == nil && socketGroup != "" {
+		if _, err = rpc.SocketGroupID(socketGroup); err != nil {
+			return errors.Wrapf(err, "%s is not valid", consts.SocketGroupEnv)
+		}
+		config.Configuration.SocketGroup = socketGroup
+	}
+
+	timeouts := &config.Configuration.VMTimeouts
+	for _, timeout := range []struct {
+		env     string
+		seconds *int
+	}{
+		{consts.PrepareTimeoutEnv, &timeouts.Prepare},
+		{consts.StartTimeoutEnv, &timeouts.Start},
+		{consts.StopTimeoutEnv, &timeouts.Stop},
+	} {
+		seconds, err := c.GetenvInt(timeout.env, "VM lifecycle phase timeout in seconds")
+		if err == nil && seconds > 0 {
+			*timeout.seconds = seconds
+		} else if *timeout.seconds <= 0 {
+			log.Info(timeout.env, " is not set or invalid (should be > 0), using the default timeout")
+		}
+	}
+
+	perVMKey, err := c.GetenvString(consts.PerVMKeyEnv, "Encrypt the volume of each VM with a key of its own")
+	if err == nil && perVMKey != "" {
+		config.Configuration.PerVMKey, err = strconv.ParseBool(perVMKey)
+		if err != nil {
+			log.Info("Error while parsing the variable ", consts.PerVMKeyEnv, " setting to default value false")
+		}
+	}
+
 	config.Configuration.LogEnableStdout = false
 	logEnableStdout, err := c.GetenvString(consts.EnableConsoleLogEnv, "Workload Agent Enable standard output")
 	if err == nil && logEnableStdout != "" {
@@ -95,6 +140,19 @@ func (uc Update_Service_Config) Run(c csetup.Context) error {
 	return config.Save()
 }
 
+// setFlavorCacheTTL sets the flavor cache TTL from the environment. The TTL set by an earlier setup, 0 included, is
+// kept when the environment does not set a valid one
+func setFlavorCacheTTL(c csetup.Context) {
+	flavorCacheTTL, err := c.GetenvInt(consts.FlavorCacheTTLEnv, "Flavor cache TTL in seconds")
+	if err == nil && flavorCacheTTL >= 0 {
+		config.Configuration.FlavorCacheTTL = &flavorCacheTTL
+	} else if config.Configuration.FlavorCacheTTL != nil {
+		log.Info("No change in Flavor Cache TTL")
+	} else {
+		log.Info(consts.FlavorCacheTTLEnv, " is not set or invalid (should be >= 0), using default value: ", consts.DefaultFlavorCacheTTL)
+	}
+}
+
 func (uc Update_Service_Config) Validate(c csetup.Context) error {
 	log.Trace("setup/update_service_config:Validate() Entering")
 	defer log.Trace("setup/update_service_config:Validate() Leaving")
@@ -0,0 +1,46 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package setup
+
+import (
+	csetup "intel/isecl/lib/common/v4/setup"
+	"intel/isecl/wlagent/v4/config"
+	"intel/isecl/wlagent/v4/consts"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetFlavorCacheTTL(t *testing.T) {
+	defer func(ttl *int) {
+		config.Configuration.FlavorCacheTTL = ttl
+		os.Unsetenv(consts.FlavorCacheTTLEnv)
+	}(config.Configuration.FlavorCacheTTL)
+	var c csetup.Context
+
+	// a configuration without a TTL, such as one from an earlier release, uses the default
+	config.Configuration.FlavorCacheTTL = nil
+	os.Unsetenv(consts.FlavorCacheTTLEnv)
+	setFlavorCacheTTL(c)
+	assert.Nil(t, config.Configuration.FlavorCacheTTL)
+	assert.Equal(t, consts.DefaultFlavorCacheTTL, config.FlavorCacheTTL())
+
+	assert.NoError(t, os.Setenv(consts.FlavorCacheTTLEnv, "0"))
+	setFlavorCacheTTL(c)
+	assert.Equal(t, 0, config.FlavorCacheTTL())
+
+	// running setup again without the variable, or with an invalid one, keeps the cache disabled
+	os.Unsetenv(consts.FlavorCacheTTLEnv)
+	setFlavorCacheTTL(c)
+	assert.Equal(t, 0, config.FlavorCacheTTL())
+	assert.NoError(t, os.Setenv(consts.FlavorCacheTTLEnv, "-1"))
+	setFlavorCacheTTL(c)
+	assert.Equal(t, 0, config.FlavorCacheTTL())
+
+	assert.NoError(t, os.Setenv(consts.FlavorCacheTTLEnv, "60"))
+	setFlavorCacheTTL(c)
+	assert.Equal(t, 60, config.FlavorCacheTTL())
+}
@@ -0,0 +1,32 @@
+#!/bin/bash
+
+COMPONENT_NAME=workload-agent
+OLD_VM_STATE_PATH=/var/run/$COMPONENT_NAME/vm-state
+VM_STATE_PATH=/var/lib/$COMPONENT_NAME/vm-state
+
+# Do nothing for container deployment, the container does not launch VMs
+if [ -f "/.container-env" ]; then
+  exit 0
+fi
+
+# the VM state moved out of /var/run, which does not survive a reboot. The records of the running VMs are moved along
+echo "Starting $COMPONENT_NAME config upgrade to v4.2.0"
+mkdir -p $VM_STATE_PATH
+if [ $? -ne 0 ]; then
+  echo "failed to create $VM_STATE_PATH"
+  exit 1
+fi
+chmod 700 /var/lib/$COMPONENT_NAME $VM_STATE_PATH
+if [ -d $OLD_VM_STATE_PATH ]; then
+  for record in $OLD_VM_STATE_PATH/*.json; do
+    [ -f "$record" ] || continue
+    mv -n "$record" $VM_STATE_PATH/
+    if [ $? -ne 0 ]; then
+      echo "failed to move the VM state record $record to $VM_STATE_PATH"
+      exit 1
+    fi
+  done
+  rm -rf $OLD_VM_STATE_PATH
+fi
+
+echo "Completed $COMPONENT_NAME config upgrade to v4.2.0"
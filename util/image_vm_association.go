@@ -0,0 +1,235 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"intel/isecl/wlagent/v4/consts"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const imageVMAssociationSchemaVersion = 1
+
+var ImageVMAssociations = make(map[string]*ImageVMAssociation)
+var MapMtx sync.RWMutex
+
+var (
+	imageVMAssociationFilePath = consts.RunDirPath + consts.ImageVmCountAssociationFileName
+	// serializes writers so that a stale snapshot of the map never replaces a newer one
+	saveMtx sync.Mutex
+)
+
+type ImageVMAssociation struct {
+	ImagePath string `yaml:"imagepath"`
+	VMCount   int    `yaml:"vmcount"`
+}
+
+// imageVMAssociationStore is the on-disk representation of the image vm association file
+type imageVMAssociationStore struct {
+	Version      int                            `yaml:"version"`
+	Checksum     string                         `yaml:"checksum"`
+	Associations map[string]*ImageVMAssociation `yaml:"associations"`
+}
+
+// LoadImageVMAssociation method loads image vm association from yaml file, or from its backup when the file is missing
+// or does not match its checksum, as left by a crash in the middle of SaveImageVMAssociation
+func LoadImageVMAssociation() error {
+	log.Trace("util/image_vm_association:LoadImageVMAssociation() Entering")
+	defer log.Trace("util/image_vm_association:LoadImageVMAssociation() Leaving")
+
+	log.Info("Reading image vm association file.")
+	saveMtx.Lock()
+	defer saveMtx.Unlock()
+
+	associations, err := readImageVMAssociationFile(imageVMAssociationFilePath)
+	if err != nil {
+		backupPath := imageVMAssociationFilePath + ".bak"
+		log.WithError(err).Warnf("util/image_vm_association:LoadImageVMAssociation() Image vm association file %s is "+
+			"not usable, recovering from %s", imageVMAssociationFilePath, backupPath)
+		var backupErr error
+		associations, backupErr = readImageVMAssociationFile(backupPath)
+		if backupErr != nil {
+			// nothing to recover from, start over with no associations rather than refusing to start.
+			// The vm counts are rebuilt from the volumes in use when the agent reconciles its state
+			log.WithError(backupErr).Errorf("util/image_vm_association:LoadImageVMAssociation() Image vm association "+
+				"backup %s is not usable, starting with empty associations", backupPath)
+			associations = make(map[string]*ImageVMAssociation)
+		}
+		// persist the recovered associations so that the next load does not need to recover again. The
+		// unusable file is removed first so that it does not replace the backup
+		if err = os.Remove(imageVMAssociationFilePath); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "util/image_vm_association:LoadImageVMAssociation() Error removing %s", imageVMAssociationFilePath)
+		}
+		if err = writeImageVMAssociationFile(associations); err != nil {
+			return errors.Wrap(err, "util/image_vm_association:LoadImageVMAssociation() Error saving recovered image vm associations")
+		}
+	}
+
+	MapMtx.Lock()
+	ImageVMAssociations = associations
+	MapMtx.Unlock()
+	return nil
+}
+
+// SaveImageVMAssociation method saves vm image association to yaml file. The new file is written and synced under a
+// temporary name and renamed over the current file, which is kept as the backup
+func SaveImageVMAssociation() error {
+	log.Trace("util/image_vm_association:SaveImageVMAssociation() Entering")
+	defer log.Trace("util/image_vm_association:SaveImageVMAssociation() Leaving")
+
+	log.Infof("util/image_vm_association:SaveImageVMAssociation() Writing to image vm association file %s", imageVMAssociationFilePath)
+	saveMtx.Lock()
+	defer saveMtx.Unlock()
+
+	MapMtx.RLock()
+	associations := make(map[string]*ImageVMAssociation, len(ImageVMAssociations))
+	for imageUUID, association := range ImageVMAssociations {
+		associationCopy := *association
+		associations[imageUUID] = &associationCopy
+	}
+	MapMtx.RUnlock()
+
+	return writeImageVMAssociationFile(associations)
+}
+
+// readImageVMAssociationFile reads and verifies one generation of the image vm association file.
+// Files written before the schema was versioned hold the bare associations map and are accepted as is.
+func readImageVMAssociationFile(filePath string) (map[string]*ImageVMAssociation, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) && filePath == imageVMAssociationFilePath {
+		if _, backupErr := os.Stat(filePath + ".bak"); os.IsNotExist(backupErr) {
+			// first start of the agent, there is nothing to load
+			return make(map[string]*ImageVMAssociation), nil
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading %s", filePath)
+	}
+
+	var store imageVMAssociationStore
+	err = yaml.Unmarshal(content, &store)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing %s", filePath)
+	}
+
+	switch store.Version {
+	case 0:
+		associations := make(map[string]*ImageVMAssociation)
+		err = yaml.Unmarshal(content, &associations)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error parsing %s", filePath)
+		}
+		return validateImageVMAssociations(associations)
+	case imageVMAssociationSchemaVersion:
+		if store.Associations == nil {
+			store.Associations = make(map[string]*ImageVMAssociation)
+		}
+		checksum, err := imageVMAssociationChecksum(store.Associations)
+		if err != nil {
+			return nil, err
+		}
+		if checksum != store.Checksum {
+			return nil, errors.Errorf("Checksum mismatch in %s, the file is corrupted", filePath)
+		}
+		return validateImageVMAssociations(store.Associations)
+	default:
+		return nil, errors.Errorf("Unsupported schema version %d in %s", store.Version, filePath)
+	}
+}
+
+func validateImageVMAssociations(associations map[string]*ImageVMAssociation) (map[string]*ImageVMAssociation, error) {
+	for imageUUID, association := range associations {
+		if association == nil || association.VMCount < 0 {
+			return nil, errors.Errorf("Invalid image vm association for image %s", imageUUID)
+		}
+	}
+	return associations, nil
+}
+
+func imageVMAssociationChecksum(associations map[string]*ImageVMAssociation) (string, error) {
+	// map keys are marshalled in sorted order, so equal associations always have the same checksum
+	content, err := yaml.Marshal(associations)
+	if err != nil {
+		return "", errors.Wrap(err, "Error marshalling image vm associations")
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// writeImageVMAssociationFile writes a new generation of the image vm association file, keeping the
+// previous generation as a backup. saveMtx must be held by the caller.
+func writeImageVMAssociationFile(associations map[string]*ImageVMAssociation) error {
+	checksum, err := imageVMAssociationChecksum(associations)
+	if err != nil {
+		return err
+	}
+	content, err := yaml.Marshal(imageVMAssociationStore{
+		Version:      imageVMAssociationSchemaVersion,
+		Checksum:     checksum,
+		Associations: associations,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling image vm association file")
+	}
+
+	fInfo, err := os.Stat(imageVMAssociationFilePath)
+	if fInfo != nil && fInfo.Mode().Perm() != 0600 {
+		return errors.Errorf("Invalid file permission on %s", imageVMAssociationFilePath)
+	}
+
+	dirPath := filepath.Dir(imageVMAssociationFilePath)
+	tmpFile, err := ioutil.TempFile(dirPath, "."+filepath.Base(imageVMAssociationFilePath)+"-")
+	if err != nil {
+		return errors.Wrapf(err, "Error creating temporary file in %s", dirPath)
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(content)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Error while writing file:%s", tmpFile.Name())
+	}
+
+	// keep the current generation as a backup, if the rename below does not make it to disk
+	// the load falls back to it
+	err = os.Rename(imageVMAssociationFilePath, imageVMAssociationFilePath+".bak")
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "Error creating backup of %s", imageVMAssociationFilePath)
+	}
+	err = os.Rename(tmpFile.Name(), imageVMAssociationFilePath)
+	if err != nil {
+		return errors.Wrapf(err, "Error while replacing file:%s", imageVMAssociationFilePath)
+	}
+	return syncDir(dirPath)
+}
+
+// syncDir makes the renames in a directory durable
+func syncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return errors.Wrapf(err, "Error opening directory %s", dirPath)
+	}
+	defer func() {
+		derr := dir.Close()
+		if derr != nil {
+			log.WithError(derr).Error("Error closing directory")
+		}
+	}()
+	if err = dir.Sync(); err != nil {
+		return errors.Wrapf(err, "Error syncing directory %s", dirPath)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testImageUUID = "31ab5921-24fd-498c-8c9e-b20f61004fc0"
+
+func setupTestAssociationFile(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "image-vm-association")
+	assert.NoError(t, err)
+	imageVMAssociationFilePath = filepath.Join(dir, "image_vm_association")
+	ImageVMAssociations = make(map[string]*ImageVMAssociation)
+	return func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestImageVMAssociationSaveAndLoad(t *testing.T) {
+	defer setupTestAssociationFile(t)()
+
+	ImageVMAssociations[testImageUUID] = &ImageVMAssociation{ImagePath: "/var/lib/nova/instances/_base/image", VMCount: 2}
+	assert.NoError(t, SaveImageVMAssociation())
+
+	ImageVMAssociations = make(map[string]*ImageVMAssociation)
+	assert.NoError(t, LoadImageVMAssociation())
+	assert.Equal(t, 2, ImageVMAssociations[testImageUUID].VMCount)
+	assert.Equal(t, "/var/lib/nova/instances/_base/image", ImageVMAssociations[testImageUUID].ImagePath)
+
+	fInfo, err := os.Stat(imageVMAssociationFilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fInfo.Mode().Perm())
+}
+
+func TestImageVMAssociationRecoverFromTruncatedFile(t *testing.T) {
+	defer setupTestAssociationFile(t)()
+
+	ImageVMAssociations[testImageUUID] = &ImageVMAssociation{ImagePath: "/images/image", VMCount: 1}
+	assert.NoError(t, SaveImageVMAssociation())
+	ImageVMAssociations[testImageUUID].VMCount = 2
+	assert.NoError(t, SaveImageVMAssociation())
+
+	content, err := ioutil.ReadFile(imageVMAssociationFilePath)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(imageVMAssociationFilePath, content[:len(content)-8], 0600))
+
+	// the truncated generation is discarded and the previous one is restored
+	assert.NoError(t, LoadImageVMAssociation())
+	assert.Equal(t, 1, ImageVMAssociations[testImageUUID].VMCount)
+
+	_, err = readImageVMAssociationFile(imageVMAssociationFilePath)
+	assert.NoError(t, err)
+}
+
+func TestImageVMAssociationRecoverWithoutBackup(t *testing.T) {
+	defer setupTestAssociationFile(t)()
+
+	assert.NoError(t, ioutil.WriteFile(imageVMAssociationFilePath, []byte("version: 1\nchecksum: abc\nassoc"), 0600))
+	assert.NoError(t, LoadImageVMAssociation())
+	assert.Empty(t, ImageVMAssociations)
+}
+
+func TestImageVMAssociationLoadLegacyFile(t *testing.T) {
+	defer setupTestAssociationFile(t)()
+
+	legacy := testImageUUID + ":\n  imagepath: /images/image\n  vmcount: 3\n"
+	assert.NoError(t, ioutil.WriteFile(imageVMAssociationFilePath, []byte(legacy), 0600))
+	assert.NoError(t, LoadImageVMAssociation())
+	assert.Equal(t, 3, ImageVMAssociations[testImageUUID].VMCount)
+}
@@ -5,87 +5,34 @@
 package util
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	cLog "intel/isecl/lib/common/v4/log"
 	"intel/isecl/lib/common/v4/log/message"
 	"intel/isecl/lib/tpmprovider/v4"
 	"intel/isecl/wlagent/v4/config"
 	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/metrics"
 	"io/ioutil"
-	"os"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
 )
 
 var log = cLog.GetDefaultLogger()
 var secLog = cLog.GetSecurityLogger()
-var ImageVMAssociations = make(map[string]*ImageVMAssociation)
-var MapMtx sync.RWMutex
 
-type ImageVMAssociation struct {
-	ImagePath string `yaml:"imagepath"`
-	VMCount   int    `yaml:"vmcount"`
-}
-
-// LoadImageVMAssociation method loads image vm association from yaml file
-func LoadImageVMAssociation() error {
-	log.Trace("util/util:LoadImageVMAssociation Entering")
-	defer log.Trace("util/util:LoadImageVMAssociation Leaving")
-
-	imageVMAssociationFilePath := consts.RunDirPath + consts.ImageVmCountAssociationFileName
-	// Read from a file and store it in a string
-	log.Info("Reading image vm association file.")
-	MapMtx.RLock()
-	imageVMAssociationFile, err := os.OpenFile(imageVMAssociationFilePath, os.O_RDWR|os.O_CREATE, 0600)
-	MapMtx.RUnlock()
-	if err != nil {
-		return err
-	}
-	defer func() {
-		derr := imageVMAssociationFile.Close()
-		if derr != nil {
-			log.WithError(derr).Error("Error closing file")
-		}
-	}()
-	associations, err := ioutil.ReadAll(imageVMAssociationFile)
-	if err != nil {
-		return err
-	}
-	err = yaml.Unmarshal(associations, &ImageVMAssociations)
-	if err != nil {
-		return err
-	}
-	return nil
-}
+// TpmMtx is held by every user of the TPM in the agent, from GetTpmInstance until the TPM is closed, so that the
+// agent opens one connection to the TPM at a time
+var TpmMtx sync.Mutex
 
-// SaveImageVMAssociation method saves vm image association to yaml file
-func SaveImageVMAssociation() error {
-	log.Trace("util/util:SaveImageVMAssociation() Entering")
-	defer log.Trace("util/util:SaveImageVMAssociation() Leaving")
-
-	imageVMAssociationFilePath := consts.RunDirPath + consts.ImageVmCountAssociationFileName
-	log.Infof("util/util:SaveImageVMAssociation() Writing to image vm association file %s", imageVMAssociationFilePath)
-	associations, err := yaml.Marshal(&ImageVMAssociations)
-	if err != nil {
-		return err
-	}
-	fInfo, err := os.Stat(imageVMAssociationFilePath)
-	if fInfo != nil && fInfo.Mode().Perm() != 0600 {
-		return errors.Errorf("Invalid file permission on %s", imageVMAssociationFilePath)
-	}
-
-	MapMtx.Lock()
-	defer MapMtx.Unlock()
-	err = ioutil.WriteFile(imageVMAssociationFilePath, associations, 0600)
-	if err != nil {
-		return errors.Wrapf(err, "util/util:SaveImageVMAssociation() Error while writing file:%s", imageVMAssociationFilePath)
-	}
-	return nil
-}
-
-// GetTpmInstance method is used to get an instance of TPM to perform various tpm operations
+// GetTpmInstance method is used to get an instance of TPM to perform various tpm operations. The caller holds TpmMtx
 func GetTpmInstance() (tpmprovider.TpmProvider, error) {
 	log.Trace("util/util:GetTpmInstance() Entering")
 	defer log.Trace("util/util:GetTpmInstance() Leaving")
@@ -95,25 +42,31 @@ func GetTpmInstance() (tpmprovider.TpmProvider, error) {
 	}
 
 	vmStartTpm, err := tpmFactory.NewTpmProvider()
-
+	if err != nil {
+		return nil, errors.Wrap(err, "util/util:GetTpmInstance() Could not open a connection to the TPM")
+	}
 	return vmStartTpm, nil
 }
 
-// UnwrapKey method is used to unbind a key using TPM
-func UnwrapKey(tpmWrappedKey []byte) ([]byte, error) {
+// UnwrapKey method is used to unbind a key using TPM. TPM commands can not be interrupted, ctx is only checked
+// before the TPM is opened. The caller holds TpmMtx
+func UnwrapKey(ctx context.Context, tpmWrappedKey []byte) ([]byte, error) {
 	log.Trace("util/util:UnwrapKey() Entering")
 	defer log.Trace("util/util:UnwrapKey() Leaving")
 
 	if len(tpmWrappedKey) == 0 {
 		return nil, errors.New("util/util:UnwrapKey() tpm wrapped key is empty")
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "util/util:UnwrapKey() key was not unwrapped")
+	}
 
 	var certifiedKey tpmprovider.CertifiedKey
 	t, err := GetTpmInstance()
-	defer t.Close()
 	if err != nil {
 		return nil, errors.Wrap(err, "util/util:UnwrapKey() Could not establish connection to TPM ")
 	}
+	defer t.Close()
 	log.Debug("util/util:UnwrapKey() Reading the binding key certificate")
 	bindingKeyFilePath := consts.ConfigDirPath + consts.BindingKeyFileName
 	bindingKeyCert, fileErr := ioutil.ReadFile(bindingKeyFilePath)
@@ -129,10 +82,49 @@ func UnwrapKey(tpmWrappedKey []byte) ([]byte, error) {
 
 	log.Debug("util/util:UnwrapKey() Binding key deserialized")
 	secLog.Infof("util/util:UnwrapKey() %s, Binding key getting decrypted", message.SU)
+	start := time.Now()
 	key, unbindErr := t.Unbind(&certifiedKey, config.Configuration.BindingKeySecret, tpmWrappedKey)
+	metrics.ObserveTPMOperation("unbind", start, unbindErr)
 	if unbindErr != nil {
 		return nil, errors.Wrap(unbindErr, "util/util:UnwrapKey() error while unbinding the tpm wrapped key ")
 	}
 	log.Debug("util/util:UnwrapKey() Unbinding TPM wrapped key was successful, return the key")
 	return key, nil
 }
+
+// tpmBindingLabel is the OAEP label the TPM expects on the keys it unbinds with the binding key
+var tpmBindingLabel = []byte("TPM2\x00")
+
+// WrapKey wraps a key with the public key of the TPM binding key, so that it can only be unwrapped by UnwrapKey on
+// this host. Unlike UnwrapKey it does not use the TPM
+func WrapKey(key []byte) ([]byte, error) {
+	log.Trace("util/util:WrapKey() Entering")
+	defer log.Trace("util/util:WrapKey() Leaving")
+
+	bindingKeyPem, err := ioutil.ReadFile(consts.ConfigDirPath + consts.BindingKeyPemFileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "util/util:WrapKey() Error while reading the binding key certificate")
+	}
+	return wrapKeyWithCertificate(bindingKeyPem, key)
+}
+
+// wrapKeyWithCertificate encrypts key with the RSA public key of the PEM encoded binding key certificate
+func wrapKeyWithCertificate(bindingKeyPem, key []byte) ([]byte, error) {
+	block, _ := pem.Decode(bindingKeyPem)
+	if block == nil || block.Type != consts.PemCertificateHeader {
+		return nil, errors.New("util/util:wrapKeyWithCertificate() The binding key certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "util/util:wrapKeyWithCertificate() Error parsing the binding key certificate")
+	}
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("util/util:wrapKeyWithCertificate() The binding key is not an RSA key")
+	}
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, key, tpmBindingLabel)
+	if err != nil {
+		return nil, errors.Wrap(err, "util/util:wrapKeyWithCertificate() Error wrapping the key with the binding key")
+	}
+	return wrappedKey, nil
+}
@@ -0,0 +1,44 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package util
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapKeyWithCertificate(t *testing.T) {
+	bindingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Binding Key"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, &template, &template, &bindingKey.PublicKey, bindingKey)
+	assert.NoError(t, err)
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})
+
+	// the TPM unbinds the wrapped key with the private part of the binding key and the TPM label
+	key := []byte("0123456789abcdef0123456789abcdef")
+	wrappedKey, err := wrapKeyWithCertificate(certPem, key)
+	assert.NoError(t, err)
+	unwrappedKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, bindingKey, wrappedKey, []byte("TPM2\x00"))
+	assert.NoError(t, err)
+	assert.Equal(t, key, unwrappedKey)
+
+	_, err = wrapKeyWithCertificate([]byte("not a certificate"), key)
+	assert.Error(t, err)
+}
@@ -0,0 +1,469 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"context"
+	"fmt"
+	"intel/isecl/lib/common/v4/crypt"
+	"intel/isecl/lib/common/v4/log/message"
+	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/libvirt"
+	"io"
+	"math"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	blockDeviceDirPath = "/dev/"
+	// luksLabelField is the field of cryptsetup luksDump holding the label of a LUKS2 volume
+	luksLabelField = "Label"
+	// qcow2Format is the format of the VM disk overlays the agent formats block devices under
+	qcow2Format = "qcow2"
+)
+
+// isAgentDeviceMapper returns true if path is a dm-crypt volume of the agent, named after a VM or an image UUID
+func isAgentDeviceMapper(path string) bool {
+	return filepath.Dir(path)+"/" == consts.DevMapperDirPath && uuidRegex.MatchString(filepath.Base(path))
+}
+
+// isBlockVolumeLink returns true if the VM disk is a link to the volume of the VM opened over its block device
+func isBlockVolumeLink(vmUUID, vmPath string) bool {
+	target, err := os.Readlink(vmPath)
+	return err == nil && target == consts.DevMapperDirPath+vmUUID
+}
+
+// isAgentBlockVolume returns true if the device was formatted by the agent for the VM
+func isAgentBlockVolume(ctx context.Context, device, vmUUID string) (bool, error) {
+	label, isLuks, err := luksDeviceLabel(ctx, device)
+	return isLuks && label == vmUUID, err
+}
+
+// luksDeviceLabel returns the label of the LUKS volume on the device, and false if the device holds no LUKS volume.
+// cryptsetup isLuks exits with status 1 for a device without a LUKS header, any other failure is an error.
+func luksDeviceLabel(ctx context.Context, device string) (string, bool, error) {
+	_, err := executeCommand(ctx, "cryptsetup", []string{"isLuks", device})
+	if err != nil {
+		if exitErr, ok := errors.Cause(err).(*osexec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return "", false, nil
+		}
+		return "", false, errors.Wrapf(err, "wlavm/block_disk:luksDeviceLabel() error checking the LUKS header of %s", device)
+	}
+	output, err := executeCommand(ctx, "cryptsetup", []string{"luksDump", device})
+	if err != nil {
+		return "", true, errors.Wrapf(err, "wlavm/block_disk:luksDeviceLabel() error reading the LUKS header of %s", device)
+	}
+	return luksLabel(output), true, nil
+}
+
+// checkUnmanagedBlockDisk returns an error if the block device of a VM disk that is not backed by an encrypted image
+// holds the volume of a VM formatted by the agent, on whose ciphertext the VM would otherwise boot
+func checkUnmanagedBlockDisk(ctx context.Context, vmUUID, vmPath string) error {
+	label, isLuks, err := luksDeviceLabel(ctx, vmPath)
+	if err != nil {
+		log.WithError(err).Errorf("wlavm/block_disk:checkUnmanagedBlockDisk() Error checking the block device of VM %s", vmUUID)
+		return newVMError(PhasePrepare, VolumeFailed, err, "error checking whether the block device of the VM disk "+
+			"holds a dm-crypt volume")
+	}
+	if isLuks && uuidRegex.MatchString(label) {
+		log.Errorf("wlavm/block_disk:checkUnmanagedBlockDisk() The disk %s of VM %s holds the volume of VM %s", vmPath, vmUUID, label)
+		return newVMError(PhasePrepare, VolumeFailed, nil, "the block device of the VM disk "+vmPath+" holds the "+
+			"dm-crypt volume of VM "+label+" and the encrypted image it was launched from is not found")
+	}
+	return nil
+}
+
+// checkBlockOverlay returns an error unless the VM disk on the block device is a qcow2 overlay whose backing file is
+// the encrypted image, the only contents of a device that the agent formats
+func checkBlockOverlay(vmPath, imagePath string, vmDiskInfo qemuImageInfo) error {
+	if vmDiskInfo.Format != qcow2Format || vmDiskInfo.BackingFile == "" {
+		return newVMError(PhasePrepare, VolumeFailed, nil, "the VM disk "+vmPath+" is not a qcow2 overlay backed by "+
+			"the encrypted image, its block device is not formatted")
+	}
+	if filepath.IsAbs(vmDiskInfo.BackingFile) && vmDiskInfo.BackingFile != imagePath {
+		return newVMError(PhasePrepare, VolumeFailed, nil, "the backing file "+vmDiskInfo.BackingFile+" of the VM "+
+			"disk "+vmPath+" is not the image "+imagePath+" named in the domain XML")
+	}
+	encrypted, err := crypt.EncryptionHeaderExists(imagePath)
+	if err != nil {
+		return newVMError(PhasePrepare, VolumeFailed, err, "error reading the encryption header of the backing file "+
+			"of the VM disk")
+	}
+	if !encrypted {
+		return newVMError(PhasePrepare, VolumeFailed, nil, "the backing file "+imagePath+" of the VM disk "+vmPath+
+			" is not an encrypted image, its block device is not formatted")
+	}
+	return nil
+}
+
+// luksLabel returns the label from the output of cryptsetup luksDump, or "" if the volume has none
+func luksLabel(dumpOutput string) string {
+	for _, line := range strings.Split(dumpOutput, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(fields) == 2 && fields[0] == luksLabelField {
+			label := strings.TrimSpace(fields[1])
+			if label == "(no label)" {
+				return ""
+			}
+			return label
+		}
+	}
+	return ""
+}
+
+// isBlockDiskPath returns true if the disk of the domain at path is a block device
+func isBlockDiskPath(disks []libvirt.Disk, path string) bool {
+	for _, disk := range disks {
+		if disk.Path() == path {
+			return disk.Type == libvirt.DiskTypeBlock
+		}
+	}
+	return false
+}
+
+// cryptsetupDevice returns the device under a volume from the cryptsetup status output
+func cryptsetupDevice(statusOutput string) string {
+	for _, line := range strings.Split(statusOutput, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(fields) == 2 && fields[0] == "device" {
+			return strings.TrimSpace(fields[1])
+		}
+	}
+	return ""
+}
+
+// prepareBlockDisk opens the volume of the VM over the block device of the VM disk, formatting it on the first
+// launch of the VM, and points the link naming the device in the domain XML at the volume
+func prepareBlockDisk(ctx context.Context, rb *rollback, vmUUID, imageUUID, vmPath, backingFile string, size int) error {
+	log.Trace("wlavm/block_disk:prepareBlockDisk() Entering")
+	defer log.Trace("wlavm/block_disk:prepareBlockDisk() Leaving")
+
+	// a VM restarted without being stopped still has its volume open
+	if isBlockVolumeLink(vmUUID, vmPath) {
+		log.Infof("wlavm/block_disk:prepareBlockDisk() The volume of VM %s is already open", vmUUID)
+		return nil
+	}
+	// the link is replaced by a link to the volume, a device node can not be
+	if _, err := os.Readlink(vmPath); err != nil {
+		log.WithError(err).Errorf("wlavm/block_disk:prepareBlockDisk() The disk of VM %s is not a symbolic link", vmUUID)
+		return newVMError(PhasePrepare, VolumeFailed, err, "the domain XML must name the block device of the VM disk "+
+			"by a symbolic link such as /dev/<vg>/<lv> or /dev/rbd/<pool>/<image>, not by "+vmPath)
+	}
+	device, err := filepath.EvalSymlinks(vmPath)
+	if err != nil {
+		log.WithError(err).Errorf("wlavm/block_disk:prepareBlockDisk() Error resolving the device of VM disk %s", vmPath)
+		return newVMError(PhasePrepare, VolumeFailed, err, "error resolving the block device of the VM disk")
+	}
+
+	// a device holding a LUKS volume is never formatted again, it is either the volume of the VM or not the agent's
+	label, formatted, err := luksDeviceLabel(ctx, device)
+	if err != nil {
+		log.WithError(err).Errorf("wlavm/block_disk:prepareBlockDisk() Error checking block device %s", device)
+		return newVMError(PhasePrepare, VolumeFailed, err, "error checking whether the block device of the VM disk "+
+			"holds a dm-crypt volume")
+	}
+	if formatted && label != vmUUID {
+		log.Errorf("wlavm/block_disk:prepareBlockDisk() Block device %s of VM %s holds a LUKS volume labelled %q", device, vmUUID, label)
+		return newVMError(PhasePrepare, VolumeFailed, nil, "the block device "+device+" of the VM disk holds a LUKS "+
+			"volume that the agent did not format for the VM")
+	}
+	var vmDiskInfo qemuImageInfo
+	imagePath := backingFile
+	if formatted {
+		imagePath = blockDiskImagePath(vmUUID, imageUUID)
+	} else {
+		vmDiskInfo, err = qemuImgInfo(ctx, vmPath)
+		if err != nil {
+			log.WithError(err).Errorf("wlavm/block_disk:prepareBlockDisk() Error reading VM disk %s", vmPath)
+			return newVMError(PhasePrepare, QemuImgFailed, err, "error discovering properties of the VM disk")
+		}
+		if imagePath == "" {
+			imagePath = vmDiskInfo.BackingFile
+		}
+	}
+	if imagePath == "" {
+		if encrypted, _ := crypt.EncryptionHeaderExists(vmPath); encrypted {
+			return newVMError(PhasePrepare, VolumeFailed, nil, "the VM disk "+vmPath+" is a copy of the encrypted "+
+				"image, which can not be decrypted in place on a block device, back the VM disk with the encrypted image")
+		}
+		return newVMError(PhasePrepare, AssociationFailed, nil, "image path for image "+imageUUID+" of VM disk "+
+			vmPath+" not found")
+	}
+	if !formatted {
+		if err = checkBlockOverlay(vmPath, imagePath, vmDiskInfo); err != nil {
+			log.WithError(err).Errorf("wlavm/block_disk:prepareBlockDisk() Not formatting block device %s", device)
+			return err
+		}
+	}
+	if imageUUID == "" {
+		imageUUID = resolveImageUUID(vmUUID, imagePath)
+		markPending(vmUUID, imageUUID)
+	}
+	if imageUUID == "" {
+		log.Errorf("wlavm/block_disk:prepareBlockDisk() No image UUID for the encrypted image %s of VM %s", imagePath, vmUUID)
+		return newVMError(PhasePrepare, ImageUnidentified, nil, "the domain XML does not name the encrypted image "+
+			imagePath+" and it is not in the image registry")
+	}
+	if size == 0 {
+		size, err = blockDeviceSize(device)
+		if err != nil {
+			log.WithError(err).Errorf("wlavm/block_disk:prepareBlockDisk() Error reading the size of device %s", device)
+			return newVMError(PhasePrepare, VolumeFailed, err, "error reading the size of the block device of the VM disk")
+		}
+	}
+
+	image, err := prepareImageOnce(ctx, imageUUID, func() (preparedImage, error) {
+		unlockImage := imageLocks.Lock(imageUUID)
+		defer unlockImage()
+		return prepareImage(ctx, imageUUID, imagePath, size)
+	})
+	if err != nil {
+		return err
+	}
+	if image.key == nil {
+		return newVMError(PhasePrepare, KeyDenied, nil, "the flavor of image "+imageUUID+" does not require "+
+			"encryption, the key of the VM volume is not released")
+	}
+
+	volumeKey, err := vmVolumeKey(ctx, rb, vmUUID, image.key, !formatted)
+	if err != nil {
+		return err
+	}
+	mapperPath := consts.DevMapperDirPath + vmUUID
+	if !formatted {
+		err = formatBlockVolume(ctx, rb, device, vmUUID, vmPath, imagePath, vmDiskInfo, volumeKey)
+		if err != nil {
+			return err
+		}
+	}
+	err = openBlockVolume(ctx, rb, device, vmUUID, volumeKey)
+	if err != nil {
+		return err
+	}
+	if !formatted {
+		err = createBlockVMDisk(ctx, imageUUID, mapperPath, vmDiskInfo, image.backingFormat)
+		if err != nil {
+			return err
+		}
+	}
+	err = linkBlockVolume(ctx, rb, vmPath, mapperPath, device)
+	if err != nil {
+		log.WithError(err).Errorf("wlavm/block_disk:prepareBlockDisk() Error pointing VM disk %s at its volume", vmPath)
+		return newVMError(PhasePrepare, VolumeFailed, err, "error pointing the VM disk at the VM dm-crypt volume")
+	}
+
+	isImageEncrypted, _ := crypt.EncryptionHeaderExists(imagePath)
+	updateVMState(vmUUID, true, func(state *VMState) {
+		state.ImageUUID = imageUUID
+		state.VMPath = vmPath
+		state.Encrypted = true
+		if isImageEncrypted {
+			state.ImagePath = imagePath
+			state.ImageVolume = imageVolumeState(imageUUID, imagePath)
+		}
+		state.KeyID = image.keyID
+		state.VMVolume = blockVolumeState(vmUUID, device)
+		state.PerVMKey = hasVMKey(vmUUID)
+		state.Phase = PhasePrepare
+		state.Error = ""
+		state.PreparedAt = time.Now()
+	})
+	log.Infof("wlavm/block_disk:prepareBlockDisk() VM %s prepared on block device %s", vmUUID, device)
+	return nil
+}
+
+// blockDiskImagePath returns the encrypted image of a VM disk formatted by the agent, whose backing file can not be
+// read until its volume is open, from the state of the VM or the image registry, or the decrypted image from the image
+// vm association while its volume is open
+func blockDiskImagePath(vmUUID, imageUUID string) string {
+	if imagePath := imagePathFromVMState(vmUUID); imagePath != "" {
+		return imagePath
+	}
+	if imageUUID == "" {
+		return ""
+	}
+	imagePath, err := ImageRegistry{Path: consts.ImageRegistryFilePath}.ImagePath(imageUUID)
+	if err != nil {
+		log.WithError(err).Warnf("wlavm/block_disk:blockDiskImagePath() Error looking up image %s in the image registry", imageUUID)
+	}
+	if imagePath != "" {
+		return imagePath
+	}
+	imagePath = imagePathFromVMAssociationFile(imageUUID)
+	if info, err := os.Stat(imagePath); err == nil && info.Size() > 0 {
+		return imagePath
+	}
+	return ""
+}
+
+// formatBlockVolume formats the device as a LUKS2 volume labelled with the VM UUID. Once formatted, the VM disk on the
+// device is recreated over the encrypted image on rollback, as it was before the VM was prepared.
+func formatBlockVolume(ctx context.Context, rb *rollback, device, vmUUID, vmPath, imagePath string, vmDiskInfo qemuImageInfo, key []byte) error {
+	secLog.Infof("wlavm/block_disk:formatBlockVolume() %s, Formatting block device %s for VM %s", message.SU, device, vmUUID)
+	_, err := executeCommandWithInput(ctx, "cryptsetup", []string{"luksFormat", "--batch-mode", "--type", "luks2",
+		"--label", vmUUID, "--key-file", "-", device}, key)
+	if err != nil {
+		log.WithError(err).Errorf("wlavm/block_disk:formatBlockVolume() Error formatting block device %s", device)
+		return newVMError(PhasePrepare, VolumeFailed, err, "error formatting the block device of the VM disk")
+	}
+
+	// qemu-img probes an encrypted image as raw when the overlay does not record the format of its backing file
+	backingFormat := vmDiskInfo.BackingFormat
+	if backingFormat == "" {
+		backingFormat = "raw"
+	}
+	rb.add("recreate VM disk "+vmPath, func() error {
+		_, err := executeCommand(context.Background(), consts.QemuImgUtilPath, strings.Fields(
+			fmt.Sprintf(consts.CreateVmDiskCmd, vmDiskInfo.Format, imagePath, backingFormat, vmPath)+" "+
+				strconv.FormatInt(vmDiskInfo.VirtualSize, 10)))
+		return err
+	})
+	return nil
+}
+
+// openBlockVolume opens the volume of the VM over the device, the volume is closed again on rollback
+func openBlockVolume(ctx context.Context, rb *rollback, device, vmUUID string, key []byte) error {
+	secLog.Infof("wlavm/block_disk:openBlockVolume() %s, Opening the volume of VM %s over %s", message.SU, vmUUID, device)
+	_, err := executeCommandWithInput(ctx, "cryptsetup", []string{"luksOpen", "--key-file", "-", device, vmUUID}, key)
+	if err != nil {
+		log.WithError(err).Errorf("wlavm/block_disk:openBlockVolume() Error opening the volume of VM %s", vmUUID)
+		return newVMError(PhasePrepare, VolumeFailed, err, "error opening the VM dm-crypt volume over the block device")
+	}
+	rb.add("close volume "+consts.DevMapperDirPath+vmUUID, func() error {
+		_, err := executeCommand(context.Background(), "cryptsetup", []string{"luksClose", vmUUID})
+		return err
+	})
+	return nil
+}
+
+// createBlockVMDisk recreates the VM disk on the volume of the VM over the decrypted image, with the format and the
+// virtual size of the VM disk the VM was defined with
+func createBlockVMDisk(ctx context.Context, imageUUID, mapperPath string, vmDiskInfo qemuImageInfo, backingFormat string) error {
+	decryptedImagePath := consts.MountPath + imageUUID + "/" + imageUUID
+	if backingFormat == "" {
+		decryptedImageInfo, err := qemuImgInfo(ctx, decryptedImagePath)
+		if err != nil {
+			log.WithError(err).Error("wlavm/block_disk:createBlockVMDisk() Error discovering the format of the decrypted image")
+			return newVMError(PhasePrepare, QemuImgFailed, err, "error discovering format of the decrypted image")
+		}
+		backingFormat = decryptedImageInfo.Format
+	}
+	_, err := executeCommand(ctx, consts.QemuImgUtilPath, strings.Fields(
+		fmt.Sprintf(consts.CreateVmDiskCmd, vmDiskInfo.Format, decryptedImagePath, backingFormat, mapperPath)))
+	if err != nil {
+		log.WithError(err).Error("wlavm/block_disk:createBlockVMDisk() Error recreating the VM disk on its volume")
+		return newVMError(PhasePrepare, QemuImgFailed, err, "error recreating the VM disk on the VM dm-crypt volume")
+	}
+	_, err = executeCommand(ctx, consts.QemuImgUtilPath, strings.Fields(
+		fmt.Sprintf(consts.ResizeVmDiskCmd, mapperPath, strconv.FormatInt(vmDiskInfo.VirtualSize, 10))))
+	if err != nil {
+		log.WithError(err).Error("wlavm/block_disk:createBlockVMDisk() Error resizing the VM disk on its volume")
+		return newVMError(PhasePrepare, QemuImgFailed, err, "error resizing the VM disk")
+	}
+	return nil
+}
+
+// linkBlockVolume points the VM disk at the volume of the VM, once udev has handled the events of the device, so
+// that it does not restore the link to the device. The link to the device is restored on rollback.
+func linkBlockVolume(ctx context.Context, rb *rollback, vmPath, mapperPath, device string) error {
+	_, err := executeCommand(ctx, "udevadm", []string{"settle"})
+	if err != nil {
+		log.WithError(err).Warn("wlavm/block_disk:linkBlockVolume() Error waiting for the udev events")
+	}
+	secLog.Infof("wlavm/block_disk:linkBlockVolume() %s, Pointing %s at %s", message.SU, vmPath, mapperPath)
+	err = replaceSymlink(vmPath, mapperPath)
+	if err != nil {
+		return err
+	}
+	rb.add("point "+vmPath+" at "+device, func() error {
+		return replaceSymlink(vmPath, device)
+	})
+
+	// the VM disk is opened by qemu
+	userID, groupID, err := userInfoLookUp("qemu")
+	if err != nil {
+		return err
+	}
+	mapperDevice, err := filepath.EvalSymlinks(mapperPath)
+	if err != nil {
+		return errors.Wrapf(err, "wlavm/block_disk:linkBlockVolume() error resolving %s", mapperPath)
+	}
+	err = os.Chown(mapperDevice, userID, groupID)
+	if err != nil {
+		return errors.Wrapf(err, "wlavm/block_disk:linkBlockVolume() error changing the owner of %s to qemu", mapperDevice)
+	}
+	return nil
+}
+
+// closeBlockVolume points the VM disk back at its block device and closes the volume of the VM. The device is read
+// from the volume when the state of the VM does not record it.
+func closeBlockVolume(ctx context.Context, vmUUID, vmPath, device string) error {
+	log.Trace("wlavm/block_disk:closeBlockVolume() Entering")
+	defer log.Trace("wlavm/block_disk:closeBlockVolume() Leaving")
+
+	if device == "" {
+		output, err := executeCommand(ctx, "cryptsetup", []string{"status", consts.DevMapperDirPath + vmUUID})
+		if err != nil {
+			return errors.Wrapf(err, "wlavm/block_disk:closeBlockVolume() error reading the device of the volume of VM %s", vmUUID)
+		}
+		device = cryptsetupDevice(output)
+	}
+	if device != "" && isBlockVolumeLink(vmUUID, vmPath) {
+		secLog.Infof("wlavm/block_disk:closeBlockVolume() %s, Pointing %s back at %s", message.SU, vmPath, device)
+		if err := replaceSymlink(vmPath, device); err != nil {
+			return err
+		}
+	}
+	secLog.Infof("wlavm/block_disk:closeBlockVolume() %s, Closing the volume of VM %s", message.SU, vmUUID)
+	_, err := executeCommand(ctx, "cryptsetup", []string{"luksClose", vmUUID})
+	if err != nil {
+		return errors.Wrapf(err, "wlavm/block_disk:closeBlockVolume() error closing the volume of VM %s", vmUUID)
+	}
+	return nil
+}
+
+// replaceSymlink atomically replaces the symbolic link at path with a link to target
+func replaceSymlink(path, target string) error {
+	tmpPath := path + ".wlagent"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "wlavm/block_disk:replaceSymlink() error removing %s", tmpPath)
+	}
+	if err := os.Symlink(target, tmpPath); err != nil {
+		return errors.Wrapf(err, "wlavm/block_disk:replaceSymlink() error creating a link to %s", target)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return errors.Wrapf(err, "wlavm/block_disk:replaceSymlink() error replacing %s", path)
+	}
+	return nil
+}
+
+// blockDeviceSize returns the size of the device in GB
+func blockDeviceSize(device string) (int, error) {
+	file, err := os.Open(device)
+	if err != nil {
+		return 0, errors.Wrapf(err, "wlavm/block_disk:blockDeviceSize() error opening %s", device)
+	}
+	defer func() {
+		derr := file.Close()
+		if derr != nil {
+			log.WithError(derr).Error("Error closing file")
+		}
+	}()
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, errors.Wrapf(err, "wlavm/block_disk:blockDeviceSize() error reading the size of %s", device)
+	}
+	return int(math.Ceil(float64(size) / (1 << 30))), nil
+}
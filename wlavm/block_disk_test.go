@@ -0,0 +1,111 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/libvirt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCryptsetupOutputs(t *testing.T) {
+	luksDump := "LUKS header information\n" +
+		"Version:       \t2\n" +
+		"Epoch:         \t3\n" +
+		"Metadata area: \t16384 [bytes]\n" +
+		"UUID:          \t0b4e3b1a-3c2f-4a4e-9d6e-8a4c2b1f0e9d\n" +
+		"Label:         \t" + testVMUUID + "\n" +
+		"Subsystem:     \t(no subsystem)\n"
+	assert.Equal(t, testVMUUID, luksLabel(luksDump))
+	assert.Empty(t, luksLabel("Version:       \t2\nLabel:         \t(no label)\n"))
+	assert.Empty(t, luksLabel("Version:       \t1\n"))
+
+	status := "/dev/mapper/" + testVMUUID + " is active and is in use.\n" +
+		"  type:    LUKS2\n" +
+		"  cipher:  aes-xts-plain64\n" +
+		"  keysize: 512 bits\n" +
+		"  device:  /dev/dm-3\n" +
+		"  sector size:  512\n" +
+		"  offset:  32768 sectors\n"
+	assert.Equal(t, "/dev/dm-3", cryptsetupDevice(status))
+	assert.Empty(t, cryptsetupBackingFile(status))
+}
+
+func TestBlockVolumeLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "block-disk")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the domain XML names the logical volume of the VM by its link to the device
+	vmPath := filepath.Join(dir, "vm-disk")
+	assert.NoError(t, os.Symlink("../dm-3", vmPath))
+	assert.False(t, isBlockVolumeLink(testVMUUID, vmPath))
+
+	assert.NoError(t, replaceSymlink(vmPath, consts.DevMapperDirPath+testVMUUID))
+	assert.True(t, isBlockVolumeLink(testVMUUID, vmPath))
+	assert.False(t, isBlockVolumeLink(testOtherVMUUID, vmPath))
+	disk, ok := volumeBackedDisk([]libvirt.Disk{
+		{Type: libvirt.DiskTypeBlock, Device: libvirt.DiskDeviceDisk, Source: libvirt.Source{Dev: vmPath}},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, vmPath, disk.Path())
+
+	assert.NoError(t, replaceSymlink(vmPath, "/dev/dm-3"))
+	assert.False(t, isBlockVolumeLink(testVMUUID, vmPath))
+	_, err = os.Lstat(vmPath + ".wlagent")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.True(t, isAgentDeviceMapper(consts.DevMapperDirPath+testImageUUID))
+	assert.False(t, isAgentDeviceMapper(consts.DevMapperDirPath+"rhel-root"))
+	assert.False(t, isAgentDeviceMapper("/dev/"+testVMUUID))
+}
+
+func TestImageSparseFilePath(t *testing.T) {
+	assert.Equal(t, "/var/lib/nova/instances/_base/dbee5739_sparseFile",
+		imageSparseFilePath(testImageUUID, "/var/lib/nova/instances/_base/dbee5739"))
+	// no sparse file is created next to an image on a block device
+	assert.Equal(t, consts.ImageVolumeDirPath+testImageUUID+"_sparseFile",
+		imageSparseFilePath(testImageUUID, "/dev/rbd/images/rhel8"))
+}
+
+func TestCheckBlockOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "block-disk")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	imagePath := filepath.Join(dir, "encrypted")
+	assert.NoError(t, ioutil.WriteFile(imagePath, encryptForTest(t, make([]byte, 32), make([]byte, 64), 0), 0600))
+	plainPath := filepath.Join(dir, "plain")
+	assert.NoError(t, ioutil.WriteFile(plainPath, make([]byte, 64), 0600))
+	vmPath := "/dev/vg/" + testVMUUID
+
+	overlay := qemuImageInfo{Format: "qcow2", BackingFile: imagePath, VirtualSize: 1 << 30}
+	assert.NoError(t, checkBlockOverlay(vmPath, imagePath, overlay))
+	// the backing file may be relative to the overlay, it is then the one named in the domain XML
+	assert.NoError(t, checkBlockOverlay(vmPath, imagePath, qemuImageInfo{Format: "qcow2", BackingFile: "encrypted"}))
+
+	// anything else on the device is never formatted: a raw disk, an overlay without a backing file, an overlay on
+	// another image than the one in the domain XML and an overlay on an image that is not encrypted
+	for name, test := range map[string]struct {
+		imagePath string
+		info      qemuImageInfo
+	}{
+		"raw":           {imagePath, qemuImageInfo{Format: "raw"}},
+		"no backing":    {imagePath, qemuImageInfo{Format: "qcow2"}},
+		"other image":   {plainPath, overlay},
+		"not encrypted": {plainPath, qemuImageInfo{Format: "qcow2", BackingFile: plainPath}},
+	} {
+		vmErr, ok := checkBlockOverlay(vmPath, test.imagePath, test.info).(*VMError)
+		assert.True(t, ok, name)
+		assert.Equal(t, VolumeFailed, vmErr.Code, name)
+	}
+}
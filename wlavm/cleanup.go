@@ -0,0 +1,282 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"intel/isecl/lib/common/v4/log/message"
+	"intel/isecl/lib/vml/v4"
+	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/libvirt"
+	"intel/isecl/wlagent/v4/util"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CleanupReport describes the changes made by Cleanup
+type CleanupReport struct {
+	DryRun          bool
+	UnmountedPaths  []string
+	ClosedVolumes   []string
+	RemovedFiles    []string
+	CorrectedCounts []string
+	CleanupErrors   []string
+}
+
+// Cleanup removes the volume of a VM deleted while the agent was down, or of an image: it unmounts and closes the
+// dm-crypt volume, deletes its mount point, its sparse file and the key of a VM volume, and corrects the image vm
+// associations. It refuses a volume used by a domain defined on the host, running or shut off, by a VM whose disk is
+// still in place or by a VM being prepared. With dryRun the report lists what would be changed without changing
+// anything. A step that fails is reported in CleanupErrors and the following steps are skipped.
+func Cleanup(lister DomainLister, kind VolumeKind, uuid string, dryRun bool) (CleanupReport, error) {
+	log.Trace("wlavm/cleanup:Cleanup() Entering")
+	defer log.Trace("wlavm/cleanup:Cleanup() Leaving")
+
+	report := CleanupReport{DryRun: dryRun}
+	if kind != VolumeKindVM && kind != VolumeKindImage {
+		return report, errors.Errorf("wlavm/cleanup:Cleanup() Invalid volume kind %s", kind)
+	}
+	if !uuidRegex.MatchString(uuid) {
+		return report, errors.Errorf("wlavm/cleanup:Cleanup() Invalid %s UUID %s", kind, uuid)
+	}
+	uuid = strings.ToLower(uuid)
+
+	lifecycleMtx.Lock()
+	defer lifecycleMtx.Unlock()
+
+	// the sparse file of a shut off VM holds its disk as much as the one of a running VM
+	definedDomainXMLs, err := lister.ListDefinedDomains()
+	if err != nil {
+		return report, errors.Wrap(err, "wlavm/cleanup:Cleanup() error listing the defined domains")
+	}
+	users, _, err := domainsUsing(definedDomainXMLs, uuid)
+	if err != nil {
+		return report, err
+	}
+	if len(users) > 0 {
+		return report, errors.Errorf("wlavm/cleanup:Cleanup() The volume of %s %s is used by the VMs %s",
+			kind, uuid, strings.Join(users, ", "))
+	}
+	runningDomainXMLs, err := lister.ListRunningDomains()
+	if err != nil {
+		return report, errors.Wrap(err, "wlavm/cleanup:Cleanup() error listing the running domains")
+	}
+	_, imageVMCount, err := domainsUsing(runningDomainXMLs, uuid)
+	if err != nil {
+		return report, err
+	}
+	pendingMtx.Lock()
+	_, pending := pendingVMs[uuid]
+	pendingMtx.Unlock()
+	if pending || isImagePending(uuid) {
+		return report, errors.Errorf("wlavm/cleanup:Cleanup() The volume of %s %s is used by a VM being prepared", kind, uuid)
+	}
+
+	var vmState *VMState
+	var imageUUID string
+	if kind == VolumeKindVM {
+		vmState, err = LoadVMState(uuid)
+		if err != nil {
+			log.WithError(err).Warnf("wlavm/cleanup:Cleanup() Cleaning up VM %s without its state record", uuid)
+		}
+		if vmState != nil {
+			imageUUID = vmState.ImageUUID
+			// the disk of a VM is removed along with the VM, a VM undefined only for a while keeps it
+			if vmState.VMPath != "" {
+				if _, err = os.Lstat(vmState.VMPath); err == nil {
+					return report, errors.Errorf("wlavm/cleanup:Cleanup() The disk %s of VM %s still exists", vmState.VMPath, uuid)
+				}
+			}
+		}
+	}
+	mountPoints, err := listAgentMounts()
+	if err != nil {
+		return report, err
+	}
+	backingFiles, err := agentVolumeBackingFiles()
+	if err != nil {
+		return report, err
+	}
+	sparseFile, err := cleanupSparseFile(kind, uuid, backingFiles[uuid], vmState)
+	if err != nil {
+		return report, err
+	}
+	// a volume of the other kind with the same UUID is never touched
+	if sparseFile != "" && newVolume(uuid, sparseFile, "").Kind != kind {
+		return report, errors.Errorf("wlavm/cleanup:Cleanup() The volume %s is not the volume of %s %s", sparseFile, kind, uuid)
+	}
+
+	mountPath := consts.MountPath + uuid
+	for _, mountPoint := range mountPoints {
+		if mountPoint != mountPath {
+			continue
+		}
+		if !dryRun {
+			secLog.Infof("wlavm/cleanup:Cleanup() %s, Unmounting %s", message.SU, mountPath)
+			if err = vml.Unmount(mountPath); err != nil {
+				log.WithError(err).Errorf("wlavm/cleanup:Cleanup() Failed to unmount %s", mountPath)
+				report.CleanupErrors = append(report.CleanupErrors, "unmount "+mountPath+": "+err.Error())
+				return report, nil
+			}
+		}
+		report.UnmountedPaths = append(report.UnmountedPaths, mountPath)
+	}
+
+	if _, ok := backingFiles[uuid]; ok {
+		if !dryRun {
+			secLog.Infof("wlavm/cleanup:Cleanup() %s, Closing dm-crypt volume %s", message.SU, uuid)
+			if err = vml.DeleteVolume(consts.DevMapperDirPath + uuid); err != nil {
+				log.WithError(err).Errorf("wlavm/cleanup:Cleanup() Failed to close dm-crypt volume %s", uuid)
+				report.CleanupErrors = append(report.CleanupErrors, "close volume "+uuid+": "+err.Error())
+				return report, nil
+			}
+		}
+		report.ClosedVolumes = append(report.ClosedVolumes, uuid)
+	}
+
+	removedPaths := []string{mountPath, sparseFile}
+	if kind == VolumeKindVM {
+		// the key of the volume is of no use once its sparse file is removed
+		removedPaths = append(removedPaths, vmKeyFilePath(uuid))
+	}
+	for _, path := range removedPaths {
+		if path == "" {
+			continue
+		}
+		if _, err = os.Lstat(path); os.IsNotExist(err) {
+			continue
+		}
+		if !dryRun {
+			secLog.Infof("wlavm/cleanup:Cleanup() %s, Removing %s", message.SU, path)
+			if err = os.RemoveAll(path); err != nil {
+				log.WithError(err).Errorf("wlavm/cleanup:Cleanup() Failed to remove %s", path)
+				report.CleanupErrors = append(report.CleanupErrors, "remove "+path+": "+err.Error())
+				return report, nil
+			}
+		}
+		report.RemovedFiles = append(report.RemovedFiles, path)
+	}
+
+	if kind == VolumeKindVM {
+		if !dryRun {
+			removeVMState(uuid)
+		}
+		if imageUUID == "" {
+			return report, nil
+		}
+	} else {
+		imageUUID = uuid
+	}
+	// the VM is not running anymore, the running VMs using its image are the ones left to count
+	corrected, err := correctImageVMCount(imageUUID, imageVMCount[imageUUID], dryRun)
+	if corrected {
+		report.CorrectedCounts = append(report.CorrectedCounts, imageUUID)
+	}
+	if err != nil {
+		report.CleanupErrors = append(report.CleanupErrors, "image vm count "+imageUUID+": "+err.Error())
+	}
+	return report, nil
+}
+
+// domainsUsing returns the domains that are the VM uuid, are launched from the image uuid or have one of their disks
+// on the volume mounted at the mount path of uuid, and the number of the domains using each decrypted image
+func domainsUsing(domainXMLs []string, uuid string) ([]string, map[string]int, error) {
+	var users []string
+	imageVMCount := make(map[string]int)
+	mountPath := consts.MountPath + uuid + "/"
+	for _, domainXML := range domainXMLs {
+		d, err := parseDomain(domainXML, libvirt.Start)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "wlavm/cleanup:domainsUsing() error parsing the domain XML of a domain")
+		}
+		if d.GetImageUUID() != "" && strings.HasPrefix(d.GetImagePath(), consts.MountPath) {
+			imageVMCount[strings.ToLower(d.GetImageUUID())]++
+		}
+
+		uses := strings.EqualFold(d.GetVMUUID(), uuid) || strings.EqualFold(d.GetImageUUID(), uuid)
+		for _, disk := range d.GetDisks() {
+			paths := []string{disk.Path()}
+			// the disk of a VM launched from an encrypted image is a symbolic link to its volume
+			if target, err := filepath.EvalSymlinks(disk.Path()); err == nil {
+				paths = append(paths, target)
+			}
+			for _, backingStore := range disk.BackingChain() {
+				paths = append(paths, backingStore.Source.Path())
+			}
+			for _, path := range paths {
+				uses = uses || strings.HasPrefix(path, mountPath)
+			}
+		}
+		if uses {
+			users = append(users, d.GetVMUUID())
+		}
+	}
+	return users, imageVMCount, nil
+}
+
+// cleanupSparseFile returns the sparse file backing the volume of the VM or image uuid, backingFile when the volume is
+// open or the one in the VM state records when it is closed, or "" if it is not known
+func cleanupSparseFile(kind VolumeKind, uuid, backingFile string, vmState *VMState) (string, error) {
+	if backingFile != "" {
+		return backingFile, nil
+	}
+
+	sparseFile := ""
+
+	if kind == VolumeKindVM {
+		if vmState != nil && vmState.VMVolume != nil {
+			sparseFile = vmState.VMVolume.SparseFilePath
+		}
+	} else {
+		states, err := ListVMStates()
+		if err != nil {
+			return "", err
+		}
+		for _, state := range states {
+			if strings.EqualFold(state.ImageUUID, uuid) && state.ImageVolume != nil {
+				sparseFile = state.ImageVolume.SparseFilePath
+				break
+			}
+		}
+	}
+	// the path comes from a record on disk, only a sparse file of the agent is ever removed
+	if !strings.Contains(filepath.Base(sparseFile), sparseFileMarker) {
+		return "", nil
+	}
+	return sparseFile, nil
+}
+
+// correctImageVMCount sets the vm count of the image to vmCount, removing its association when no VM uses it, and
+// returns whether the count was corrected. Images without an association are left alone, their path is not known.
+func correctImageVMCount(imageUUID string, vmCount int, dryRun bool) (bool, error) {
+	util.MapMtx.Lock()
+	association, ok := util.ImageVMAssociations[imageUUID]
+	if !ok || association.VMCount == vmCount {
+		util.MapMtx.Unlock()
+		return false, nil
+	}
+	if dryRun {
+		util.MapMtx.Unlock()
+		return true, nil
+	}
+	log.Warnf("wlavm/cleanup:correctImageVMCount() Correcting the vm count of image %s from %d to %d", imageUUID,
+		association.VMCount, vmCount)
+	if vmCount == 0 {
+		delete(util.ImageVMAssociations, imageUUID)
+	} else {
+		association.VMCount = vmCount
+	}
+	util.MapMtx.Unlock()
+
+	if err := util.SaveImageVMAssociation(); err != nil {
+		return true, errors.Wrap(err, "wlavm/cleanup:correctImageVMCount() error saving the image vm associations")
+	}
+	return true, nil
+}
@@ -0,0 +1,110 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testDomainLister struct {
+	running []string
+	// shutOff are the domains that are defined but not running
+	shutOff []string
+}
+
+func (l testDomainLister) ListRunningDomains() ([]string, error) {
+	return l.running, nil
+}
+
+func (l testDomainLister) ListDefinedDomains() ([]string, error) {
+	return append(append([]string{}, l.running...), l.shutOff...), nil
+}
+
+func TestDomainsUsing(t *testing.T) {
+	domainXML, err := ioutil.ReadFile("../test/domain.xml")
+	assert.NoError(t, err)
+	// the VM of the test domain is launched from the decrypted image
+	decryptedImagePath := consts.MountPath + testImageUUID + "/" + testImageUUID
+	domainXMLs := []string{strings.Replace(string(domainXML),
+		"/var/lib/nova/instances/_base/dbee5739d526f9b742b8c7d4d829097965f4f718", decryptedImagePath, 1)}
+	const runningVMUUID = "412ea302-1759-440b-894a-bfef290d7a63"
+
+	users, imageVMCount, err := domainsUsing(domainXMLs, runningVMUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{runningVMUUID}, users)
+	assert.Equal(t, map[string]int{testImageUUID: 1}, imageVMCount)
+
+	users, _, err = domainsUsing(domainXMLs, testImageUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{runningVMUUID}, users)
+
+	users, _, err = domainsUsing(domainXMLs, testVMUUID)
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+
+	// a volume in use is refused before anything on the host is looked at
+	_, err = Cleanup(testDomainLister{running: domainXMLs}, VolumeKindImage, testImageUUID, false)
+	assert.Error(t, err)
+	_, err = Cleanup(testDomainLister{running: domainXMLs}, VolumeKindVM, "../"+testVMUUID, true)
+	assert.Error(t, err)
+
+	// so is a volume used by a VM that is shut off
+	_, err = Cleanup(testDomainLister{shutOff: domainXMLs}, VolumeKindVM, runningVMUUID, false)
+	assert.Error(t, err)
+}
+
+func TestCleanupRefusesVMWithDisk(t *testing.T) {
+	defer setupTestVMStateDir(t)()
+	dir, err := ioutil.TempDir("", "instance")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	vmPath := filepath.Join(dir, "disk")
+	assert.NoError(t, ioutil.WriteFile(vmPath, nil, 0600))
+	updateVMState(testVMUUID, true, func(state *VMState) {
+		state.ImageUUID = testImageUUID
+		state.VMPath = vmPath
+	})
+
+	// the VM may only be undefined for a while, its disk is still in place
+	_, err = Cleanup(testDomainLister{}, VolumeKindVM, testVMUUID, false)
+	assert.Error(t, err)
+	state, err := LoadVMState(testVMUUID)
+	assert.NoError(t, err)
+	assert.NotNil(t, state)
+}
+
+func TestCorrectImageVMCountDryRun(t *testing.T) {
+	defer func(associations map[string]*util.ImageVMAssociation) {
+		util.ImageVMAssociations = associations
+	}(util.ImageVMAssociations)
+	util.ImageVMAssociations = map[string]*util.ImageVMAssociation{
+		testImageUUID: {ImagePath: consts.MountPath + testImageUUID + "/" + testImageUUID, VMCount: 2},
+	}
+
+	corrected, err := correctImageVMCount(testImageUUID, 1, true)
+	assert.NoError(t, err)
+	assert.True(t, corrected)
+	assert.Equal(t, 2, util.ImageVMAssociations[testImageUUID].VMCount)
+
+	corrected, err = correctImageVMCount(testImageUUID, 2, true)
+	assert.NoError(t, err)
+	assert.False(t, corrected)
+
+	// an image without an association is not given one
+	corrected, err = correctImageVMCount(testVMUUID, 0, true)
+	assert.NoError(t, err)
+	assert.False(t, corrected)
+}
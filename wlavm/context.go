@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import (
+	"bytes"
+	"context"
+	"intel/isecl/wlagent/v4/config"
+	"intel/isecl/wlagent/v4/consts"
+	osexec "os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PhaseTimeout returns how long a VM lifecycle phase may take before it is cancelled, from the configuration or
+// the default of the phase when it is not configured
+func PhaseTimeout(phase Phase) time.Duration {
+	timeouts := config.Configuration.VMTimeouts
+	seconds := 0
+	switch phase {
+	case PhasePrepare:
+		seconds = timeouts.Prepare
+		if seconds <= 0 {
+			seconds = consts.DefaultPrepareTimeout
+		}
+	case PhaseStart:
+		seconds = timeouts.Start
+		if seconds <= 0 {
+			seconds = consts.DefaultStartTimeout
+		}
+	case PhaseStop:
+		seconds = timeouts.Stop
+		if seconds <= 0 {
+			seconds = consts.DefaultStopTimeout
+		}
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// PhaseContext returns the context a VM lifecycle phase runs with, which is done when the phase takes longer than its
+// timeout. Prepare and Start are cancelled with ctx as well, Stop closes the volumes of the VM and is not
+func PhaseContext(ctx context.Context, phase Phase) (context.Context, context.CancelFunc) {
+	if phase == PhaseStop {
+		ctx = context.Background()
+	}
+	return context.WithTimeout(ctx, PhaseTimeout(phase))
+}
+
+// executeCommand runs the command and returns its standard output. The command is killed when ctx is done
+func executeCommand(ctx context.Context, name string, args []string) (string, error) {
+	return executeCommandWithInput(ctx, name, args, nil)
+}
+
+// executeCommandWithInput runs the command with input on its standard input, so that secrets such as volume keys
+// are not passed on the command line
+func executeCommandWithInput(ctx context.Context, name string, args []string, input []byte) (string, error) {
+	var stderr bytes.Buffer
+	cmd := osexec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	output, err := cmd.Output()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return string(output), errors.Wrapf(ctxErr, "wlavm/context:executeCommand() %s was killed", name)
+	}
+	if err != nil {
+		return string(output), errors.Wrapf(err, "wlavm/context:executeCommand() %s failed: %s", name, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
+
+// contextError returns a Timeout VMError in place of err when err was caused by ctx being done, so that the
+// libvirt hook reports the timeout or the cancellation rather than the step that was interrupted
+func contextError(ctx context.Context, phase Phase, err error) error {
+	ctxErr := ctx.Err()
+	if err == nil || ctxErr == nil {
+		return err
+	}
+	if vmErr, ok := err.(*VMError); ok && vmErr.Code == Timeout {
+		return err
+	}
+	message := "the operation was cancelled"
+	if ctxErr == context.DeadlineExceeded {
+		message = "the operation did not finish within " + PhaseTimeout(phase).String()
+	}
+	return newVMError(phase, Timeout, err, message)
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import (
+	"context"
+	"intel/isecl/wlagent/v4/config"
+	"intel/isecl/wlagent/v4/consts"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPhaseTimeout(t *testing.T) {
+	timeouts := config.Configuration.VMTimeouts
+	defer func() {
+		config.Configuration.VMTimeouts = timeouts
+	}()
+
+	config.Configuration.VMTimeouts.Prepare = 0
+	config.Configuration.VMTimeouts.Stop = 30
+	assert.Equal(t, consts.DefaultPrepareTimeout*time.Second, PhaseTimeout(PhasePrepare))
+	assert.Equal(t, 30*time.Second, PhaseTimeout(PhaseStop))
+}
+
+func TestExecuteCommandKilled(t *testing.T) {
+	output, err := executeCommand(context.Background(), "echo", []string{"qcow2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "qcow2\n", output)
+
+	_, err = executeCommand(context.Background(), "false", nil)
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = executeCommand(ctx, "sleep", []string{"10"})
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestContextError(t *testing.T) {
+	err := newVMError(PhasePrepare, QemuImgFailed, nil, "error resizing the VM disk")
+	assert.Equal(t, err, contextError(context.Background(), PhasePrepare, err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Nil(t, contextError(ctx, PhasePrepare, nil))
+	vmErr, ok := contextError(ctx, PhasePrepare, err).(*VMError)
+	assert.True(t, ok)
+	assert.Equal(t, Timeout, vmErr.Code)
+	assert.Equal(t, err, vmErr.Err)
+	assert.Equal(t, vmErr, contextError(ctx, PhasePrepare, vmErr))
+}
+
+func TestPhaseContext(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := PhaseContext(parent, PhasePrepare)
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.True(t, ok)
+
+	// the teardown of a VM is bounded by its timeout but not cancelled with the client call
+	stopCtx, stopCancel := PhaseContext(parent, PhaseStop)
+	defer stopCancel()
+	deadline, ok := stopCtx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(PhaseTimeout(PhaseStop)), deadline, time.Second)
+	cancelParent()
+	assert.Equal(t, context.Canceled, ctx.Err())
+	assert.NoError(t, stopCtx.Err())
+}
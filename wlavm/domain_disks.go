@@ -0,0 +1,139 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"context"
+	"fmt"
+	"intel/isecl/lib/common/v4/crypt"
+	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/libvirt"
+	"os"
+	"strings"
+)
+
+// parseDomain parses the domain XML, picks the disk of the VM on a volume of the agent out of all its disks, if the VM
+// has one, and resolves the image of the VM when the domain XML does not name it
+func parseDomain(domainXML string, qemuInterceptCall libvirt.QemuIntercept) (*libvirt.DomainParser, error) {
+	d, err := libvirt.NewDomainParser(domainXML, qemuInterceptCall)
+	if err != nil {
+		return nil, err
+	}
+	if disk, ok := volumeBackedDisk(d.GetDisks()); ok {
+		d.UseDisk(disk)
+	}
+	if d.GetImageUUID() == "" {
+		d.SetImageUUID(resolveImageUUID(d.GetVMUUID(), d.GetImagePath()))
+	}
+	return d, nil
+}
+
+// volumeBackedDisk returns the disk of the VM that is a symbolic link to the volume of the VM, or that is backed by
+// a decrypted image. It is the disk the agent prepared for a VM launched from an encrypted image.
+func volumeBackedDisk(disks []libvirt.Disk) (libvirt.Disk, bool) {
+	for _, disk := range disks {
+		if !disk.IsWritable() || !isLocalDisk(disk) {
+			continue
+		}
+		if target, err := os.Readlink(disk.Path()); err == nil && (strings.HasPrefix(target, consts.MountPath) || isAgentDeviceMapper(target)) {
+			return disk, true
+		}
+		for _, backingStore := range disk.BackingChain() {
+			if strings.HasPrefix(backingStore.Source.Path(), consts.MountPath) {
+				return disk, true
+			}
+		}
+	}
+	return libvirt.Disk{}, false
+}
+
+// imageBackedDisk returns the disk of the VM backed by the encrypted image, or is a standalone copy of it, or already
+// prepared by the agent. The domain XML passed to the prepare hook may not describe the backing chain, the backing
+// file of the disks is then read from the disk files.
+func imageBackedDisk(ctx context.Context, vmUUID string, disks []libvirt.Disk) (libvirt.Disk, bool) {
+	if disk, ok := volumeBackedDisk(disks); ok {
+		return disk, true
+	}
+	for _, disk := range disks {
+		if !disk.IsWritable() || !isLocalDisk(disk) {
+			continue
+		}
+		// the block device of a VM stopped is formatted by the agent, its backing file is on the volume
+		if disk.Type == libvirt.DiskTypeBlock {
+			formatted, err := isAgentBlockVolume(ctx, disk.Path(), vmUUID)
+			if err != nil {
+				log.WithError(err).Warnf("wlavm/domain_disks:imageBackedDisk() Error checking block device %s", disk.Path())
+			}
+			if formatted {
+				return disk, true
+			}
+		}
+		backingFile := disk.BackingFile()
+		if backingFile == "" {
+			info, err := qemuImgInfo(ctx, disk.Path())
+			if err != nil {
+				log.WithError(err).Debugf("wlavm/domain_disks:imageBackedDisk() Skipping disk %s", disk.Path())
+				continue
+			}
+			backingFile = info.BackingFile
+		}
+		if backingFile == "" {
+			if encrypted, err := crypt.EncryptionHeaderExists(disk.Path()); err == nil && encrypted {
+				return disk, true
+			}
+			continue
+		}
+		if strings.HasPrefix(backingFile, consts.MountPath) {
+			return disk, true
+		}
+		if encrypted, err := crypt.EncryptionHeaderExists(backingFile); err == nil && encrypted {
+			return disk, true
+		}
+	}
+	return libvirt.Disk{}, false
+}
+
+// isLocalDisk returns true if the disk is a file or a block device of the host, which the agent can prepare
+func isLocalDisk(disk libvirt.Disk) bool {
+	return disk.Type == libvirt.DiskTypeFile || disk.Type == libvirt.DiskTypeBlock
+}
+
+// qemuImageInfo holds the fields of qemu-img info used by the agent
+type qemuImageInfo struct {
+	BackingFile   string
+	BackingFormat string
+	Format        string
+	// VirtualSize is the size of the disk seen by the VM, in bytes
+	VirtualSize int64
+}
+
+// qemuImgInfo returns the backing file, the format and the virtual size of a disk file from qemu-img info
+func qemuImgInfo(ctx context.Context, diskPath string) (qemuImageInfo, error) {
+	var info qemuImageInfo
+	output, err := executeCommand(ctx, consts.QemuImgUtilPath, strings.Fields(fmt.Sprintf(consts.GetImgInfoCmd, diskPath)))
+	if err != nil {
+		return info, err
+	}
+	for _, line := range strings.Split(output, "\n") {
+		lineSplit := strings.Split(strings.TrimSpace(line), ": ")
+		if len(lineSplit) < 2 {
+			continue
+		}
+		switch lineSplit[0] {
+		case consts.QemuImgInfoBackingFileField:
+			info.BackingFile = lineSplit[1]
+		case consts.QemuImgInfoBackingFormatField:
+			info.BackingFormat = lineSplit[1]
+		case consts.QemuImgInfoFileFormatField:
+			info.Format = lineSplit[1]
+		case consts.QemuImgInfoVirtualSizeField:
+			info.VirtualSize = parseVirtualSize(lineSplit[1])
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,52 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/libvirt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDomainPicksVolumeBackedDisk(t *testing.T) {
+	domainXML, err := ioutil.ReadFile("../test/domain_disks.xml")
+	assert.NoError(t, err)
+
+	// the root disk is the last disk of the domain, after a config drive, attached volumes and an ephemeral disk
+	d, err := parseDomain(string(domainXML), libvirt.Start)
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/lib/nova/instances/"+testVMUUID+"/disk", d.GetVMPath())
+	assert.Equal(t, consts.MountPath+testImageUUID+"/"+testImageUUID, d.GetImagePath())
+
+	// a VM restarted from shutoff only has the symbolic link of its disk to its volume
+	dir, err := ioutil.TempDir("", "domain-disks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	vmPath := filepath.Join(dir, "disk")
+	assert.NoError(t, os.Symlink(consts.MountPath+testVMUUID+"/disk", vmPath))
+	disks := []libvirt.Disk{
+		{Type: libvirt.DiskTypeFile, Device: libvirt.DiskDeviceDisk, Source: libvirt.Source{File: filepath.Join(dir, "disk.eph0")}},
+		{Type: libvirt.DiskTypeFile, Device: libvirt.DiskDeviceDisk, Source: libvirt.Source{File: vmPath}},
+	}
+	disk, ok := volumeBackedDisk(disks)
+	assert.True(t, ok)
+	assert.Equal(t, vmPath, disk.Path())
+
+	// a VM that was not launched from an encrypted image keeps its first disk
+	d, err = parseDomain(strings.Replace(string(domainXML), consts.MountPath, "/var/lib/nova/instances/_base/", -1), libvirt.Start)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/disk/by-id/wwn-0x6001405b5c1a2e3d", d.GetVMPath())
+	_, ok = volumeBackedDisk(disks[:1])
+	assert.False(t, ok)
+}
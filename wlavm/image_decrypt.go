@@ -0,0 +1,320 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"intel/isecl/lib/common/v4/crypt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	gcmBlockSize     = 16
+	gcmTagSize       = 16
+	gcmNonceSize     = 12
+	decryptChunkSize = 1 << 20
+	// maximum plaintext length of a single AES-GCM message
+	gcmMaxPlaintextSize = (1<<32 - 2) * gcmBlockSize
+	// decryptingSuffix is appended to the path of a decrypted image until its ciphertext is authenticated
+	decryptingSuffix = ".decrypting"
+)
+
+// decryptProgress is called while an image is decrypted with the number of bytes decrypted so far
+// and the total number of bytes to decrypt
+type decryptProgress func(done, total int64)
+
+// decryptImageFile decrypts the encrypted image at srcPath into dstPath chunk by chunk, as vml.Decrypt needs the whole
+// image in memory. The plaintext is written under a temporary name that is renamed to dstPath once the GCM tag
+// authenticates the ciphertext. The decryption stops when ctx is done
+func decryptImageFile(ctx context.Context, srcPath, dstPath string, key []byte, progress decryptProgress) error {
+	log.Trace("wlavm/image_decrypt:decryptImageFile() Entering")
+	defer log.Trace("wlavm/image_decrypt:decryptImageFile() Leaving")
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return errors.Wrapf(err, "wlavm/image_decrypt:decryptImageFile() error opening encrypted image %s", srcPath)
+	}
+	defer func() {
+		derr := src.Close()
+		if derr != nil {
+			log.WithError(derr).Error("Error closing file")
+		}
+	}()
+
+	// the size of a block device is not in its file info, the device must hold exactly the encrypted image
+	srcSize, err := src.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = src.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "wlavm/image_decrypt:decryptImageFile() error reading size of encrypted image %s", srcPath)
+	}
+	nonce, offset, err := parseEncryptionHeader(src, srcSize)
+	if err != nil {
+		return errors.Wrapf(err, "wlavm/image_decrypt:decryptImageFile() error reading encrypted image %s", srcPath)
+	}
+	ciphertextLen := srcSize - offset - gcmTagSize
+	if ciphertextLen < 0 {
+		return errors.New("wlavm/image_decrypt:decryptImageFile() encrypted image is truncated")
+	}
+	if _, err = src.Seek(offset, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "wlavm/image_decrypt:decryptImageFile() error seeking to the ciphertext of %s", srcPath)
+	}
+
+	partialPath := dstPath + decryptingSuffix
+	dst, err := os.OpenFile(partialPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0664)
+	if err != nil {
+		return errors.Wrapf(err, "wlavm/image_decrypt:decryptImageFile() error creating decrypted image %s", partialPath)
+	}
+	err = decryptGCMStream(ctx, dst, src, ciphertextLen, key, nonce, progress)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil && closeErr != nil {
+		err = errors.Wrapf(closeErr, "wlavm/image_decrypt:decryptImageFile() error closing decrypted image %s", partialPath)
+	}
+	if err == nil {
+		err = os.Rename(partialPath, dstPath)
+	}
+	if err != nil {
+		// never leave unauthenticated or partially decrypted data behind
+		if rmErr := os.Remove(partialPath); rmErr != nil && !os.IsNotExist(rmErr) {
+			log.WithError(rmErr).Errorf("wlavm/image_decrypt:decryptImageFile() error removing decrypted image %s", partialPath)
+		}
+		return err
+	}
+	return nil
+}
+
+// parseEncryptionHeader reads the encryption header of an encrypted image of imageSize bytes from r and returns the
+// nonce and the offset of the ciphertext it describes
+func parseEncryptionHeader(r io.Reader, imageSize int64) (nonce []byte, offset int64, err error) {
+	var header crypt.EncryptionHeader
+	headerSize := int64(binary.Size(header))
+	if imageSize < headerSize {
+		return nil, 0, errors.New("wlavm/image_decrypt:parseEncryptionHeader() encrypted image is shorter than the encryption header")
+	}
+	err = binary.Read(r, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "wlavm/image_decrypt:parseEncryptionHeader() error reading the encryption header")
+	}
+	if !bytes.HasPrefix(header.MagicText[:], []byte(crypt.EncryptionHeaderMagicText)) {
+		return nil, 0, errors.New("wlavm/image_decrypt:parseEncryptionHeader() the image does not carry the encryption header")
+	}
+	if version := string(bytes.TrimRight(header.Version[:], "\x00")); version != crypt.EncryptionHeaderVersion {
+		return nil, 0, errors.Errorf("wlavm/image_decrypt:parseEncryptionHeader() unsupported encryption header version %q", version)
+	}
+	if algorithm := string(bytes.TrimRight(header.EncryptionAlgorithm[:], "\x00")); algorithm != crypt.GCMEncryptionAlgorithm {
+		return nil, 0, errors.Errorf("wlavm/image_decrypt:parseEncryptionHeader() unsupported encryption algorithm %q", algorithm)
+	}
+	offset = int64(header.OffsetInLittleEndian)
+	if offset < headerSize || offset > imageSize {
+		return nil, 0, errors.Errorf("wlavm/image_decrypt:parseEncryptionHeader() invalid ciphertext offset %d", offset)
+	}
+	return header.IV[:], offset, nil
+}
+
+// decryptGCMStream reads ciphertextLen bytes of AES-GCM ciphertext followed by the authentication tag from src,
+// and writes the plaintext to dst. An error is returned if the tag does not authenticate the ciphertext, or if ctx
+// is done before the whole ciphertext is decrypted.
+func decryptGCMStream(ctx context.Context, dst io.Writer, src io.Reader, ciphertextLen int64, key, nonce []byte, progress decryptProgress) error {
+	if len(nonce) != gcmNonceSize {
+		return errors.New("wlavm/image_decrypt:decryptGCMStream() invalid nonce size")
+	}
+	if ciphertextLen > gcmMaxPlaintextSize {
+		return errors.New("wlavm/image_decrypt:decryptGCMStream() ciphertext exceeds the maximum AES-GCM message size")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return errors.Wrap(err, "wlavm/image_decrypt:decryptGCMStream() error initializing AES cipher")
+	}
+
+	var counter [gcmBlockSize]byte
+	var tagMask [gcmBlockSize]byte
+	copy(counter[:], nonce)
+	counter[gcmBlockSize-1] = 1
+	block.Encrypt(tagMask[:], counter[:])
+	// the counter never wraps around the lower 32 bits within the maximum message size,
+	// so a plain CTR stream matches the GCM counter increments
+	counter[gcmBlockSize-1] = 2
+	ctr := cipher.NewCTR(block, counter[:])
+	g := newGHash(block)
+
+	buf := make([]byte, decryptChunkSize)
+	var done int64
+	for done < ciphertextLen {
+		if err = ctx.Err(); err != nil {
+			return errors.Wrap(err, "wlavm/image_decrypt:decryptGCMStream() decryption stopped")
+		}
+		chunk := buf
+		if remaining := ciphertextLen - done; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		_, err = io.ReadFull(src, chunk)
+		if err != nil {
+			return errors.Wrap(err, "wlavm/image_decrypt:decryptGCMStream() error reading ciphertext")
+		}
+		g.update(chunk)
+		ctr.XORKeyStream(chunk, chunk)
+		_, err = dst.Write(chunk)
+		if err != nil {
+			return errors.Wrap(err, "wlavm/image_decrypt:decryptGCMStream() error writing plaintext")
+		}
+		done += int64(len(chunk))
+		if progress != nil {
+			progress(done, ciphertextLen)
+		}
+	}
+
+	tag := make([]byte, gcmTagSize)
+	_, err = io.ReadFull(src, tag)
+	if err != nil {
+		return errors.Wrap(err, "wlavm/image_decrypt:decryptGCMStream() error reading authentication tag")
+	}
+	expectedTag := g.sum(uint64(ciphertextLen), tagMask[:])
+	if subtle.ConstantTimeCompare(tag, expectedTag) != 1 {
+		return errors.New("wlavm/image_decrypt:decryptGCMStream() message authentication failed")
+	}
+	return nil
+}
+
+// gcmFieldElement represents a value in GF(2¹²⁸) in the bit order used by GCM, low holds the first 64 bits
+type gcmFieldElement struct {
+	low, high uint64
+}
+
+// ghash computes the GCM authentication hash over a stream of ciphertext using a 4-bit table per Shoup's method
+type ghash struct {
+	productTable [16]gcmFieldElement
+	y            gcmFieldElement
+	partial      [gcmBlockSize]byte
+	partialLen   int
+}
+
+var gcmReductionTable = []uint16{
+	0x0000, 0x1c20, 0x3840, 0x2460, 0x7080, 0x6ca0, 0x48c0, 0x54e0,
+	0xe100, 0xfd20, 0xd940, 0xc560, 0x9180, 0x8da0, 0xa9c0, 0xb5e0,
+}
+
+func newGHash(block cipher.Block) *ghash {
+	var hashKey [gcmBlockSize]byte
+	block.Encrypt(hashKey[:], hashKey[:])
+
+	g := &ghash{}
+	x := gcmFieldElement{
+		binary.BigEndian.Uint64(hashKey[:8]),
+		binary.BigEndian.Uint64(hashKey[8:]),
+	}
+	g.productTable[reverseBits(1)] = x
+	for i := 2; i < 16; i += 2 {
+		g.productTable[reverseBits(i)] = gcmDouble(&g.productTable[reverseBits(i/2)])
+		g.productTable[reverseBits(i+1)] = gcmAdd(&g.productTable[reverseBits(i)], &x)
+	}
+	return g
+}
+
+// update absorbs data into the hash, data does not need to be block aligned
+func (g *ghash) update(data []byte) {
+	if g.partialLen > 0 {
+		n := copy(g.partial[g.partialLen:], data)
+		g.partialLen += n
+		data = data[n:]
+		if g.partialLen < gcmBlockSize {
+			return
+		}
+		g.updateBlocks(g.partial[:])
+		g.partialLen = 0
+	}
+	fullBlocks := (len(data) / gcmBlockSize) * gcmBlockSize
+	g.updateBlocks(data[:fullBlocks])
+	g.partialLen = copy(g.partial[:], data[fullBlocks:])
+}
+
+// sum pads the last partial block, absorbs the length block and returns the tag
+func (g *ghash) sum(ciphertextLen uint64, tagMask []byte) []byte {
+	if g.partialLen > 0 {
+		for i := g.partialLen; i < gcmBlockSize; i++ {
+			g.partial[i] = 0
+		}
+		g.updateBlocks(g.partial[:])
+		g.partialLen = 0
+	}
+	// there is no additional data, only the ciphertext length is hashed
+	g.y.high ^= ciphertextLen * 8
+	g.mul(&g.y)
+
+	tag := make([]byte, gcmTagSize)
+	binary.BigEndian.PutUint64(tag, g.y.low)
+	binary.BigEndian.PutUint64(tag[8:], g.y.high)
+	for i := range tag {
+		tag[i] ^= tagMask[i]
+	}
+	return tag
+}
+
+func (g *ghash) updateBlocks(blocks []byte) {
+	for len(blocks) > 0 {
+		g.y.low ^= binary.BigEndian.Uint64(blocks)
+		g.y.high ^= binary.BigEndian.Uint64(blocks[8:])
+		g.mul(&g.y)
+		blocks = blocks[gcmBlockSize:]
+	}
+}
+
+// mul sets y to y*H, where H is the hash key
+func (g *ghash) mul(y *gcmFieldElement) {
+	var z gcmFieldElement
+	for i := 0; i < 2; i++ {
+		word := y.high
+		if i == 1 {
+			word = y.low
+		}
+		// multiply z by 16 and add one of the precomputed multiples of H
+		for j := 0; j < 64; j += 4 {
+			msw := z.high & 0xf
+			z.high >>= 4
+			z.high |= z.low << 60
+			z.low >>= 4
+			z.low ^= uint64(gcmReductionTable[msw]) << 48
+
+			t := &g.productTable[word&0xf]
+			z.low ^= t.low
+			z.high ^= t.high
+			word >>= 4
+		}
+	}
+	*y = z
+}
+
+func reverseBits(i int) int {
+	i = ((i << 2) & 0xc) | ((i >> 2) & 0x3)
+	i = ((i << 1) & 0xa) | ((i >> 1) & 0x5)
+	return i
+}
+
+func gcmAdd(x, y *gcmFieldElement) gcmFieldElement {
+	return gcmFieldElement{x.low ^ y.low, x.high ^ y.high}
+}
+
+func gcmDouble(x *gcmFieldElement) (double gcmFieldElement) {
+	msbSet := x.high&1 == 1
+	double.high = x.high >> 1
+	double.high |= x.low << 63
+	double.low = x.low >> 1
+	if msbSet {
+		double.low ^= 0xe100000000000000
+	}
+	return
+}
@@ -0,0 +1,185 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"intel/isecl/lib/common/v4/crypt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// encryptForTest encrypts plaintext the way the workload policy manager encrypts images, with padding bytes between
+// the encryption header and the ciphertext
+func encryptForTest(t *testing.T, key, plaintext []byte, padding int) []byte {
+	var header crypt.EncryptionHeader
+	nonce := make([]byte, len(header.IV))
+	_, _ = rand.Read(nonce)
+	copy(header.MagicText[:], crypt.EncryptionHeaderMagicText)
+	copy(header.EncryptionAlgorithm[:], crypt.GCMEncryptionAlgorithm)
+	copy(header.IV[:], nonce)
+	copy(header.Version[:], crypt.EncryptionHeaderVersion)
+	header.OffsetInLittleEndian = uint32(binary.Size(header) + padding)
+
+	var encrypted bytes.Buffer
+	assert.NoError(t, binary.Write(&encrypted, binary.LittleEndian, header))
+	encrypted.Write(make([]byte, padding))
+	block, err := aes.NewCipher(key)
+	assert.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	assert.NoError(t, err)
+	return gcm.Seal(encrypted.Bytes(), nonce, plaintext, nil)
+}
+
+func setupTestDecrypt(t *testing.T, encrypted []byte) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "image-decrypt")
+	assert.NoError(t, err)
+	srcPath := filepath.Join(dir, "encrypted")
+	assert.NoError(t, ioutil.WriteFile(srcPath, encrypted, 0600))
+	return srcPath, filepath.Join(dir, "decrypted"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDecryptImageFile(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+
+	// cover empty input, partial blocks, large inputs and a ciphertext away from the header
+	for _, test := range []struct{ size, padding int }{{0, 0}, {1, 0}, {4095, 0}, {1<<20 + 7, 0}, {100, 512}} {
+		plaintext := make([]byte, test.size)
+		_, _ = rand.Read(plaintext)
+		srcPath, dstPath, cleanup := setupTestDecrypt(t, encryptForTest(t, key, plaintext, test.padding))
+
+		var lastDone int64
+		err := decryptImageFile(context.Background(), srcPath, dstPath, key, func(done, total int64) {
+			assert.Equal(t, int64(test.size), total)
+			lastDone = done
+		})
+		assert.NoError(t, err, "size %d", test.size)
+		decrypted, err := ioutil.ReadFile(dstPath)
+		assert.NoError(t, err)
+		assert.Equal(t, plaintext, decrypted, "size %d", test.size)
+		assert.Equal(t, int64(test.size), lastDone)
+		_, err = os.Stat(dstPath + decryptingSuffix)
+		assert.True(t, os.IsNotExist(err))
+		cleanup()
+	}
+}
+
+func TestDecryptImageFileRejectsTamperedCiphertext(t *testing.T) {
+	key := make([]byte, 32)
+	encrypted := encryptForTest(t, key, []byte("qcow2 image contents that must not be accepted once modified"), 0)
+	encrypted[len(encrypted)-30] ^= 0x01
+	srcPath, dstPath, cleanup := setupTestDecrypt(t, encrypted)
+	defer cleanup()
+
+	assert.Error(t, decryptImageFile(context.Background(), srcPath, dstPath, key, nil))
+	// nothing is left behind, neither under the decrypted image path nor under its temporary path
+	for _, path := range []string{dstPath, dstPath + decryptingSuffix} {
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err), path)
+	}
+
+	// a truncated image is not authenticated either
+	assert.NoError(t, ioutil.WriteFile(srcPath, encryptForTest(t, key, make([]byte, 100), 0)[:100], 0600))
+	assert.Error(t, decryptImageFile(context.Background(), srcPath, dstPath, key, nil))
+}
+
+func TestParseEncryptionHeader(t *testing.T) {
+	key := make([]byte, 32)
+	encrypted := encryptForTest(t, key, make([]byte, 64), 0)
+	var header crypt.EncryptionHeader
+	headerSize := binary.Size(header)
+	nonce, offset, err := parseEncryptionHeader(bytes.NewReader(encrypted), int64(len(encrypted)))
+	assert.NoError(t, err)
+	assert.Equal(t, encrypted[20:32], nonce)
+	assert.Equal(t, int64(headerSize), offset)
+
+	for name, corrupt := range map[string]func([]byte){
+		"magic":     func(image []byte) { image[0] = 'X' },
+		"version":   func(image []byte) { image[16] = '2' },
+		"algorithm": func(image []byte) { copy(image[32:], "CBC-256") },
+		"offset":    func(image []byte) { binary.LittleEndian.PutUint32(image[12:], uint32(len(image)+1)) },
+		"header":    func(image []byte) { binary.LittleEndian.PutUint32(image[12:], 8) },
+	} {
+		image := append([]byte{}, encrypted...)
+		corrupt(image)
+		_, _, err = parseEncryptionHeader(bytes.NewReader(image), int64(len(image)))
+		assert.Error(t, err, name)
+	}
+	_, _, err = parseEncryptionHeader(bytes.NewReader(encrypted[:headerSize-1]), int64(headerSize-1))
+	assert.Error(t, err)
+}
+
+func sealForTest(t *testing.T, key, nonce, plaintext []byte) []byte {
+	block, err := aes.NewCipher(key)
+	assert.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	assert.NoError(t, err)
+	return gcm.Seal(nil, nonce, plaintext, nil)
+}
+
+func TestDecryptGCMStream(t *testing.T) {
+	key := make([]byte, 32)
+	nonce := make([]byte, gcmNonceSize)
+	_, _ = rand.Read(key)
+	_, _ = rand.Read(nonce)
+
+	// cover empty input, partial blocks and inputs spanning several chunks
+	for _, size := range []int{0, 1, 15, 16, 17, 4095, decryptChunkSize, 2*decryptChunkSize + 7} {
+		plaintext := make([]byte, size)
+		_, _ = rand.Read(plaintext)
+		sealed := sealForTest(t, key, nonce, plaintext)
+
+		out := bytes.NewBuffer(make([]byte, 0, size))
+		var calls int
+		err := decryptGCMStream(context.Background(), out, bytes.NewReader(sealed), int64(size), key, nonce, func(done, total int64) {
+			assert.Equal(t, int64(size), total)
+			calls++
+		})
+		assert.NoError(t, err, "size %d", size)
+		assert.Equal(t, plaintext, out.Bytes(), "size %d", size)
+		// the progress is reported for each chunk
+		assert.Equal(t, (size+decryptChunkSize-1)/decryptChunkSize, calls, "size %d", size)
+	}
+
+	// a truncated tag is not authenticated
+	sealed := sealForTest(t, key, nonce, make([]byte, 100))
+	var out bytes.Buffer
+	assert.Error(t, decryptGCMStream(context.Background(), &out, bytes.NewReader(sealed[:len(sealed)-1]), 100, key, nonce, nil))
+}
+
+func TestDecryptImageFileCancelled(t *testing.T) {
+	key := make([]byte, 32)
+	srcPath, dstPath, cleanup := setupTestDecrypt(t, encryptForTest(t, key, make([]byte, 3*decryptChunkSize), 0))
+	defer cleanup()
+
+	// the decryption stops at the next chunk once ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	var lastDone int64
+	err := decryptImageFile(ctx, srcPath, dstPath, key, func(done, total int64) {
+		lastDone = done
+		cancel()
+	})
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.Equal(t, int64(decryptChunkSize), lastDone)
+	for _, path := range []string{dstPath, dstPath + decryptingSuffix} {
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err), path)
+	}
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// preparedImage is the result of preparing an encrypted image for the VMs using it
+type preparedImage struct {
+	key           []byte
+	keyID         string
+	backingFormat string
+}
+
+// imageFlight is the preparation of an image in progress, waiters block on done and then read result and err
+type imageFlight struct {
+	done    chan struct{}
+	waiters int
+	result  preparedImage
+	err     error
+}
+
+var (
+	imageFlightsMtx sync.Mutex
+	imageFlights    = make(map[string]*imageFlight)
+)
+
+// prepareImageOnce runs prepare for the image unless it is already running for another VM. In that case it
+// waits for the running preparation to finish and returns its result, so that VMs launched together from the
+// same image all get the same key or the same error while the image is decrypted only once. A waiter stops
+// waiting when its ctx is done, the preparation runs on with the ctx of the VM that started it.
+func prepareImageOnce(ctx context.Context, imageUUID string, prepare func() (preparedImage, error)) (preparedImage, error) {
+	imageFlightsMtx.Lock()
+	if flight, ok := imageFlights[imageUUID]; ok {
+		flight.waiters++
+		imageFlightsMtx.Unlock()
+		log.Infof("wlavm/image_flight:prepareImageOnce() Image %s is being prepared for another VM, waiting for it", imageUUID)
+		select {
+		case <-flight.done:
+			return flight.result, flight.err
+		case <-ctx.Done():
+			return preparedImage{}, errors.Wrapf(ctx.Err(), "wlavm/image_flight:prepareImageOnce() stopped waiting for image %s", imageUUID)
+		}
+	}
+	flight := &imageFlight{done: make(chan struct{})}
+	imageFlights[imageUUID] = flight
+	imageFlightsMtx.Unlock()
+
+	defer func() {
+		imageFlightsMtx.Lock()
+		delete(imageFlights, imageUUID)
+		if flight.waiters > 0 {
+			log.Infof("wlavm/image_flight:prepareImageOnce() Sharing the preparation of image %s with %d waiting VMs", imageUUID, flight.waiters)
+		}
+		imageFlightsMtx.Unlock()
+		close(flight.done)
+	}()
+	flight.result, flight.err = prepare()
+	return flight.result, flight.err
+}
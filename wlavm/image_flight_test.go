@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForImageWaiters waits until the given number of callers joined the running preparation of the image
+func waitForImageWaiters(t *testing.T, imageUUID string, waiters int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		imageFlightsMtx.Lock()
+		flight, ok := imageFlights[imageUUID]
+		joined := ok && flight.waiters == waiters
+		imageFlightsMtx.Unlock()
+		if joined {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d callers did not join the preparation of image %s", waiters, imageUUID)
+}
+
+func TestPrepareImageOnceSharesResult(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	prepare := func() (preparedImage, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return preparedImage{key: []byte("key"), backingFormat: "qcow2"}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]preparedImage, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			image, err := prepareImageOnce(context.Background(), "image", prepare)
+			assert.NoError(t, err)
+			results[i] = image
+		}(i)
+	}
+	waitForImageWaiters(t, "image", len(results)-1)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, image := range results {
+		assert.Equal(t, preparedImage{key: []byte("key"), backingFormat: "qcow2"}, image)
+	}
+	assert.Empty(t, imageFlights)
+}
+
+func TestPrepareImageOnceSharesError(t *testing.T) {
+	release := make(chan struct{})
+	results := make(chan error, 2)
+	prepareErr := errors.New("key denied")
+
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := prepareImageOnce(context.Background(), "image", func() (preparedImage, error) {
+				<-release
+				return preparedImage{}, prepareErr
+			})
+			results <- err
+		}()
+	}
+	waitForImageWaiters(t, "image", 1)
+	close(release)
+	assert.Equal(t, prepareErr, <-results)
+	assert.Equal(t, prepareErr, <-results)
+}
+
+func TestPrepareImageOnceWaiterCancelled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	go func() {
+		_, _ = prepareImageOnce(context.Background(), "image", func() (preparedImage, error) {
+			<-release
+			return preparedImage{}, nil
+		})
+	}()
+	waitForImageWaiters(t, "image", 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		_, err := prepareImageOnce(ctx, "image", func() (preparedImage, error) {
+			return preparedImage{}, nil
+		})
+		result <- err
+	}()
+	waitForImageWaiters(t, "image", 1)
+	cancel()
+	assert.Equal(t, context.Canceled, errors.Cause(<-result))
+}
@@ -0,0 +1,185 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"context"
+	"intel/isecl/wlagent/v4/consts"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// ImageResolver identifies the image a VM is launched from, when the domain XML names it neither in the Nova metadata
+// nor in the metadata of the agent. The encryption header of an image does not hold its UUID
+type ImageResolver interface {
+	// ResolveImageUUID returns the UUID of the image of the VM, whose disk is backed by imagePath, or "" if the
+	// resolver does not know the image
+	ResolveImageUUID(vmUUID, imagePath string) (string, error)
+}
+
+// imageResolvers are asked in turn for the image of a VM
+var imageResolvers = []ImageResolver{
+	decryptedImageResolver{},
+	vmStateImageResolver{},
+	ImageRegistry{Path: consts.ImageRegistryFilePath},
+}
+
+// resolveImageUUID returns the UUID of the image of the VM from the first resolver that knows it, or ""
+func resolveImageUUID(vmUUID, imagePath string) string {
+	log.Trace("wlavm/image_identity:resolveImageUUID() Entering")
+	defer log.Trace("wlavm/image_identity:resolveImageUUID() Leaving")
+
+	for _, resolver := range imageResolvers {
+		imageUUID, err := resolver.ResolveImageUUID(vmUUID, imagePath)
+		if err != nil {
+			log.WithError(err).Warnf("wlavm/image_identity:resolveImageUUID() Error resolving the image of VM %s", vmUUID)
+			continue
+		}
+		if imageUUID != "" {
+			log.Debugf("wlavm/image_identity:resolveImageUUID() VM %s is launched from image %s", vmUUID, imageUUID)
+			return imageUUID
+		}
+	}
+	return ""
+}
+
+// decryptedImageResolver identifies the image of a VM running on a decrypted image, which is named after the image
+type decryptedImageResolver struct{}
+
+func (decryptedImageResolver) ResolveImageUUID(vmUUID, imagePath string) (string, error) {
+	if !strings.HasPrefix(imagePath, consts.MountPath) {
+		return "", nil
+	}
+	imageUUID := filepath.Base(imagePath)
+	if !uuidRegex.MatchString(imageUUID) {
+		return "", nil
+	}
+	return imageUUID, nil
+}
+
+// vmStateImageResolver identifies the image of a VM from the state recorded when the VM was prepared
+type vmStateImageResolver struct{}
+
+func (vmStateImageResolver) ResolveImageUUID(vmUUID, imagePath string) (string, error) {
+	state, err := LoadVMState(vmUUID)
+	if err != nil || state == nil {
+		return "", err
+	}
+	return state.ImageUUID, nil
+}
+
+// ImageRegistry identifies images from a YAML file listing the encrypted images of the host by path, e.g.
+//
+//	images:
+//	- uuid: 31ab5921-24fd-498c-8c9e-b20f61004fc0
+//	  path: /var/lib/libvirt/images/rhel8.img
+type ImageRegistry struct {
+	Path string
+}
+
+type imageRegistryFile struct {
+	Images []struct {
+		UUID string `yaml:"uuid"`
+		Path string `yaml:"path"`
+	} `yaml:"images"`
+}
+
+// ResolveImageUUID returns the UUID of the image registered with imagePath. A host without a registry knows no image.
+func (r ImageRegistry) ResolveImageUUID(vmUUID, imagePath string) (string, error) {
+	if imagePath == "" {
+		return "", nil
+	}
+	registry, err := r.load()
+	if err != nil {
+		return "", err
+	}
+	for _, image := range registry.Images {
+		if filepath.Clean(image.Path) != filepath.Clean(imagePath) {
+			continue
+		}
+		if !uuidRegex.MatchString(image.UUID) {
+			return "", errors.Errorf("wlavm/image_identity:ResolveImageUUID() Invalid UUID %s for image %s in the image "+
+				"registry", image.UUID, image.Path)
+		}
+		return image.UUID, nil
+	}
+	return "", nil
+}
+
+// ImagePath returns the path registered for the image, or "" if the image is not registered
+func (r ImageRegistry) ImagePath(imageUUID string) (string, error) {
+	registry, err := r.load()
+	if err != nil {
+		return "", err
+	}
+	for _, image := range registry.Images {
+		if strings.EqualFold(image.UUID, imageUUID) {
+			return image.Path, nil
+		}
+	}
+	return "", nil
+}
+
+// load reads the registry, a registry that does not exist is empty
+func (r ImageRegistry) load() (imageRegistryFile, error) {
+	var registry imageRegistryFile
+	content, err := ioutil.ReadFile(r.Path)
+	if os.IsNotExist(err) {
+		return registry, nil
+	}
+	if err != nil {
+		return registry, errors.Wrapf(err, "wlavm/image_identity:load() Error reading the image registry %s", r.Path)
+	}
+	if err = yaml.Unmarshal(content, &registry); err != nil {
+		return registry, errors.Wrapf(err, "wlavm/image_identity:load() Error parsing the image registry %s", r.Path)
+	}
+	return registry, nil
+}
+
+// diskSizeFromVirtualSize returns the size in GB of the volume holding a disk, from the virtual size of the disk file,
+// for the VMs whose domain XML does not give the size of the flavor
+func diskSizeFromVirtualSize(ctx context.Context, diskPath string) (int, error) {
+	info, err := qemuImgInfo(ctx, diskPath)
+	if err != nil {
+		return 0, err
+	}
+	if info.VirtualSize <= 0 {
+		return 0, errors.Errorf("wlavm/image_identity:diskSizeFromVirtualSize() No virtual size for disk %s", diskPath)
+	}
+	return volumeSizeForDisk(info.VirtualSize), nil
+}
+
+// volumeSizeForDisk returns the size in GB of a volume that holds a disk of diskSize bytes, with one more GB for the
+// file system of the volume and the metadata of the qcow2 disk, which grow the disk file past its virtual size
+func volumeSizeForDisk(diskSize int64) int {
+	return int(math.Ceil(float64(diskSize)/(1<<30))) + 1
+}
+
+// parseVirtualSize returns the size in bytes from the virtual size field of qemu-img info, e.g. "1 GiB (1073741824 bytes)"
+func parseVirtualSize(field string) int64 {
+	start := strings.Index(field, "(")
+	if start < 0 {
+		return 0
+	}
+	fields := strings.Fields(field[start+1:])
+	if len(fields) == 0 {
+		return 0
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
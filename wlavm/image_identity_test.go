@@ -0,0 +1,77 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"intel/isecl/wlagent/v4/consts"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-registry")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	registry := ImageRegistry{Path: filepath.Join(dir, "image-registry.yml")}
+	imageUUID, err := registry.ResolveImageUUID(testVMUUID, "/var/lib/libvirt/images/rhel8.img")
+	assert.NoError(t, err)
+	assert.Empty(t, imageUUID)
+
+	content := "images:\n" +
+		"- uuid: " + testImageUUID + "\n" +
+		"  path: /var/lib/libvirt/images/rhel8.img\n" +
+		"- uuid: not-a-uuid\n" +
+		"  path: /var/lib/libvirt/images/other.img\n"
+	assert.NoError(t, ioutil.WriteFile(registry.Path, []byte(content), 0600))
+
+	imageUUID, err = registry.ResolveImageUUID(testVMUUID, "/var/lib/libvirt/images//rhel8.img")
+	assert.NoError(t, err)
+	assert.Equal(t, testImageUUID, imageUUID)
+	_, err = registry.ResolveImageUUID(testVMUUID, "/var/lib/libvirt/images/other.img")
+	assert.Error(t, err)
+	imageUUID, err = registry.ResolveImageUUID(testVMUUID, "/var/lib/libvirt/images/unknown.img")
+	assert.NoError(t, err)
+	assert.Empty(t, imageUUID)
+
+	// the encrypted image of a VM disk on a block device is looked up by image UUID
+	imagePath, err := registry.ImagePath(strings.ToUpper(testImageUUID))
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/lib/libvirt/images/rhel8.img", imagePath)
+	imagePath, err = registry.ImagePath(testVMUUID)
+	assert.NoError(t, err)
+	assert.Empty(t, imagePath)
+}
+
+func TestResolveImageUUID(t *testing.T) {
+	defer setupTestVMStateDir(t)()
+
+	assert.Equal(t, testImageUUID, resolveImageUUID(testVMUUID, consts.MountPath+testImageUUID+"/"+testImageUUID))
+	assert.Empty(t, resolveImageUUID(testVMUUID, "/var/lib/libvirt/images/unknown.img"))
+
+	updateVMState(testVMUUID, true, func(state *VMState) {
+		state.ImageUUID = testImageUUID
+	})
+	assert.Equal(t, testImageUUID, resolveImageUUID(testVMUUID, ""))
+}
+
+func TestParseVirtualSize(t *testing.T) {
+	assert.Equal(t, int64(21474836480), parseVirtualSize("20 GiB (21474836480 bytes)"))
+	assert.Equal(t, int64(1073741824), parseVirtualSize("1.0G (1073741824 bytes)"))
+	assert.Equal(t, int64(0), parseVirtualSize("20 GiB"))
+
+	// the volume is given one GB more than the disk
+	assert.Equal(t, 21, volumeSizeForDisk(21474836480))
+	assert.Equal(t, 22, volumeSizeForDisk(21474836481))
+	assert.Equal(t, 2, volumeSizeForDisk(1))
+}
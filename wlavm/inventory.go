@@ -0,0 +1,203 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/util"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// VMInventory describes a VM managed by the agent, from its state record and the volumes open on the host
+type VMInventory struct {
+	VMUUID    string `json:"vm_uuid"`
+	ImageUUID string `json:"image_uuid"`
+	Phase     Phase  `json:"phase"`
+	Error     string `json:"error,omitempty"`
+	// Device is the dm-crypt device of the VM disk, empty when the volume is not open
+	Device              string `json:"device"`
+	MountPoint          string `json:"mount_point"`
+	SparseFile          string `json:"sparse_file"`
+	SparseFileSize      int64  `json:"sparse_file_size"`
+	SparseFileAllocated int64  `json:"sparse_file_allocated"`
+	TrustReportPosted   bool   `json:"trust_report_posted"`
+	KeyID               string `json:"key_id,omitempty"`
+	PreparedAt          string `json:"prepared_at,omitempty"`
+	StartedAt           string `json:"started_at,omitempty"`
+	StoppedAt           string `json:"stopped_at,omitempty"`
+}
+
+// ImageInventory describes a decrypted image managed by the agent
+type ImageInventory struct {
+	ImageUUID string `json:"image_uuid"`
+	ImagePath string `json:"image_path"`
+	// Device is the dm-crypt device of the decrypted image, empty when the volume is not open
+	Device              string `json:"device"`
+	MountPoint          string `json:"mount_point"`
+	SparseFile          string `json:"sparse_file"`
+	SparseFileSize      int64  `json:"sparse_file_size"`
+	SparseFileAllocated int64  `json:"sparse_file_allocated"`
+	// VMCount is the number of running VMs using the image, from the image vm association
+	VMCount int    `json:"vm_count"`
+	KeyID   string `json:"key_id,omitempty"`
+}
+
+// Inventory lists the VMs and the images managed by the agent
+type Inventory struct {
+	VMs    []VMInventory    `json:"vms"`
+	Images []ImageInventory `json:"images"`
+}
+
+// GetInventory returns the VMs and the images managed by the agent, sorted by UUID. It combines the VM state records,
+// the image vm association and the dm-crypt volumes open on the host, so that the VMs prepared without a state
+// record and the volumes left open by a VM that was deleted are listed too.
+func GetInventory() (Inventory, error) {
+	log.Trace("wlavm/inventory:GetInventory() Entering")
+	defer log.Trace("wlavm/inventory:GetInventory() Leaving")
+
+	volumes, err := ListVolumes()
+	if err != nil {
+		return Inventory{}, err
+	}
+	states, err := ListVMStates()
+	if err != nil {
+		return Inventory{}, err
+	}
+
+	util.MapMtx.RLock()
+	associations := make(map[string]util.ImageVMAssociation, len(util.ImageVMAssociations))
+	for imageUUID, association := range util.ImageVMAssociations {
+		associations[imageUUID] = *association
+	}
+	util.MapMtx.RUnlock()
+	return buildInventory(volumes, states, associations), nil
+}
+
+// buildInventory lists a VM for each state record and each VM volume without a record, and an image for each image
+// referred to by a record, an image volume or the image vm association
+func buildInventory(volumes []Volume, states []VMState, associations map[string]util.ImageVMAssociation) Inventory {
+	var inventory Inventory
+	openVolumes := make(map[string]Volume, len(volumes))
+	for _, volume := range volumes {
+		openVolumes[volume.UUID] = volume
+	}
+	images := make(map[string]*ImageInventory)
+	image := func(imageUUID string) *ImageInventory {
+		if _, ok := images[imageUUID]; !ok {
+			images[imageUUID] = &ImageInventory{ImageUUID: imageUUID}
+		}
+		return images[imageUUID]
+	}
+
+	listedVMs := make(map[string]bool, len(states))
+	for _, state := range states {
+		vm := VMInventory{
+			VMUUID:     state.VMUUID,
+			ImageUUID:  state.ImageUUID,
+			Phase:      state.Phase,
+			Error:      state.Error,
+			KeyID:      state.KeyID,
+			PreparedAt: formatTime(state.PreparedAt),
+			StartedAt:  formatTime(state.StartedAt),
+			StoppedAt:  formatTime(state.StoppedAt),
+		}
+		vm.TrustReportPosted = !state.ReportPostedAt.IsZero()
+		if state.VMVolume != nil {
+			vm.SparseFile = state.VMVolume.SparseFilePath
+		}
+		if volume, ok := openVolumes[state.VMUUID]; ok {
+			vm.Device = consts.DevMapperDirPath + volume.UUID
+			vm.MountPoint = volume.MountPoint
+			vm.SparseFile = volume.BackingFile
+		}
+		vm.SparseFileSize, vm.SparseFileAllocated = fileSizes(vm.SparseFile)
+		inventory.VMs = append(inventory.VMs, vm)
+		listedVMs[state.VMUUID] = true
+
+		if state.ImageUUID != "" && (state.ImageVolume != nil || state.KeyID != "") {
+			img := image(state.ImageUUID)
+			if state.ImagePath != "" {
+				img.ImagePath = state.ImagePath
+			}
+			if state.KeyID != "" {
+				img.KeyID = state.KeyID
+			}
+			if state.ImageVolume != nil && img.SparseFile == "" {
+				img.SparseFile = state.ImageVolume.SparseFilePath
+			}
+		}
+	}
+
+	for _, volume := range volumes {
+		if volume.Kind == VolumeKindImage {
+			img := image(volume.UUID)
+			img.Device = consts.DevMapperDirPath + volume.UUID
+			img.MountPoint = volume.MountPoint
+			img.SparseFile = volume.BackingFile
+			if img.ImagePath == "" {
+				img.ImagePath = strings.TrimSuffix(volume.BackingFile, imageSparseFileSuffix)
+			}
+		} else if !listedVMs[volume.UUID] {
+			vm := VMInventory{
+				VMUUID:     volume.UUID,
+				Device:     consts.DevMapperDirPath + volume.UUID,
+				MountPoint: volume.MountPoint,
+				SparseFile: volume.BackingFile,
+			}
+			vm.SparseFileSize, vm.SparseFileAllocated = fileSizes(vm.SparseFile)
+			inventory.VMs = append(inventory.VMs, vm)
+		}
+	}
+
+	for imageUUID, association := range associations {
+		img := image(imageUUID)
+		img.VMCount = association.VMCount
+		if img.ImagePath == "" {
+			img.ImagePath = association.ImagePath
+		}
+	}
+
+	for _, img := range images {
+		img.SparseFileSize, img.SparseFileAllocated = fileSizes(img.SparseFile)
+		inventory.Images = append(inventory.Images, *img)
+	}
+	sort.Slice(inventory.VMs, func(i, j int) bool {
+		return inventory.VMs[i].VMUUID < inventory.VMs[j].VMUUID
+	})
+	sort.Slice(inventory.Images, func(i, j int) bool {
+		return inventory.Images[i].ImageUUID < inventory.Images[j].ImageUUID
+	})
+	return inventory
+}
+
+// fileSizes returns the apparent size of a sparse file and the space allocated to it, in bytes
+func fileSizes(path string) (int64, int64) {
+	if path == "" {
+		return 0, 0
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		// st_blocks is counted in 512-byte units whatever the block size of the file system
+		return info.Size(), stat.Blocks * 512
+	}
+	return info.Size(), 0
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
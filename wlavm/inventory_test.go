@@ -0,0 +1,78 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import (
+	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	imagePath := filepath.Join(dir, "_base", testImageUUID)
+	vmSparseFile := filepath.Join(dir, testVMUUID, testVMUUID+"_sparse")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(vmSparseFile), 0700))
+	assert.NoError(t, ioutil.WriteFile(vmSparseFile, make([]byte, 4096), 0600))
+	assert.NoError(t, os.Truncate(vmSparseFile, 1<<20))
+
+	volumes := []Volume{
+		newVolume(testVMUUID, vmSparseFile, consts.MountPath+testVMUUID),
+		newVolume(testImageUUID, imagePath+imageSparseFileSuffix, consts.MountPath+testImageUUID),
+		// the volume of a VM prepared without a state record
+		newVolume(testOtherVMUUID, filepath.Join(dir, testOtherVMUUID+"_sparse"), ""),
+	}
+	states := []VMState{{
+		VMUUID:         testVMUUID,
+		ImageUUID:      testImageUUID,
+		ImagePath:      imagePath,
+		Encrypted:      true,
+		KeyID:          "8f2b7a4e-3b0a-4f7e-9d0c-2a1b3c4d5e6f",
+		VMVolume:       vmVolumeState(testVMUUID, filepath.Join(dir, testVMUUID, "disk")),
+		ImageVolume:    imageVolumeState(testImageUUID, imagePath),
+		Phase:          PhaseStart,
+		ReportPostedAt: time.Now(),
+	}}
+	associations := map[string]util.ImageVMAssociation{
+		testImageUUID: {ImagePath: consts.MountPath + testImageUUID + "/" + testImageUUID, VMCount: 1},
+	}
+
+	inventory := buildInventory(volumes, states, associations)
+	if assert.Len(t, inventory.VMs, 2) {
+		other, vm := inventory.VMs[0], inventory.VMs[1]
+		assert.Equal(t, testOtherVMUUID, other.VMUUID)
+		assert.Equal(t, consts.DevMapperDirPath+testOtherVMUUID, other.Device)
+		assert.Empty(t, other.Phase)
+
+		assert.Equal(t, testVMUUID, vm.VMUUID)
+		assert.Equal(t, PhaseStart, vm.Phase)
+		assert.Equal(t, consts.DevMapperDirPath+testVMUUID, vm.Device)
+		assert.Equal(t, consts.MountPath+testVMUUID, vm.MountPoint)
+		assert.Equal(t, vmSparseFile, vm.SparseFile)
+		assert.Equal(t, int64(1<<20), vm.SparseFileSize)
+		assert.True(t, vm.SparseFileAllocated < vm.SparseFileSize)
+		assert.True(t, vm.TrustReportPosted)
+		assert.Empty(t, vm.StartedAt)
+	}
+	if assert.Len(t, inventory.Images, 1) {
+		image := inventory.Images[0]
+		assert.Equal(t, testImageUUID, image.ImageUUID)
+		assert.Equal(t, imagePath, image.ImagePath)
+		assert.Equal(t, consts.DevMapperDirPath+testImageUUID, image.Device)
+		assert.Equal(t, 1, image.VMCount)
+		assert.Equal(t, "8f2b7a4e-3b0a-4f7e-9d0c-2a1b3c4d5e6f", image.KeyID)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import (
+	"sync"
+)
+
+var (
+	// vmLocks serializes the operations on a VM and imageLocks the work on an image volume, the VM lock is taken first
+	vmLocks    = newKeyedMutex()
+	imageLocks = newKeyedMutex()
+	// lifecycleMtx is shared by the VM operations and held exclusively by Reconcile
+	lifecycleMtx sync.RWMutex
+)
+
+// keyedMutex provides one mutex per key. The mutex of a key only exists while it is held or waited for.
+type keyedMutex struct {
+	mtx   sync.Mutex
+	locks map[string]*refCountedMutex
+}
+
+type refCountedMutex struct {
+	sync.Mutex
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*refCountedMutex)}
+}
+
+// Lock locks the mutex of key and returns the function that unlocks it. Calling the returned function
+// more than once has no effect, so it can be deferred and still be called early.
+func (k *keyedMutex) Lock(key string) func() {
+	k.mtx.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &refCountedMutex{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mtx.Unlock()
+
+	l.Lock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.Unlock()
+			k.mtx.Lock()
+			l.refs--
+			if l.refs == 0 {
+				delete(k.locks, key)
+			}
+			k.mtx.Unlock()
+		})
+	}
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyedMutexSerializesSameKey(t *testing.T) {
+	k := newKeyedMutex()
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	inside, maxInside := 0, 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := k.Lock("image")
+			defer unlock()
+			mtx.Lock()
+			inside++
+			if inside > maxInside {
+				maxInside = inside
+			}
+			mtx.Unlock()
+			time.Sleep(time.Millisecond)
+			mtx.Lock()
+			inside--
+			mtx.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, maxInside)
+	assert.Empty(t, k.locks)
+}
+
+func TestKeyedMutexIndependentKeys(t *testing.T) {
+	k := newKeyedMutex()
+	unlockFirst := k.Lock("first")
+	defer unlockFirst()
+
+	done := make(chan struct{})
+	go func() {
+		unlock := k.Lock("second")
+		unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock of an independent key was blocked")
+	}
+}
@@ -8,46 +8,48 @@
 package wlavm
 
 import (
+	"context"
 	"fmt"
 	wlsModel "github.com/intel-secl/intel-secl/v4/pkg/model/wls"
 	"intel/isecl/lib/common/v4/crypt"
-	"intel/isecl/lib/common/v4/exec"
 	"intel/isecl/lib/common/v4/log/message"
 	osutil "intel/isecl/lib/common/v4/os"
 	pinfo "intel/isecl/lib/platform-info/v4/platforminfo"
 	"intel/isecl/lib/vml/v4"
-	wlsclient "intel/isecl/wlagent/v4/clients"
 	"intel/isecl/wlagent/v4/consts"
 	"intel/isecl/wlagent/v4/filewatch"
+	"intel/isecl/wlagent/v4/flavor"
 	"intel/isecl/wlagent/v4/libvirt"
 	"intel/isecl/wlagent/v4/util"
-	"io/ioutil"
 	"os"
 	"os/user"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/pkg/errors"
 )
 
 var (
-	imgVolumeMtx sync.Mutex
-	vmVolumeMtx  sync.Mutex
-	TpmMtx       sync.Mutex
+	// creating a volume attaches its sparse file to a free loop device, the lookup of the free
+	// loop device and the attach are not atomic so volumes are created one at a time
+	loopDeviceMtx sync.Mutex
 )
 
 // Prepare method is used perform the VM confidentiality check before launching the VM
 // Input Parameters: domainXML content string
-// Return : Returns a boolean value to the main method.
-// true if the vm is launched successfully, else returns false.
-func Prepare(domainXMLContent string, filewatcher *filewatch.Watcher) bool {
+// Return : Returns nil if the vm is prepared successfully, else returns a *VMError
+// describing the reason the VM launch was refused. The child processes are killed when ctx is done.
+// A failed prepare undoes the steps it completed for the VM, so that the VM can be launched again.
+func Prepare(ctx context.Context, domainXMLContent string, filewatcher *filewatch.Watcher) (err error) {
 
 	log.Trace("wlavm/prepare:Prepare() Entering")
 	defer log.Trace("wlavm/prepare:Prepare() Leaving")
-	var skipImageVolumeCreation = false
-	var err error
+	defer func() {
+		err = contextError(ctx, PhasePrepare, err)
+	}()
 	var isImageEncrypted bool
 
 	log.Info("wlavm/prepare:Prepare() Parsing domain XML to get image UUID, image path, VM UUID, VM path and disk size")
@@ -55,7 +57,12 @@ func Prepare(domainXMLContent string, filewatcher *filewatch.Watcher) bool {
 	if err != nil {
 		log.Error("wlavm/prepare:Prepare() Parsing error: ", err.Error())
 		log.Tracef("%+v", err)
-		return false
+		return newVMError(PhasePrepare, InvalidDomainXML, err, "error parsing domain XML")
+	}
+	// the VM path and the image path are those of the disk backed by the image, wherever it is in the domain XML
+	disk, isImageBacked := imageBackedDisk(ctx, d.GetVMUUID(), d.GetDisks())
+	if isImageBacked {
+		d.UseDisk(disk)
 	}
 
 	vmUUID := d.GetVMUUID()
@@ -63,14 +70,59 @@ func Prepare(domainXMLContent string, filewatcher *filewatch.Watcher) bool {
 	imageUUID := d.GetImageUUID()
 	imagePath := d.GetImagePath()
 	size := d.GetDiskSize()
+
+	lifecycleMtx.RLock()
+	defer lifecycleMtx.RUnlock()
+	unlockVM := vmLocks.Lock(vmUUID)
+	defer unlockVM()
+
+	var isVMLaunchfromEncryptedImage bool
+
+	// the steps completed for the VM are undone in reverse order when prepare fails, while the VM is still locked
+	rb := &rollback{}
+	defer func() {
+		if err != nil {
+			if rbErr := rb.run(); rbErr != nil {
+				log.WithError(rbErr).Errorf("wlavm/prepare:Prepare() VM %s was not fully rolled back", vmUUID)
+			}
+			updateVMState(vmUUID, isVMLaunchfromEncryptedImage || isImageEncrypted, func(state *VMState) {
+				state.ImageUUID = imageUUID
+				state.VMPath = vmPath
+				state.Phase = PhasePrepare
+				state.Error = vmErrorMessage(contextError(ctx, PhasePrepare, err))
+			})
+			return
+		}
+		rb.commit()
+	}()
+
+	// the volumes created for the VM are in use from now on, even though the VM is not running yet
+	markPending(vmUUID, imageUUID)
+	rb.add("clear the pending entry of VM "+vmUUID, func() error {
+		clearPending(vmUUID)
+		return nil
+	})
+
+	// the VM disks on block devices are encrypted in place, the other block devices are not managed by the agent
+	if isImageBacked && disk.Type == libvirt.DiskTypeBlock {
+		isVMLaunchfromEncryptedImage = true
+		return prepareBlockDisk(ctx, rb, vmUUID, imageUUID, vmPath, disk.BackingFile(), size)
+	}
+	if !isImageBacked && isBlockDiskPath(d.GetDisks(), vmPath) {
+		if err = checkUnmanagedBlockDisk(ctx, vmUUID, vmPath); err != nil {
+			return err
+		}
+		log.Infof("wlavm/prepare:Prepare() VM %s has no disk backed by an encrypted image, returning to the hook", vmUUID)
+		return nil
+	}
+
 	var vmVirtualSize string
 	var vmVirtualFormat string
 	var vmBackFileFormat string
 	var key []byte
-	isImageDecrypted := false
+	var keyID string
 	mustRecreateVMDisk := false
 	decryptedImagePath := ""
-	var isVMLaunchfromEncryptedImage bool
 
 	// Step 1 - Check if the VM is in shutoff state - detect the symlink from VM Disk to Volume
 	// if not, this is a fresh launch
@@ -91,35 +143,63 @@ func Prepare(domainXMLContent string, filewatcher *filewatch.Watcher) bool {
 	if vmSymlinkReadErr != nil {
 		mustRecreateVMDisk = true
 		// discover backing file path via qemu-img info on VM disk file
-		getBackingFileOutput, err := exec.ExecuteCommand(consts.QemuImgUtilPath,
-			strings.Fields(fmt.Sprintf(consts.GetImgInfoCmd, vmPath)))
+		vmDiskInfo, err := qemuImgInfo(ctx, vmPath)
 		if err != nil {
 			log.Errorf("wlavm/prepare:Prepare() Error discovering backing file path: %s", err.Error())
-			return false
+			return newVMError(PhasePrepare, QemuImgFailed, err, "error discovering backing file path of VM disk")
 		}
 
 		// set the image path and continue with prepare stage
-		for _, line := range strings.Split(getBackingFileOutput, "\n") {
-			lineSplit := strings.Split(strings.TrimSpace(line), ": ")
-			if len(lineSplit) > 1 {
-				switch lineSplit[0] {
-				case consts.QemuImgInfoBackingFileField:
-					imagePath = lineSplit[1]
-					log.Debugf("wlavm/prepare:Prepare() Backing file path for VM : %s", imagePath)
-				}
+		if vmDiskInfo.BackingFile != "" {
+			imagePath = vmDiskInfo.BackingFile
+			log.Debugf("wlavm/prepare:Prepare() Backing file path for VM : %s", imagePath)
+		}
+	}
+
+	// a VM disk without a backing file is a standalone copy of the image, the VM is protected if the copy is encrypted
+	if imagePath == "" {
+		isStandaloneDisk, err := isStandaloneEncryptedDisk(vmUUID, vmPath)
+		if err != nil {
+			log.WithError(err).Errorf("wlavm/prepare:Prepare() Error checking if the disk of VM %s is encrypted", vmUUID)
+			return newVMError(PhasePrepare, InternalError, err, "error checking if the VM disk is encrypted")
+		}
+		if isStandaloneDisk {
+			isVMLaunchfromEncryptedImage = true
+			if imageUUID == "" {
+				imageUUID = resolveImageUUID(vmUUID, vmPath)
+				markPending(vmUUID, imageUUID)
 			}
+			return prepareStandaloneVM(ctx, rb, vmUUID, imageUUID, vmPath, size, filewatcher, false)
+		}
+		if vmSymlinkReadErr != nil {
+			log.Infof("wlavm/prepare:Prepare() The disk of VM %s has no backing file and is not encrypted, returning to the hook", vmUUID)
+			return nil
 		}
 	}
 
 	// in case of reboot from VM shutoff no image path is available from the domain xml
-	// fetch from the association file
+	// fetch from the state of the VM, or from the association file for the VMs prepared without a state record
 	if imagePath == "" {
+		imagePath = imagePathFromVMState(vmUUID)
+	}
+	// the domains not defined by Nova may not name their image in the domain XML
+	if imageUUID == "" {
+		imageUUID = resolveImageUUID(vmUUID, imagePath)
+		markPending(vmUUID, imageUUID)
+	}
+	if imagePath == "" && imageUUID != "" {
 		imagePath = imagePathFromVMAssociationFile(imageUUID)
-		if imagePath == "" {
-			log.Errorf("wlavm/prepare:Prepare() Error while retrieving image path from image-vm association "+
-				"file for image %s", imageUUID)
-			return false
-		}
+	}
+	// the VM volume of a VM prepared on a standalone disk without a state record is found next to the VM disk
+	if imagePath == "" && hasStandaloneVolume(vmUUID, vmPath) {
+		log.Infof("wlavm/prepare:Prepare() No image path for VM %s, preparing its volume as a standalone disk", vmUUID)
+		isVMLaunchfromEncryptedImage = true
+		return prepareStandaloneVM(ctx, rb, vmUUID, imageUUID, vmPath, size, filewatcher, true)
+	}
+	if imagePath == "" {
+		log.Errorf("wlavm/prepare:Prepare() Error while retrieving image path from image-vm association "+
+			"file for image %s", imageUUID)
+		return newVMError(PhasePrepare, AssociationFailed, nil, "image path for image "+imageUUID+" not found in image-vm association")
 	}
 
 	// Step 2 - check if the image is in the crypto path - if yes this is from an encrypted image
@@ -132,94 +212,39 @@ func Prepare(domainXMLContent string, filewatcher *filewatch.Watcher) bool {
 	if err != nil {
 		log.Errorf("wlavm/prepare:Prepare() Error while trying to check if the image is encrypted: %s", err.Error())
 		log.Tracef("%+v", err)
-		return false
+		return newVMError(PhasePrepare, InternalError, err, "error checking if the image is encrypted")
+	}
+	if isImageEncrypted && imageUUID == "" {
+		log.Errorf("wlavm/prepare:Prepare() No image UUID for the encrypted image %s of VM %s", imagePath, vmUUID)
+		return newVMError(PhasePrepare, ImageUnidentified, nil, "the domain XML does not name the encrypted image "+
+			imagePath+" and it is not in the image registry")
 	}
 
-	if isImageEncrypted {
-		log.Info("wlavm/prepare:Prepare() Checking if the image file has already been decrypted")
-		decryptedImagePath = consts.MountPath + imageUUID + "/" + imageUUID
-		imageFileStat, imageFileStatErr := os.Stat(decryptedImagePath)
-		if imageFileStatErr == nil && imageFileStat.Size() > 0 {
-			isImageDecrypted, err = crypt.EncryptionHeaderExists(imagePath)
-			if err == nil && !isImageDecrypted {
-				log.Info("wlavm/prepare:Prepare() The image is already decrypted, " +
-					"so will be skipping the image dm-crypt volume creation")
-				skipImageVolumeCreation = true
-			}
-		}
-
-		var flavorKeyInfo wlsModel.FlavorKey
-		var tpmWrappedKey []byte
-
-		// get host hardware UUID
-		secLog.Infof("wlavm/prepare:Prepare() %s, Trying to get host hardware UUID", message.SU)
-		hardwareUUID, err := pinfo.HardwareUUID()
+	// only Nova gives the disk size of the flavor, the volumes of the other VMs are sized after the VM disk
+	if size == 0 && (isVMLaunchfromEncryptedImage || isImageEncrypted) {
+		size, err = diskSizeFromVirtualSize(ctx, vmPath)
 		if err != nil {
-			log.WithError(err).Error("wlavm/prepare:Prepare() Unable to get the host hardware UUID")
-			return false
+			log.WithError(err).Errorf("wlavm/prepare:Prepare() Error reading the virtual size of VM disk %s", vmPath)
+			return newVMError(PhasePrepare, QemuImgFailed, err, "error reading the virtual size of the VM disk")
 		}
-		log.Debugf("wlavm/prepare:Prepare() The host hardware UUID is :%s", hardwareUUID)
-
-		//get flavor-key from workload service
-		// we will be hitting the WLS to retrieve the the flavor and key.
-		log.Infof("wlavm/prepare:Prepare() Retrieving image-flavor-key for image %s from WLS", imageUUID)
+		log.Debugf("wlavm/prepare:Prepare() Disk size of VM %s from its virtual size: %d GB", vmUUID, size)
+	}
 
-		flavorKeyInfo, err = wlsclient.GetImageFlavorKey(imageUUID, hardwareUUID)
+	if isImageEncrypted {
+		decryptedImagePath = consts.MountPath + imageUUID + "/" + imageUUID
+		// VMs sharing the image wait for the first of them to fetch the key and decrypt the image,
+		// and then share its result
+		image, err := prepareImageOnce(ctx, imageUUID, func() (preparedImage, error) {
+			unlockImage := imageLocks.Lock(imageUUID)
+			defer unlockImage()
+			return prepareImage(ctx, imageUUID, imagePath, size)
+		})
 		if err != nil {
-			secLog.WithError(err).Error("wlavm/prepare:Prepare() Error retrieving the image flavor and key")
-			return false
-		}
-
-		if flavorKeyInfo.Flavor.Meta.ID == "" {
-			log.Infof("wlavm/prepare:Prepare() Flavor does not exist for the image %s", imageUUID)
-			return false
-		}
-
-		if flavorKeyInfo.Flavor.EncryptionRequired {
-			if len(flavorKeyInfo.Key) == 0 {
-				log.Error("wlavm/prepare:Prepare() Flavor Key is empty")
-				return false
-			}
-			tpmWrappedKey = flavorKeyInfo.Key
-			// unwrap key
-			log.Info("wlavm/prepare:Prepare() Unwrapping the key...")
-			TpmMtx.Lock()
-			key, unWrapErr := util.UnwrapKey(tpmWrappedKey)
-			TpmMtx.Unlock()
-			if unWrapErr != nil {
-				secLog.WithError(err).Error("wlavm/prepare:Prepare() Error unwrapping the key")
-				return false
-			}
-
-			// decrypt and mount the VM image
-			if !skipImageVolumeCreation {
-				log.Info("wlavm/prepare:Prepare() Creating and mounting image dm-crypt volume")
-				err = imageVolumeManager(imageUUID, imagePath, size, key)
-				if err != nil {
-					log.WithError(err).Error("wlavm/prepare:Prepare() Error while creating and mounting image dm-crypt volume ")
-					return false
-				}
-
-				// discover via qemu-img info on decrypted image file
-				qemuImgInfoOutput, err := exec.ExecuteCommand(consts.QemuImgUtilPath, strings.Fields(fmt.Sprintf(consts.GetImgInfoCmd, decryptedImagePath)))
-				if err != nil {
-					log.Errorf("wlavm/prepare:Prepare() Error discovering backing file path: %s", err.Error())
-					return false
-				}
-
-				// set the image path and continue with prepare stage
-				for _, line := range strings.Split(qemuImgInfoOutput, "\n") {
-					lineSplit := strings.Split(strings.TrimSpace(line), ": ")
-					if len(lineSplit) > 1 {
-						switch lineSplit[0] {
-						case consts.QemuImgInfoFileFormatField:
-							vmBackFileFormat = lineSplit[1]
-							log.Debugf("wlavm/prepare:Prepare() Backing File format: %s", vmBackFileFormat)
-						}
-					}
-				}
-			}
+			return err
 		}
+		key = image.key
+		keyID = image.keyID
+		vmBackFileFormat = image.backingFormat
 	}
 
 	if isVMLaunchfromEncryptedImage || isImageEncrypted {
@@ -232,11 +257,11 @@ func Prepare(domainXMLContent string, filewatcher *filewatch.Watcher) bool {
 		if vmSymlinkReadErr != nil || vmSymLinkStatErr != nil || vmSymLinkStat.Size() == 0 {
 			if mustRecreateVMDisk {
 				// since we need to recreate the VM disk file - discover info via qemu-img
-				queryVmDisk, err := exec.ExecuteCommand(consts.QemuImgUtilPath,
+				queryVmDisk, err := executeCommand(ctx, consts.QemuImgUtilPath,
 					strings.Fields(fmt.Sprintf(consts.GetImgInfoCmd, vmPath)))
 				if err != nil {
 					log.Errorf("wlavm/prepare:Prepare() Error discovering backing file path: %s", err.Error())
-					return false
+					return newVMError(PhasePrepare, QemuImgFailed, err, "error discovering properties of the VM disk")
 				}
 
 				// set the image properties
@@ -258,62 +283,238 @@ func Prepare(domainXMLContent string, filewatcher *filewatch.Watcher) bool {
 					}
 				}
 
-				recreateVMDiskOutput, err := exec.ExecuteCommand(consts.QemuImgUtilPath, strings.Fields(
+				// the original VM disk is kept aside until the VM is prepared
+				err = rb.preserveFile(vmPath)
+				if err != nil {
+					log.WithError(err).Error("wlavm/prepare:Prepare() Error preserving the VM disk file")
+					return newVMError(PhasePrepare, InternalError, err, "error preserving the VM disk file")
+				}
+
+				recreateVMDiskOutput, err := executeCommand(ctx, consts.QemuImgUtilPath, strings.Fields(
 					fmt.Sprintf(consts.CreateVmDiskCmd, vmVirtualFormat, decryptedImagePath, vmBackFileFormat, vmPath)))
 				if err != nil {
 					log.Errorf("wlavm/prepare:Prepare() Error recreating VM disk file: %s", err.Error())
-					return false
+					return newVMError(PhasePrepare, QemuImgFailed, err, "error recreating the VM disk file")
 				}
 				log.Debugf("wlavm/prepare:Prepare() Reformatting VM disk: %s", recreateVMDiskOutput)
 
 				// resize the disk file per the Nova flavor
-				resizeDiskFileOutput, err := exec.ExecuteCommand(consts.QemuImgUtilPath, strings.Fields(
+				resizeDiskFileOutput, err := executeCommand(ctx, consts.QemuImgUtilPath, strings.Fields(
 					fmt.Sprintf(consts.ResizeVmDiskCmd, vmPath, vmVirtualSize)))
 				if err != nil {
 					log.Errorf("wlavm/prepare:Prepare() Error resizing VM disk: %s", err.Error())
-					return false
+					return newVMError(PhasePrepare, QemuImgFailed, err, "error resizing the VM disk")
 				}
 				log.Debugf("wlavm/prepare:Prepare() Resizing VM disk output: %s", resizeDiskFileOutput)
 			}
 		}
 
 		log.Info("wlavm/prepare:Prepare() Creating and mounting vm dm-crypt volume")
-		err = vmVolumeManager(vmUUID, vmPath, size, key, filewatcher)
+		err = vmVolumeManager(ctx, rb, vmUUID, vmPath, size, key, filewatcher, false)
 		if err != nil {
 			log.WithError(err).Error("wlavm/prepare:Prepare() Error while creating and mounting vm dm-crypt volume ")
-			return false
+			if vmErr, ok := err.(*VMError); ok {
+				return vmErr
+			}
+			return newVMError(PhasePrepare, VolumeFailed, err, "error creating and mounting the VM dm-crypt volume")
 		}
 	}
 
+	if isVMLaunchfromEncryptedImage || isImageEncrypted {
+		encryptedImagePath := imagePath
+		updateVMState(vmUUID, true, func(state *VMState) {
+			state.ImageUUID = imageUUID
+			state.VMPath = vmPath
+			state.Encrypted = true
+			if isImageEncrypted {
+				state.ImagePath = encryptedImagePath
+				state.KeyID = keyID
+				state.ImageVolume = imageVolumeState(imageUUID, encryptedImagePath)
+			}
+			state.VMVolume = vmVolumeState(vmUUID, vmPath)
+			state.PerVMKey = hasVMKey(vmUUID)
+			state.Phase = PhasePrepare
+			state.Error = ""
+			state.PreparedAt = time.Now()
+		})
+	}
+
 	log.Infof("wlavm/prepare:Prepare() VM %s prepared", vmUUID)
-	return true
+	return nil
+}
+
+// imagePathFromVMState returns the path of the encrypted image recorded in the state of the VM, if it still exists
+func imagePathFromVMState(vmUUID string) string {
+	state, err := LoadVMState(vmUUID)
+	if err != nil {
+		log.WithError(err).Warnf("wlavm/prepare:imagePathFromVMState() Error loading the state of VM %s", vmUUID)
+		return ""
+	}
+	if state == nil || state.ImagePath == "" {
+		return ""
+	}
+	if _, err = os.Stat(state.ImagePath); err != nil {
+		log.WithError(err).Warnf("wlavm/prepare:imagePathFromVMState() Recorded image of VM %s is not usable", vmUUID)
+		return ""
+	}
+	return state.ImagePath
+}
+
+// prepareImage retrieves and unwraps the key of the encrypted image, and decrypts the image into its dm-crypt
+// volume unless this has already been done for another VM. The image volume created by a failed prepareImage is
+// closed again, the volume of an image prepared successfully is kept for the VMs sharing it even if their own
+// prepare fails.
+func prepareImage(ctx context.Context, imageUUID, imagePath string, size int) (image preparedImage, err error) {
+	log.Trace("wlavm/prepare:prepareImage() Entering")
+	defer log.Trace("wlavm/prepare:prepareImage() Leaving")
+
+	rb := &rollback{}
+	defer func() {
+		if err != nil {
+			if rbErr := rb.run(); rbErr != nil {
+				log.WithError(rbErr).Errorf("wlavm/prepare:prepareImage() Image %s was not fully rolled back", imageUUID)
+			}
+			return
+		}
+		rb.commit()
+	}()
+
+	skipImageVolumeCreation := false
+
+	log.Info("wlavm/prepare:prepareImage() Checking if the image file has already been decrypted")
+	decryptedImagePath := consts.MountPath + imageUUID + "/" + imageUUID
+	imageFileStat, imageFileStatErr := os.Stat(decryptedImagePath)
+	if imageFileStatErr == nil && imageFileStat.Size() > 0 {
+		isImageDecrypted, err := crypt.EncryptionHeaderExists(imagePath)
+		if err == nil && !isImageDecrypted {
+			log.Info("wlavm/prepare:prepareImage() The image is already decrypted, " +
+				"so will be skipping the image dm-crypt volume creation")
+			skipImageVolumeCreation = true
+		}
+	}
+
+	image, err = retrieveImageKey(ctx, imageUUID)
+	if err != nil {
+		return image, err
+	}
+
+	// decrypt and mount the VM image
+	if image.key != nil && !skipImageVolumeCreation {
+		log.Info("wlavm/prepare:prepareImage() Creating and mounting image dm-crypt volume")
+		err = imageVolumeManager(ctx, rb, imageUUID, imagePath, size, image.key)
+		if err != nil {
+			log.WithError(err).Error("wlavm/prepare:prepareImage() Error while creating and mounting image dm-crypt volume ")
+			if vmErr, ok := err.(*VMError); ok {
+				return image, vmErr
+			}
+			return image, newVMError(PhasePrepare, VolumeFailed, err, "error creating and mounting the image dm-crypt volume")
+		}
+
+		// discover via qemu-img info on decrypted image file
+		decryptedImageInfo, err := qemuImgInfo(ctx, decryptedImagePath)
+		if err != nil {
+			log.Errorf("wlavm/prepare:prepareImage() Error discovering backing file path: %s", err.Error())
+			return image, newVMError(PhasePrepare, QemuImgFailed, err, "error discovering format of the decrypted image")
+		}
+		image.backingFormat = decryptedImageInfo.Format
+		log.Debugf("wlavm/prepare:prepareImage() Backing File format: %s", image.backingFormat)
+	}
+	return image, nil
 }
 
-func vmVolumeManager(vmUUID string, vmPath string, size int, key []byte, filewatcher *filewatch.Watcher) error {
+// retrieveImageKey retrieves the flavor of the image and unwraps its key with the TPM. The key is nil when the flavor
+// does not require encryption.
+func retrieveImageKey(ctx context.Context, imageUUID string) (image preparedImage, err error) {
+	log.Trace("wlavm/prepare:retrieveImageKey() Entering")
+	defer log.Trace("wlavm/prepare:retrieveImageKey() Leaving")
+
+	var flavorKeyInfo wlsModel.FlavorKey
+	var tpmWrappedKey []byte
+
+	// get host hardware UUID
+	secLog.Infof("wlavm/prepare:retrieveImageKey() %s, Trying to get host hardware UUID", message.SU)
+	hardwareUUID, err := pinfo.HardwareUUID()
+	if err != nil {
+		log.WithError(err).Error("wlavm/prepare:retrieveImageKey() Unable to get the host hardware UUID")
+		return image, newVMError(PhasePrepare, HardwareUUIDFailed, err, "unable to get the host hardware UUID")
+	}
+	log.Debugf("wlavm/prepare:retrieveImageKey() The host hardware UUID is :%s", hardwareUUID)
+
+	//get flavor-key from the flavor cache or the workload service
+	log.Infof("wlavm/prepare:retrieveImageKey() Retrieving image-flavor-key for image %s", imageUUID)
+
+	flavorKeyInfo, err = flavor.GetImageFlavorKey(ctx, imageUUID, hardwareUUID)
+	if err != nil {
+		secLog.WithError(err).Error("wlavm/prepare:retrieveImageKey() Error retrieving the image flavor and key")
+		return image, newVMError(PhasePrepare, WlsUnreachable, err, "error retrieving the image flavor and key from WLS")
+	}
+
+	if flavorKeyInfo.Flavor.Meta.ID == "" {
+		log.Infof("wlavm/prepare:retrieveImageKey() Flavor does not exist for the image %s", imageUUID)
+		return image, newVMError(PhasePrepare, FlavorNotFound, nil, "flavor does not exist for the image "+imageUUID)
+	}
+
+	if !flavorKeyInfo.Flavor.EncryptionRequired {
+		return image, nil
+	}
+	if len(flavorKeyInfo.Key) == 0 {
+		log.Error("wlavm/prepare:retrieveImageKey() Flavor Key is empty")
+		return image, newVMError(PhasePrepare, KeyDenied, nil, "WLS did not release the key for image "+imageUUID+", host may be untrusted")
+	}
+	tpmWrappedKey = flavorKeyInfo.Key
+	image.keyID = flavor.KeyID(flavorKeyInfo.Flavor.Encryption.KeyURL)
+	// unwrap key
+	log.Info("wlavm/prepare:retrieveImageKey() Unwrapping the key...")
+	util.TpmMtx.Lock()
+	key, unWrapErr := util.UnwrapKey(ctx, tpmWrappedKey)
+	util.TpmMtx.Unlock()
+	if unWrapErr != nil {
+		secLog.WithError(unWrapErr).Error("wlavm/prepare:retrieveImageKey() Error unwrapping the key")
+		// the cached wrapped key can not be unbound by this TPM, make sure the next attempt goes to WLS
+		if purgeErr := flavor.PurgeCache(imageUUID); purgeErr != nil {
+			log.WithError(purgeErr).Error("wlavm/prepare:retrieveImageKey() Error purging the flavor cache")
+		}
+		return image, newVMError(PhasePrepare, TpmUnbindFailed, unWrapErr, "error unwrapping the image key with the TPM binding key")
+	}
+	image.key = key
+	return image, nil
+}
+
+// vmVolumeManager creates and mounts the dm-crypt volume of the VM and moves the VM disk into it. The disk is copied
+// as is, or decrypted with key when encryptedDisk is true, on the first launch of the VM only.
+func vmVolumeManager(ctx context.Context, rb *rollback, vmUUID string, vmPath string, size int, key []byte, filewatcher *filewatch.Watcher, encryptedDisk bool) error {
 	log.Trace("wlavm/prepare:vmVolumeManager() Entering")
 	defer log.Trace("wlavm/prepare:vmVolumeManager() Leaving")
 
 	// create vm volume
 	var err error
-	vmDeviceMapperPath := consts.DevMapperDirPath + vmUUID
-	vmSparseFilePath := strings.Replace(vmPath, "disk", vmUUID+"_sparse", -1)
+	volume := vmVolumeState(vmUUID, vmPath)
+	vmDeviceMapperPath := volume.DeviceMapperPath
+	vmSparseFilePath := volume.SparseFilePath
 	// check if sparse file exists, if it does, skip copying the change disk file to mount point
 	_, sparseFleStatErr := os.Stat(vmSparseFilePath)
-	vmVolumeMtx.Lock()
+	// the VM disk is decrypted with the image key, the volume may have a key of its own
+	volumeKey, err := vmVolumeKey(ctx, rb, vmUUID, key, os.IsNotExist(sparseFleStatErr))
+	if err != nil {
+		return err
+	}
+	loopDeviceMtx.Lock()
 	secLog.Infof("wlavm/prepare:vmVolumeManager() %s, Creating VM dm-crypt volume in %s", message.SU, vmDeviceMapperPath)
-	err = vml.CreateVolume(vmSparseFilePath, vmDeviceMapperPath, key, size)
-	vmVolumeMtx.Unlock()
+	err = vml.CreateVolume(vmSparseFilePath, vmDeviceMapperPath, volumeKey, size)
+	loopDeviceMtx.Unlock()
 	if err != nil {
 		return errors.Wrap(err, "wlavm/prepare:vmVolumeManager() error creating vm dm-crypt volume")
 	}
+	addCloseVolume(rb, vmDeviceMapperPath, vmSparseFilePath, os.IsNotExist(sparseFleStatErr))
 
 	// mount the vm dmcrypt volume on to a mount path
 	log.Debug("wlavm/prepare:vmVolumeManager() Mounting the vm volume on a mount path")
-	var vmMountPath = consts.MountPath + vmUUID
+	var vmMountPath = volume.MountPath
 	err = checkMountPathExistsAndMountVolume(vmMountPath, vmDeviceMapperPath, "disk")
 	if err != nil {
 		return errors.Wrap(err, "wlavm/prepare:vmVolumeManager() error checking if mount path exists and mounting the volume")
 	}
+	addUnmount(rb, vmMountPath)
 
 	// is sparse file exists, the image is already decrypted, so returning back to VM start method
 	if !os.IsNotExist(sparseFleStatErr) {
@@ -321,22 +522,32 @@ func vmVolumeManager(vmUUID string, vmPath string, size int, key []byte, filewat
 		return nil
 	}
 
-	// copy the files from vm path
-	args := []string{vmPath, vmMountPath}
-	secLog.Infof("wlavm/prepare:vmVolumeManager() %s, Copying all the files from %s to vm mount path", message.SU, vmPath)
-	_, err = exec.ExecuteCommand("cp", args)
-	if err != nil {
-		return errors.Wrapf(err, "wlavm/prepare:vmVolumeManager() error copying the vm path %s change disk to mount path. %s", vmPath, vmMountPath)
+	changeDiskFile := vmMountPath + "/disk"
+	if encryptedDisk {
+		// the VM disk is a copy of the encrypted image, it is decrypted as a stream into the VM volume
+		secLog.Infof("wlavm/prepare:vmVolumeManager() %s, Decrypting the VM disk %s to vm mount path", message.SU, vmPath)
+		err = decryptImageFile(ctx, vmPath, changeDiskFile, key, logDecryptProgress(vmUUID))
+		if err != nil {
+			return newVMError(PhasePrepare, DecryptFailed, err, "error while decrypting the VM disk")
+		}
+	} else {
+		// copy the files from vm path
+		args := []string{vmPath, changeDiskFile}
+		secLog.Infof("wlavm/prepare:vmVolumeManager() %s, Copying all the files from %s to vm mount path", message.SU, vmPath)
+		_, err = executeCommand(ctx, "cp", args)
+		if err != nil {
+			return errors.Wrapf(err, "wlavm/prepare:vmVolumeManager() error copying the vm path %s change disk to mount path. %s", vmPath, vmMountPath)
+		}
 	}
 
-	// remove the encrypted image file and create a symlink with the dm-crypt volume
+	// remove the encrypted image file and create a symlink with the dm-crypt volume, the change disk is
+	// kept aside until the VM is prepared
 	secLog.Infof("wlavm/prepare:vmVolumeManager() %s, Deleting change disk: %s", message.SU, vmPath)
-	err = os.RemoveAll(vmPath)
+	err = rb.preserveFile(vmPath)
 	if err != nil {
 		return errors.Wrapf(err, "wlavm/prepare:vmVolumeManager() error deleting the change disk: %s", vmPath)
 	}
 
-	changeDiskFile := vmMountPath + "/disk"
 	log.Debug("wlavm/prepare:vmVolumeManager() Creating a symlink between the vm and the volume")
 	// create symlink between the image and the dm-crypt volume
 	err = createSymLinkAndChangeOwnership(changeDiskFile, vmPath, vmMountPath)
@@ -345,7 +556,7 @@ func vmVolumeManager(vmUUID string, vmPath string, size int, key []byte, filewat
 	}
 
 	// trigger a file watcher event to delete VM mount path when disk.info file is deleted on VM delete
-	vmDiskInfoFile := strings.Replace(vmPath, "disk", "disk.info", -1)
+	vmDiskInfoFile := vmDeleteWatchPath(vmPath)
 	// Watch the symlink for deletion, and remove the _sparseFile if image is deleted
 	err = filewatcher.HandleEvent(vmDiskInfoFile, func(e fsnotify.Event) {
 		if e.Op&fsnotify.Remove == fsnotify.Remove {
@@ -354,6 +565,10 @@ func vmVolumeManager(vmUUID string, vmPath string, size int, key []byte, filewat
 			if err != nil {
 				log.Errorf("wlavm/prepare:vmVolumeManager() Failed to remove mount path")
 			}
+			if err = removeVMKey(vmUUID); err != nil {
+				log.WithError(err).Error("wlavm/prepare:vmVolumeManager() Failed to remove the VM volume key")
+			}
+			removeVMState(vmUUID)
 		}
 	})
 	if err != nil {
@@ -363,20 +578,28 @@ func vmVolumeManager(vmUUID string, vmPath string, size int, key []byte, filewat
 	return nil
 }
 
-func imageVolumeManager(imageUUID string, imagePath string, size int, key []byte) error {
+func imageVolumeManager(ctx context.Context, rb *rollback, imageUUID string, imagePath string, size int, key []byte) error {
 	log.Trace("wlavm/prepare:imageVolumeManager() Entering")
 	defer log.Trace("wlavm/prepare:imageVolumeManager() Leaving")
 
 	// create image dm-crypt volume
 	var err error
-	imageDeviceMapperPath := consts.DevMapperDirPath + imageUUID
-	sparseFilePath := imagePath + "_sparseFile"
+	volume := imageVolumeState(imageUUID, imagePath)
+	imageDeviceMapperPath := volume.DeviceMapperPath
+	sparseFilePath := volume.SparseFilePath
+	// the sparse file of an image on a block device is in the image volume directory of the agent
+	if strings.HasPrefix(sparseFilePath, consts.ImageVolumeDirPath) {
+		err = os.MkdirAll(consts.ImageVolumeDirPath, 0700)
+		if err != nil {
+			return errors.Wrap(err, "wlavm/prepare:imageVolumeManager() error creating the image volume directory")
+		}
+	}
 	// check if the sparse file already exists, if it does, skip image file decryption
 	_, sparseFileStatErr := os.Stat(sparseFilePath)
-	imgVolumeMtx.Lock()
+	loopDeviceMtx.Lock()
 	secLog.Infof("wlavm/prepare:imageVolumeManager() %s, Creating a dm-crypt volume for the image %s", message.SU, imageUUID)
 	err = vml.CreateVolume(sparseFilePath, imageDeviceMapperPath, key, size)
-	imgVolumeMtx.Unlock()
+	loopDeviceMtx.Unlock()
 	if err != nil {
 		if strings.Contains(err.Error(), "device mapper of the same already exists") {
 			log.Debug("wlavm/prepare:imageVolumeManager() Device mapper of same name already exists. Skipping image volume creation..")
@@ -385,13 +608,15 @@ func imageVolumeManager(imageUUID string, imagePath string, size int, key []byte
 			return errors.Wrap(err, "wlavm/prepare:imageVolumeManager() error while creating image dm-crypt volume for image")
 		}
 	}
+	addCloseVolume(rb, imageDeviceMapperPath, sparseFilePath, os.IsNotExist(sparseFileStatErr))
 
 	//check if the image device mapper is mount path exists, if not create it
-	imageDeviceMapperMountPath := consts.MountPath + imageUUID
+	imageDeviceMapperMountPath := volume.MountPath
 	err = checkMountPathExistsAndMountVolume(imageDeviceMapperMountPath, imageDeviceMapperPath, imageUUID)
 	if err != nil {
 		return errors.Wrap(err, "wlavm/prepare:imageVolumeManager() error checking if image mount path exists and mounting the volume")
 	}
+	addUnmount(rb, imageDeviceMapperMountPath)
 
 	// is sparse file exists, the image is already decrypted, so returning back to VM start method
 	if !os.IsNotExist(sparseFileStatErr) {
@@ -399,27 +624,15 @@ func imageVolumeManager(imageUUID string, imagePath string, size int, key []byte
 		return nil
 	}
 
-	// read image file contents
-	log.Debug("Reading the encrypted image file...")
-	encryptedImage, ioReadErr := ioutil.ReadFile(imagePath)
-	if ioReadErr != nil {
-		return errors.New("wlavm/prepare:imageVolumeManager() error while reading the image file")
-	}
-
-	//decrypt the image
-	log.Info("wlavm/prepare:imageVolumeManager() Decrypting the image")
-	decryptedImage, err := vml.Decrypt(encryptedImage, key)
+	// decrypt the image as a stream straight into the image volume, so that neither the encrypted
+	// nor the decrypted image has to be held in memory
+	decryptedImagePath := imageDeviceMapperMountPath + "/" + imageUUID
+	secLog.Infof("wlavm/prepare:imageVolumeManager() %s, Decrypting the image in to a file: %s", message.SU, decryptedImagePath)
+	err = decryptImageFile(ctx, imagePath, decryptedImagePath, key, logDecryptProgress(imageUUID))
 	if err != nil {
-		return errors.Wrap(err, "wlavm/prepare:imageVolumeManager() error while decrypting the image")
+		return newVMError(PhasePrepare, DecryptFailed, err, "error while decrypting the image")
 	}
 	log.Info("wlavm/prepare:imageVolumeManager() Image decrypted successfully")
-	// write the decrypted data into a file in image mount path
-	decryptedImagePath := imageDeviceMapperMountPath + "/" + imageUUID
-	secLog.Infof("wlavm/prepare:imageVolumeManager() %s, Writing decrypted data in to a file: %s", message.SU, decryptedImagePath)
-	ioWriteErr := ioutil.WriteFile(decryptedImagePath, decryptedImage, 0664)
-	if ioWriteErr != nil {
-		return errors.New("wlavm/prepare:imageVolumeManager() error writing the decrypted data to file")
-	}
 
 	// get the qemu user info and change image and vm file owner to qemu
 	userID, groupID, err := userInfoLookUp("qemu")
@@ -450,6 +663,47 @@ func imageVolumeManager(imageUUID string, imagePath string, size int, key []byte
 	return nil
 }
 
+// addCloseVolume records the creation of a dm-crypt volume. The volume is closed on rollback, and its sparse file
+// is removed if it was created along with the volume, so that the next attempt does not take it for complete.
+func addCloseVolume(rb *rollback, deviceMapperPath, sparseFilePath string, newSparseFile bool) {
+	rb.add("close volume "+deviceMapperPath, func() error {
+		secLog.Infof("wlavm/prepare:addCloseVolume() %s, Closing volume %s", message.SU, deviceMapperPath)
+		if err := vml.DeleteVolume(deviceMapperPath); err != nil {
+			return err
+		}
+		if !newSparseFile {
+			return nil
+		}
+		if err := os.Remove(sparseFilePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// addUnmount records the mount of a dm-crypt volume, which is unmounted on rollback
+func addUnmount(rb *rollback, mountPath string) {
+	rb.add("unmount "+mountPath, func() error {
+		secLog.Infof("wlavm/prepare:addUnmount() %s, Unmounting %s", message.SU, mountPath)
+		return vml.Unmount(mountPath)
+	})
+}
+
+// logDecryptProgress returns a decryptProgress that logs every tenth of the image decrypted
+func logDecryptProgress(imageUUID string) decryptProgress {
+	var lastReported int64 = -1
+	return func(done, total int64) {
+		if total == 0 {
+			return
+		}
+		percent := done * 100 / total
+		if percent/10 != lastReported/10 {
+			lastReported = percent
+			log.Infof("wlavm/prepare:imageVolumeManager() Decrypting image %s: %d%% (%d of %d bytes)", imageUUID, percent, done, total)
+		}
+	}
+}
+
 func createSymLinkAndChangeOwnership(targetFile, sourceFile, mountPath string) error {
 	log.Trace("wlavm/prepare:createSymLinkAndChangeOwnership() Entering")
 	defer log.Trace("wlavm/prepare:createSymLinkAndChangeOwnership() Leaving")
@@ -0,0 +1,393 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"bufio"
+	"intel/isecl/lib/common/v4/exec"
+	"intel/isecl/lib/common/v4/log/message"
+	"intel/isecl/lib/vml/v4"
+	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/libvirt"
+	"intel/isecl/wlagent/v4/util"
+	"io"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	libvirtURI       = "qemu:///system"
+	procMountsPath   = "/proc/mounts"
+	sparseFileMarker = "_sparse"
+
+	// openVolumesCacheTTL is how long the counts returned by CountOpenVolumes are reused
+	openVolumesCacheTTL = 15 * time.Second
+)
+
+var (
+	uuidRegex = regexp.MustCompile("^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}$")
+
+	// VMs between prepare and start are not running yet, but their volumes are in use
+	pendingMtx sync.Mutex
+	pendingVMs = make(map[string]string)
+
+	openVolumesCache struct {
+		sync.Mutex
+		volumes       int
+		mountedImages int
+		countedAt     time.Time
+	}
+)
+
+// DomainLister lists the domain XML of the domains running on the host, and of all the domains defined on it
+type DomainLister interface {
+	ListRunningDomains() ([]string, error)
+	ListDefinedDomains() ([]string, error)
+}
+
+// VirshDomainLister lists the domains through virsh, using the local libvirt socket
+type VirshDomainLister struct{}
+
+// ReconcileReport describes the state found and the changes made by Reconcile
+type ReconcileReport struct {
+	DryRun          bool
+	RunningVMs      []string
+	ImageVMCounts   map[string]int
+	ClosedVolumes   []string
+	UnmountedPaths  []string
+	CorrectedCounts []string
+	ReconcileErrors []string
+}
+
+// Available reports whether virsh is installed, it is not on hosts running only containers
+func (VirshDomainLister) Available() bool {
+	_, err := osexec.LookPath("virsh")
+	return err == nil
+}
+
+// ListRunningDomains returns the domain XML of each running domain
+func (VirshDomainLister) ListRunningDomains() ([]string, error) {
+	log.Trace("wlavm/reconcile:ListRunningDomains() Entering")
+	defer log.Trace("wlavm/reconcile:ListRunningDomains() Leaving")
+
+	return listDomains()
+}
+
+// ListDefinedDomains returns the domain XML of each domain defined on the host, running or not
+func (VirshDomainLister) ListDefinedDomains() ([]string, error) {
+	log.Trace("wlavm/reconcile:ListDefinedDomains() Entering")
+	defer log.Trace("wlavm/reconcile:ListDefinedDomains() Leaving")
+
+	return listDomains("--all")
+}
+
+// listDomains returns the domain XML of each domain listed by virsh list with the flags
+func listDomains(flags ...string) ([]string, error) {
+	output, err := exec.ExecuteCommand("virsh", append([]string{"-c", libvirtURI, "list", "--uuid"}, flags...))
+	if err != nil {
+		return nil, errors.Wrap(err, "wlavm/reconcile:listDomains() error listing the domains")
+	}
+
+	var domainXMLs []string
+	for _, domainUUID := range strings.Fields(output) {
+		domainXML, err := exec.ExecuteCommand("virsh", []string{"-c", libvirtURI, "dumpxml", domainUUID})
+		if err != nil {
+			return nil, errors.Wrapf(err, "wlavm/reconcile:listDomains() error reading the domain XML of %s", domainUUID)
+		}
+		domainXMLs = append(domainXMLs, domainXML)
+	}
+	return domainXMLs, nil
+}
+
+// Reconcile closes the dm-crypt volumes and unmounts the mount points of the agent that no running VM uses, as left
+// by an agent crash, and rebuilds the image vm counts. Sparse files are kept. Nothing is changed when the running
+// domains can not be listed, and with dryRun the report only lists what would be changed.
+func Reconcile(lister DomainLister, dryRun bool) (ReconcileReport, error) {
+	log.Trace("wlavm/reconcile:Reconcile() Entering")
+	defer log.Trace("wlavm/reconcile:Reconcile() Leaving")
+
+	lifecycleMtx.Lock()
+	defer lifecycleMtx.Unlock()
+
+	report := ReconcileReport{DryRun: dryRun, ImageVMCounts: make(map[string]int)}
+	domainXMLs, err := lister.ListRunningDomains()
+	if err != nil {
+		return report, errors.Wrap(err, "wlavm/reconcile:Reconcile() error listing the running domains, skipping reconciliation")
+	}
+
+	inUse := make(map[string]bool)
+	imagePaths := make(map[string]string)
+	for _, domainXML := range domainXMLs {
+		d, err := parseDomain(domainXML, libvirt.Start)
+		if err != nil {
+			return report, errors.Wrap(err, "wlavm/reconcile:Reconcile() error parsing the domain XML of a running domain")
+		}
+		vmUUID := d.GetVMUUID()
+		report.RunningVMs = append(report.RunningVMs, vmUUID)
+		inUse[vmUUID] = true
+		if d.GetImageUUID() != "" && strings.HasPrefix(d.GetImagePath(), consts.MountPath) {
+			report.ImageVMCounts[d.GetImageUUID()]++
+			imagePaths[d.GetImageUUID()] = d.GetImagePath()
+			inUse[d.GetImageUUID()] = true
+		}
+	}
+	pendingMtx.Lock()
+	for vmUUID, imageUUID := range pendingVMs {
+		inUse[vmUUID] = true
+		inUse[imageUUID] = true
+	}
+	pendingMtx.Unlock()
+
+	// unmount before closing the volumes, a mounted volume can not be closed
+	mountPoints, err := listAgentMounts()
+	if err != nil {
+		return report, err
+	}
+	for _, mountPoint := range mountPoints {
+		if inUse[filepath.Base(mountPoint)] {
+			continue
+		}
+		if dryRun {
+			report.UnmountedPaths = append(report.UnmountedPaths, mountPoint)
+			continue
+		}
+		secLog.Infof("wlavm/reconcile:Reconcile() %s, Unmounting orphaned mount point %s", message.SU, mountPoint)
+		if err = vml.Unmount(mountPoint); err != nil {
+			log.WithError(err).Errorf("wlavm/reconcile:Reconcile() Failed to unmount %s", mountPoint)
+			report.ReconcileErrors = append(report.ReconcileErrors, "unmount "+mountPoint+": "+err.Error())
+			continue
+		}
+		report.UnmountedPaths = append(report.UnmountedPaths, mountPoint)
+	}
+
+	volumes, err := listAgentVolumes()
+	if err != nil {
+		return report, err
+	}
+	for _, volume := range volumes {
+		if inUse[volume] {
+			continue
+		}
+		if dryRun {
+			report.ClosedVolumes = append(report.ClosedVolumes, volume)
+			continue
+		}
+		secLog.Infof("wlavm/reconcile:Reconcile() %s, Closing orphaned dm-crypt volume %s", message.SU, volume)
+		if err = vml.DeleteVolume(consts.DevMapperDirPath + volume); err != nil {
+			log.WithError(err).Errorf("wlavm/reconcile:Reconcile() Failed to close dm-crypt volume %s", volume)
+			report.ReconcileErrors = append(report.ReconcileErrors, "close volume "+volume+": "+err.Error())
+			continue
+		}
+		report.ClosedVolumes = append(report.ClosedVolumes, volume)
+	}
+
+	report.CorrectedCounts, err = rebuildImageVMAssociations(report.ImageVMCounts, imagePaths, dryRun)
+	if err != nil {
+		return report, err
+	}
+	log.Infof("wlavm/reconcile:Reconcile() Reconciled %d running VMs, closed %d volumes, unmounted %d mount points, "+
+		"corrected %d image vm counts", len(report.RunningVMs), len(report.ClosedVolumes), len(report.UnmountedPaths),
+		len(report.CorrectedCounts))
+	return report, nil
+}
+
+// rebuildImageVMAssociations replaces the image vm associations with the counts of the running VMs
+// and returns the images whose count was corrected. With dryRun the associations are left unchanged.
+func rebuildImageVMAssociations(imageVMCounts map[string]int, imagePaths map[string]string, dryRun bool) ([]string, error) {
+	var corrected []string
+	associations := make(map[string]*util.ImageVMAssociation)
+
+	util.MapMtx.Lock()
+	for imageUUID, association := range util.ImageVMAssociations {
+		if imageVMCounts[imageUUID] == 0 {
+			log.Warnf("wlavm/reconcile:rebuildImageVMAssociations() No running VM uses image %s, removing its "+
+				"association with %d VMs", imageUUID, association.VMCount)
+			corrected = append(corrected, imageUUID)
+		}
+	}
+	for imageUUID, vmCount := range imageVMCounts {
+		imagePath := imagePaths[imageUUID]
+		if association, ok := util.ImageVMAssociations[imageUUID]; ok {
+			if association.ImagePath != "" {
+				imagePath = association.ImagePath
+			}
+			if association.VMCount == vmCount {
+				associations[imageUUID] = &util.ImageVMAssociation{ImagePath: imagePath, VMCount: vmCount}
+				continue
+			}
+		}
+		log.Warnf("wlavm/reconcile:rebuildImageVMAssociations() Correcting the vm count of image %s to %d", imageUUID, vmCount)
+		corrected = append(corrected, imageUUID)
+		associations[imageUUID] = &util.ImageVMAssociation{ImagePath: imagePath, VMCount: vmCount}
+	}
+	if !dryRun {
+		util.ImageVMAssociations = associations
+	}
+	util.MapMtx.Unlock()
+
+	sort.Strings(corrected)
+	if len(corrected) == 0 || dryRun {
+		return nil, nil
+	}
+	err := util.SaveImageVMAssociation()
+	if err != nil {
+		return corrected, errors.Wrap(err, "wlavm/reconcile:rebuildImageVMAssociations() error saving the image vm associations")
+	}
+	return corrected, nil
+}
+
+// listAgentVolumes returns the names of the active dm-crypt volumes opened by the agent
+func listAgentVolumes() ([]string, error) {
+	backingFiles, err := agentVolumeBackingFiles()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make([]string, 0, len(backingFiles))
+	for volume := range backingFiles {
+		volumes = append(volumes, volume)
+	}
+	sort.Strings(volumes)
+	return volumes, nil
+}
+
+// agentVolumeBackingFiles returns the sparse file backing each active dm-crypt volume opened by the agent
+func agentVolumeBackingFiles() (map[string]string, error) {
+	entries, err := ioutil.ReadDir(consts.DevMapperDirPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "wlavm/reconcile:agentVolumeBackingFiles() error listing %s", consts.DevMapperDirPath)
+	}
+
+	backingFiles := make(map[string]string)
+	for _, entry := range entries {
+		if !uuidRegex.MatchString(entry.Name()) {
+			continue
+		}
+		output, err := exec.ExecuteCommand("cryptsetup", []string{"status", consts.DevMapperDirPath + entry.Name()})
+		if err != nil {
+			log.WithError(err).Debugf("wlavm/reconcile:agentVolumeBackingFiles() Skipping %s, not an active dm-crypt volume", entry.Name())
+			continue
+		}
+		backingFile := cryptsetupBackingFile(output)
+		if !strings.Contains(filepath.Base(backingFile), sparseFileMarker) {
+			continue
+		}
+		backingFiles[entry.Name()] = backingFile
+	}
+	return backingFiles, nil
+}
+
+// listAgentMounts returns the mount points under the agent mount path
+func listAgentMounts() ([]string, error) {
+	mounts, err := os.Open(procMountsPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "wlavm/reconcile:listAgentMounts() error opening %s", procMountsPath)
+	}
+	defer func() {
+		derr := mounts.Close()
+		if derr != nil {
+			log.WithError(derr).Error("Error closing file")
+		}
+	}()
+	return parseAgentMounts(mounts)
+}
+
+func parseAgentMounts(mounts io.Reader) ([]string, error) {
+	var mountPoints []string
+	scanner := bufio.NewScanner(mounts)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		mountPoint := filepath.Clean(fields[1])
+		if filepath.Dir(mountPoint)+"/" == consts.MountPath && uuidRegex.MatchString(filepath.Base(mountPoint)) {
+			mountPoints = append(mountPoints, mountPoint)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "wlavm/reconcile:parseAgentMounts() error reading the mounts")
+	}
+	return mountPoints, nil
+}
+
+// cryptsetupBackingFile returns the loop device backing file from the cryptsetup status output
+func cryptsetupBackingFile(statusOutput string) string {
+	for _, line := range strings.Split(statusOutput, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(fields) == 2 && fields[0] == "loop" {
+			return strings.TrimSpace(fields[1])
+		}
+	}
+	return ""
+}
+
+// CountOpenVolumes returns the number of dm-crypt volumes opened by the agent, and the number of
+// decrypted images mounted. Counting runs cryptsetup for each device mapper entry, the counts are reused for
+// openVolumesCacheTTL so that scraping the metrics or polling the status does not run it on every call.
+func CountOpenVolumes() (int, int, error) {
+	openVolumesCache.Lock()
+	defer openVolumesCache.Unlock()
+	if !openVolumesCache.countedAt.IsZero() && time.Since(openVolumesCache.countedAt) < openVolumesCacheTTL {
+		return openVolumesCache.volumes, openVolumesCache.mountedImages, nil
+	}
+
+	volumes, err := listAgentVolumes()
+	if err != nil {
+		return 0, 0, err
+	}
+	mountPoints, err := listAgentMounts()
+	if err != nil {
+		return 0, 0, err
+	}
+	mountedImages := 0
+	for _, mountPoint := range mountPoints {
+		// the decrypted image is named after the image UUID in its mount point, a VM mount point holds the VM disk
+		imageUUID := filepath.Base(mountPoint)
+		if _, err = os.Stat(filepath.Join(mountPoint, imageUUID)); err == nil {
+			mountedImages++
+		}
+	}
+	openVolumesCache.volumes = len(volumes)
+	openVolumesCache.mountedImages = mountedImages
+	openVolumesCache.countedAt = time.Now()
+	return len(volumes), mountedImages, nil
+}
+
+// markPending keeps the volumes of a prepared VM from being closed by Reconcile until the VM is started or stopped
+func markPending(vmUUID, imageUUID string) {
+	pendingMtx.Lock()
+	defer pendingMtx.Unlock()
+	pendingVMs[vmUUID] = imageUUID
+}
+
+func clearPending(vmUUID string) {
+	pendingMtx.Lock()
+	defer pendingMtx.Unlock()
+	delete(pendingVMs, vmUUID)
+}
+
+// isImagePending returns true if a VM using the image is prepared but not started yet
+func isImagePending(imageUUID string) bool {
+	pendingMtx.Lock()
+	defer pendingMtx.Unlock()
+	for _, pendingImageUUID := range pendingVMs {
+		if pendingImageUUID == imageUUID {
+			return true
+		}
+	}
+	return false
+}
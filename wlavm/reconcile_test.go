@@ -0,0 +1,77 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/util"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testImageUUID = "31ab5921-24fd-498c-8c9e-b20f61004fc0"
+	testVMUUID    = "4c9c1c42-7d39-4d41-8a8c-41e4b0a0e0a1"
+)
+
+func TestParseAgentMounts(t *testing.T) {
+	mounts := "/dev/sda1 / ext4 rw,relatime 0 0\n" +
+		"/dev/mapper/" + testImageUUID + " " + consts.MountPath + testImageUUID + " ext4 rw,relatime 0 0\n" +
+		"/dev/mapper/" + testVMUUID + " " + consts.MountPath + testVMUUID + " ext4 rw,relatime 0 0\n" +
+		"/dev/sdb1 " + consts.MountPath + "not-a-uuid ext4 rw,relatime 0 0\n"
+
+	mountPoints, err := parseAgentMounts(strings.NewReader(mounts))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{consts.MountPath + testImageUUID, consts.MountPath + testVMUUID}, mountPoints)
+}
+
+func TestCryptsetupBackingFile(t *testing.T) {
+	status := "/dev/mapper/" + testImageUUID + " is active and is in use.\n" +
+		"  type:    LUKS1\n" +
+		"  cipher:  aes-xts-plain64\n" +
+		"  device:  /dev/loop0\n" +
+		"  loop:    /var/lib/nova/instances/_base/image_sparseFile\n"
+
+	assert.Equal(t, "/var/lib/nova/instances/_base/image_sparseFile", cryptsetupBackingFile(status))
+	assert.Equal(t, "", cryptsetupBackingFile("/dev/mapper/x is inactive.\n"))
+}
+
+func TestRebuildImageVMAssociationsDryRun(t *testing.T) {
+	defer func(associations map[string]*util.ImageVMAssociation) {
+		util.ImageVMAssociations = associations
+	}(util.ImageVMAssociations)
+	util.ImageVMAssociations = map[string]*util.ImageVMAssociation{
+		testImageUUID: {ImagePath: consts.MountPath + testImageUUID + "/" + testImageUUID, VMCount: 3},
+	}
+
+	corrected, err := rebuildImageVMAssociations(map[string]int{testImageUUID: 1}, map[string]string{}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{testImageUUID}, corrected)
+	assert.Equal(t, 3, util.ImageVMAssociations[testImageUUID].VMCount)
+
+	corrected, err = rebuildImageVMAssociations(map[string]int{testImageUUID: 3}, map[string]string{}, true)
+	assert.NoError(t, err)
+	assert.Empty(t, corrected)
+}
+
+func TestCountOpenVolumesCached(t *testing.T) {
+	defer func() {
+		openVolumesCache.countedAt = time.Time{}
+	}()
+	openVolumesCache.volumes, openVolumesCache.mountedImages = 3, 1
+	openVolumesCache.countedAt = time.Now()
+
+	// recent counts are returned without listing the device mapper entries and the mounts again
+	volumes, mountedImages, err := CountOpenVolumes()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, volumes)
+	assert.Equal(t, 1, mountedImages)
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// preservedFileSuffix is appended to the files renamed aside by a rollback until the operation completes
+const preservedFileSuffix = ".wlagent-orig"
+
+// rollback records the completed steps of an operation, so that a failed operation undoes them in reverse order
+// and leaves the host as it found it. A rollback is used by a single goroutine.
+type rollback struct {
+	steps     []rollbackStep
+	preserved map[string]bool
+}
+
+type rollbackStep struct {
+	description string
+	undo        func() error
+}
+
+// add records a completed step, undo is called if the operation fails
+func (r *rollback) add(description string, undo func() error) {
+	r.steps = append(r.steps, rollbackStep{description: description, undo: undo})
+}
+
+// preserveFile moves path aside before the operation replaces or removes it, so that a failed operation puts it
+// back. A path already preserved by the operation is removed, as it no longer holds the original file.
+func (r *rollback) preserveFile(path string) error {
+	if r.preserved[path] {
+		if err := os.RemoveAll(path); err != nil {
+			return errors.Wrapf(err, "wlavm/rollback:preserveFile() error removing %s", path)
+		}
+		return nil
+	}
+
+	preservedPath := path + preservedFileSuffix
+	if err := os.Rename(path, preservedPath); err != nil {
+		return errors.Wrapf(err, "wlavm/rollback:preserveFile() error moving %s aside", path)
+	}
+	if r.preserved == nil {
+		r.preserved = make(map[string]bool)
+	}
+	r.preserved[path] = true
+	r.add("restore "+path, func() error {
+		// the rename replaces whatever the operation left at path, a file or a symlink
+		return os.Rename(preservedPath, path)
+	})
+	return nil
+}
+
+// commit is called when the operation succeeded, it removes the preserved files and forgets the steps
+func (r *rollback) commit() {
+	for path := range r.preserved {
+		if err := os.Remove(path + preservedFileSuffix); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Errorf("wlavm/rollback:commit() Failed to remove the preserved %s", path)
+		}
+	}
+	r.steps = nil
+	r.preserved = nil
+}
+
+// run undoes the recorded steps in reverse order. A step that can not be undone is logged and the earlier steps
+// are still undone, the error of the first step that failed is returned.
+func (r *rollback) run() error {
+	log.Trace("wlavm/rollback:run() Entering")
+	defer log.Trace("wlavm/rollback:run() Leaving")
+
+	var firstErr error
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		log.Infof("wlavm/rollback:run() Rolling back: %s", step.description)
+		if err := step.undo(); err != nil {
+			log.WithError(err).Errorf("wlavm/rollback:run() Failed to %s", step.description)
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "wlavm/rollback:run() failed to %s", step.description)
+			}
+		}
+	}
+	r.steps = nil
+	r.preserved = nil
+	return firstErr
+}
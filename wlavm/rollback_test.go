@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollbackRunsInReverse(t *testing.T) {
+	rb := &rollback{}
+	var undone []string
+	for _, step := range []string{"create", "mount", "link"} {
+		step := step
+		rb.add(step, func() error {
+			undone = append(undone, step)
+			if step == "mount" {
+				return errors.New("busy")
+			}
+			return nil
+		})
+	}
+
+	// a step that can not be undone does not keep the earlier steps from being undone
+	err := rb.run()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "mount")
+	assert.Equal(t, []string{"link", "mount", "create"}, undone)
+
+	undone = nil
+	assert.NoError(t, rb.run())
+	assert.Empty(t, undone)
+}
+
+func TestRollbackPreserveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rollback")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	disk := filepath.Join(dir, "disk")
+
+	// a failed operation puts the original file back in place of what it left behind
+	assert.NoError(t, ioutil.WriteFile(disk, []byte("original"), 0600))
+	rb := &rollback{}
+	assert.NoError(t, rb.preserveFile(disk))
+	assert.NoError(t, ioutil.WriteFile(disk, []byte("recreated"), 0600))
+	assert.NoError(t, rb.preserveFile(disk))
+	assert.NoError(t, os.Symlink(filepath.Join(dir, "volume"), disk))
+	assert.NoError(t, rb.run())
+	content, err := ioutil.ReadFile(disk)
+	assert.NoError(t, err)
+	assert.Equal(t, "original", string(content))
+
+	// a successful operation drops the original file
+	rb = &rollback{}
+	assert.NoError(t, rb.preserveFile(disk))
+	assert.NoError(t, ioutil.WriteFile(disk, []byte("recreated"), 0600))
+	rb.commit()
+	content, err = ioutil.ReadFile(disk)
+	assert.NoError(t, err)
+	assert.Equal(t, "recreated", string(content))
+	_, err = os.Stat(disk + preservedFileSuffix)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, rb.run())
+}
@@ -0,0 +1,139 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"context"
+	"intel/isecl/lib/common/v4/crypt"
+	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/filewatch"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// isStandaloneEncryptedDisk returns true if the VM disk is a standalone copy of an encrypted image, either prepared
+// already according to the state of the VM or carrying the encryption header. Such a VM uses no image volume.
+func isStandaloneEncryptedDisk(vmUUID, vmPath string) (bool, error) {
+	state, err := LoadVMState(vmUUID)
+	if err != nil {
+		log.WithError(err).Warnf("wlavm/standalone_disk:isStandaloneEncryptedDisk() Error loading the state of VM %s", vmUUID)
+	}
+	if state != nil && state.StandaloneDisk {
+		return true, nil
+	}
+	// a symbolic link is the disk of a VM prepared already
+	if _, err = os.Readlink(vmPath); err == nil {
+		return false, nil
+	}
+	encrypted, err := crypt.EncryptionHeaderExists(vmPath)
+	if err != nil {
+		return false, errors.Wrapf(err, "wlavm/standalone_disk:isStandaloneEncryptedDisk() Error reading the VM disk %s", vmPath)
+	}
+	return encrypted, nil
+}
+
+// prepareStandaloneVM opens the volume of a VM on a standalone disk and records it in the state of the VM. With
+// checkDisk, the disk in the volume is checked to be standalone, the VM may be an overlay whose image is not known.
+func prepareStandaloneVM(ctx context.Context, rb *rollback, vmUUID, imageUUID, vmPath string, size int, filewatcher *filewatch.Watcher, checkDisk bool) error {
+	keyID, err := prepareStandaloneDisk(ctx, rb, vmUUID, imageUUID, vmPath, size, filewatcher)
+	if err != nil {
+		return err
+	}
+	if checkDisk {
+		diskPath := consts.MountPath + vmUUID + "/disk"
+		info, err := qemuImgInfo(ctx, diskPath)
+		if err != nil {
+			log.WithError(err).Errorf("wlavm/standalone_disk:prepareStandaloneVM() Error reading the disk of VM %s", vmUUID)
+			return newVMError(PhasePrepare, QemuImgFailed, err, "error reading the VM disk in the VM volume")
+		}
+		if info.BackingFile != "" {
+			log.Errorf("wlavm/standalone_disk:prepareStandaloneVM() The disk of VM %s is backed by %s", vmUUID, info.BackingFile)
+			return newVMError(PhasePrepare, AssociationFailed, nil, "image path for image "+imageUUID+" of VM "+vmUUID+
+				" not found in the VM state nor in the image-vm association")
+		}
+	}
+	updateVMState(vmUUID, true, func(state *VMState) {
+		state.ImageUUID = imageUUID
+		state.VMPath = vmPath
+		state.Encrypted = true
+		state.StandaloneDisk = true
+		state.KeyID = keyID
+		state.VMVolume = vmVolumeState(vmUUID, vmPath)
+		state.PerVMKey = hasVMKey(vmUUID)
+		state.Phase = PhasePrepare
+		state.Error = ""
+		state.PreparedAt = time.Now()
+	})
+	log.Infof("wlavm/standalone_disk:prepareStandaloneVM() VM %s prepared on a standalone disk", vmUUID)
+	return nil
+}
+
+// hasStandaloneVolume returns true if the VM disk is a symbolic link to the disk in the volume of the VM and the
+// sparse file of the volume is next to the VM disk, as they are left by a VM prepared on a standalone disk
+func hasStandaloneVolume(vmUUID, vmPath string) bool {
+	target, err := os.Readlink(vmPath)
+	if err != nil || filepath.Clean(target) != consts.MountPath+vmUUID+"/disk" {
+		return false
+	}
+	info, err := os.Stat(vmSparseFilePath(vmUUID, vmPath))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// prepareStandaloneDisk retrieves the key of the image and opens the volume of the VM, into which the encrypted VM
+// disk is decrypted on the first launch. It returns the ID of the image key.
+func prepareStandaloneDisk(ctx context.Context, rb *rollback, vmUUID, imageUUID, vmPath string, size int, filewatcher *filewatch.Watcher) (string, error) {
+	log.Trace("wlavm/standalone_disk:prepareStandaloneDisk() Entering")
+	defer log.Trace("wlavm/standalone_disk:prepareStandaloneDisk() Leaving")
+
+	if imageUUID == "" {
+		log.Errorf("wlavm/standalone_disk:prepareStandaloneDisk() No image UUID for the encrypted disk of VM %s", vmUUID)
+		return "", newVMError(PhasePrepare, ImageUnidentified, nil, "the domain XML does not name the image of the "+
+			"encrypted VM disk "+vmPath)
+	}
+	image, err := retrieveImageKey(ctx, imageUUID)
+	if err != nil {
+		return "", err
+	}
+	if image.key == nil {
+		return "", newVMError(PhasePrepare, KeyDenied, nil, "the flavor of image "+imageUUID+" does not require "+
+			"encryption, the key of the encrypted VM disk is not released")
+	}
+
+	if size == 0 {
+		size, err = standaloneDiskVolumeSize(ctx, vmUUID, vmPath)
+		if err != nil {
+			log.WithError(err).Errorf("wlavm/standalone_disk:prepareStandaloneDisk() Error sizing the volume of VM %s", vmUUID)
+			return "", newVMError(PhasePrepare, QemuImgFailed, err, "error reading the size of the VM disk")
+		}
+	}
+
+	log.Info("wlavm/standalone_disk:prepareStandaloneDisk() Creating and mounting vm dm-crypt volume")
+	err = vmVolumeManager(ctx, rb, vmUUID, vmPath, size, image.key, filewatcher, true)
+	if err != nil {
+		log.WithError(err).Error("wlavm/standalone_disk:prepareStandaloneDisk() Error while creating and mounting vm dm-crypt volume")
+		if vmErr, ok := err.(*VMError); ok {
+			return "", vmErr
+		}
+		return "", newVMError(PhasePrepare, VolumeFailed, err, "error creating and mounting the VM dm-crypt volume")
+	}
+	return image.keyID, nil
+}
+
+// standaloneDiskVolumeSize returns the size in GB of the volume holding the decrypted VM disk, when the domain XML does
+// not give the size of the flavor: the size of the sparse file once it exists, else the size of the volume for the
+// encrypted disk, which is larger than the decrypted one
+func standaloneDiskVolumeSize(ctx context.Context, vmUUID, vmPath string) (int, error) {
+	if info, err := os.Stat(vmVolumeState(vmUUID, vmPath).SparseFilePath); err == nil {
+		return int(math.Ceil(float64(info.Size()) / (1 << 30))), nil
+	}
+	return diskSizeFromVirtualSize(ctx, vmPath)
+}
@@ -0,0 +1,86 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"intel/isecl/wlagent/v4/consts"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVMVolumePaths(t *testing.T) {
+	// the disk of a Nova VM is named disk in the directory of the VM
+	novaVMPath := "/var/lib/nova/instances/" + testVMUUID + "/disk"
+	assert.Equal(t, "/var/lib/nova/instances/"+testVMUUID+"/"+testVMUUID+"_sparse", vmSparseFilePath(testVMUUID, novaVMPath))
+	assert.Equal(t, "/var/lib/nova/instances/"+testVMUUID+"/disk.info", vmDeleteWatchPath(novaVMPath))
+
+	// the disks of the other VMs share a directory and can have any name
+	vmPath := "/var/lib/libvirt/images/rhel8-disk1.qcow2"
+	assert.Equal(t, "/var/lib/libvirt/images/"+testVMUUID+"_sparse", vmSparseFilePath(testVMUUID, vmPath))
+	assert.Equal(t, vmPath, vmDeleteWatchPath(vmPath))
+}
+
+func TestIsStandaloneEncryptedDisk(t *testing.T) {
+	defer setupTestVMStateDir(t)()
+	dir, err := ioutil.TempDir("", "standalone-disk")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a disk without the encryption header is not protected
+	vmPath := filepath.Join(dir, "rhel8.qcow2")
+	assert.NoError(t, ioutil.WriteFile(vmPath, make([]byte, 4096), 0600))
+	standalone, err := isStandaloneEncryptedDisk(testVMUUID, vmPath)
+	assert.NoError(t, err)
+	assert.False(t, standalone)
+
+	// the disk of a prepared VM is a symbolic link to the volume of the VM, which is not the encrypted copy
+	assert.NoError(t, os.Remove(vmPath))
+	assert.NoError(t, os.Symlink(consts.MountPath+testVMUUID+"/disk", vmPath))
+	standalone, err = isStandaloneEncryptedDisk(testVMUUID, vmPath)
+	assert.NoError(t, err)
+	assert.False(t, standalone)
+
+	// the state of the VM tells that its volume was prepared from a standalone disk
+	updateVMState(testVMUUID, true, func(state *VMState) {
+		state.ImageUUID = testImageUUID
+		state.Encrypted = true
+		state.StandaloneDisk = true
+		state.VMVolume = vmVolumeState(testVMUUID, vmPath)
+	})
+	standalone, err = isStandaloneEncryptedDisk(testVMUUID, vmPath)
+	assert.NoError(t, err)
+	assert.True(t, standalone)
+}
+
+func TestHasStandaloneVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "standalone-volume")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	vmPath := filepath.Join(dir, "disk")
+
+	// the link is dangling while the volume is closed, e.g. after a reboot
+	assert.NoError(t, os.Symlink(consts.MountPath+testVMUUID+"/disk", vmPath))
+	assert.False(t, hasStandaloneVolume(testVMUUID, vmPath))
+	assert.NoError(t, ioutil.WriteFile(vmSparseFilePath(testVMUUID, vmPath), nil, 0600))
+	assert.True(t, hasStandaloneVolume(testVMUUID, vmPath))
+
+	// the volume of another VM is not the volume of this one
+	assert.False(t, hasStandaloneVolume(testOtherVMUUID, vmPath))
+	assert.NoError(t, os.Remove(vmPath))
+	assert.NoError(t, os.Symlink(consts.MountPath+testOtherVMUUID+"/disk", vmPath))
+	assert.False(t, hasStandaloneVolume(testVMUUID, vmPath))
+
+	// a disk that is not a link is not prepared yet
+	assert.NoError(t, os.Remove(vmPath))
+	assert.NoError(t, ioutil.WriteFile(vmPath, nil, 0600))
+	assert.False(t, hasStandaloneVolume(testVMUUID, vmPath))
+}
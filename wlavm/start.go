@@ -8,6 +8,7 @@
 package wlavm
 
 import (
+	"context"
 	"crypto"
 	"encoding/json"
 	wlsModel "github.com/intel-secl/intel-secl/v4/pkg/model/wls"
@@ -23,64 +24,91 @@ import (
 	"intel/isecl/wlagent/v4/config"
 	"intel/isecl/wlagent/v4/consts"
 	"intel/isecl/wlagent/v4/filewatch"
+	"intel/isecl/wlagent/v4/flavor"
 	"intel/isecl/wlagent/v4/libvirt"
+	"intel/isecl/wlagent/v4/metrics"
 	"intel/isecl/wlagent/v4/util"
 	"strings"
+	"time"
 )
 
 // Start method is used perform the VM confidentiality check before launching the VM
 // Input Parameters: domainXML content string
-// Return : Returns a boolean value to the main method.
-// true if the vm is launched successfully, else returns false.
-func Start(domainXMLContent string, filewatcher *filewatch.Watcher) bool {
+// Return : Returns nil if the vm is started successfully, else returns a *VMError
+// describing the reason the VM launch was refused.
+func Start(ctx context.Context, domainXMLContent string, filewatcher *filewatch.Watcher) (err error) {
 
 	log.Trace("wlavm/start:Start() Entering")
 	defer log.Trace("wlavm/start:Start() Leaving")
-	var err error
+	defer func() {
+		err = contextError(ctx, PhaseStart, err)
+	}()
 
 	log.Info("wlavm/start:Start() Parsing domain XML to get image UUID, image path, VM UUID, VM path and disk size")
-	d, err := libvirt.NewDomainParser(domainXMLContent, libvirt.Start)
+	d, err := parseDomain(domainXMLContent, libvirt.Start)
 	if err != nil {
 		log.Error("wlavm/start:Start() Parsing error: ", err.Error())
 		log.Tracef("%+v", err)
-		return false
+		return newVMError(PhaseStart, InvalidDomainXML, err, "error parsing domain XML")
 	}
 
 	vmUUID := d.GetVMUUID()
 	imageUUID := d.GetImageUUID()
 	imagePath := d.GetImagePath()
+	lifecycleMtx.RLock()
+	defer lifecycleMtx.RUnlock()
+	unlockVM := vmLocks.Lock(vmUUID)
+	defer unlockVM()
+	defer clearPending(vmUUID)
+	reportPosted := false
+	defer func() {
+		updateVMState(vmUUID, false, func(state *VMState) {
+			state.Phase = PhaseStart
+			state.Error = vmErrorMessage(contextError(ctx, PhaseStart, err))
+			if reportPosted {
+				state.ReportPostedAt = time.Now()
+			}
+			if err == nil {
+				state.StartedAt = time.Now()
+			}
+		})
+	}()
 
 	var flavorKeyInfo wlsModel.FlavorKey
 
-	// check if the image is in the crypto path - if yes it was launched from an encrypted image
+	// the state of the VM tells if it was launched from an encrypted image, for the VMs prepared without
+	// a state record the image is in the crypto path if it was
 	// need to push VM instance trust report to WLS
-	if strings.HasPrefix(imagePath, consts.MountPath) {
+	launchedFromEncryptedImage := strings.HasPrefix(imagePath, consts.MountPath)
+	state, stateErr := LoadVMState(vmUUID)
+	if stateErr != nil {
+		log.WithError(stateErr).Warnf("wlavm/start:Start() Error loading the state of VM %s", vmUUID)
+	} else if state != nil {
+		launchedFromEncryptedImage = state.Encrypted
+	}
+	if launchedFromEncryptedImage {
 		// get host hardware UUID
 		secLog.Infof("wlavm/start:Start() %s, Trying to get host hardware UUID", message.SU)
 		hardwareUUID, err := pinfo.HardwareUUID()
 		if err != nil {
 			log.WithError(err).Error("wlavm/start:Start() Unable to get the host hardware UUID")
-			return false
+			return newVMError(PhaseStart, HardwareUUIDFailed, err, "unable to get the host hardware UUID")
 		}
 		log.Debugf("wlavm/start:Start() The host hardware UUID is :%s", hardwareUUID)
 
-		//get flavor-key from workload service
-		// we will be hitting the WLS to retrieve the the flavor and key.
-		// TODO: Investigate if it makes sense to cache the flavor locally as well with
-		// an expiration time. Believe this was discussed and previously ruled out..
-		// but still worth exploring for performance reasons as we want to minimize
-		// making http client calls to external servers.
-		log.Infof("wlavm/start:Start() Retrieving image-flavor-key for image %s from WLS", imageUUID)
+		//get flavor-key from the flavor cache or the workload service, the flavor fetched
+		// during prepare is usually still cached at this point
+		log.Infof("wlavm/start:Start() Retrieving image-flavor-key for image %s", imageUUID)
 
-		flavorKeyInfo, err = wlsclient.GetImageFlavorKey(imageUUID, hardwareUUID)
+		flavorKeyInfo, err = flavor.GetImageFlavorKey(ctx, imageUUID, hardwareUUID)
 		if err != nil {
 			secLog.WithError(err).Error("wlavm/start:Start() Error retrieving the image flavor and key")
-			return false
+			return newVMError(PhaseStart, WlsUnreachable, err, "error retrieving the image flavor and key from WLS")
 		}
 
 		if flavorKeyInfo.Flavor.Meta.ID == "" {
 			log.Infof("wlavm/start:Start() Flavor does not exist for the image %s", imageUUID)
-			return false
+			return newVMError(PhaseStart, FlavorNotFound, nil, "flavor does not exist for the image "+imageUUID)
 		}
 
 		//create VM manifest
@@ -88,33 +116,45 @@ func Start(domainXMLContent string, filewatcher *filewatch.Watcher) bool {
 		manifest, err := vml.CreateVMManifest(vmUUID, hardwareUUID, imageUUID, true)
 		if err != nil {
 			log.WithError(err).Error("wlavm/start:Start() Error creating the VM manifest")
-			return false
+			return newVMError(PhaseStart, TrustReportFailed, err, "error creating the VM manifest")
 		}
 
 		//Create Image trust report
-		status := CreateInstanceTrustReport(manifest, wlsModel.SignedImageFlavor{ImageFlavor: flavorKeyInfo.Flavor, Signature: flavorKeyInfo.Signature})
-		if status == false {
+		err = CreateInstanceTrustReport(ctx, manifest, wlsModel.SignedImageFlavor{ImageFlavor: flavorKeyInfo.Flavor, Signature: flavorKeyInfo.Signature})
+		if err != nil {
 			log.Error("wlavm/start:Start() Error while creating image trust report")
-			return false
+			return err
+		}
+		reportPosted = true
+
+		// a VM on a standalone copy of the image does not share the image volume, it is not counted
+		if state != nil && state.StandaloneDisk {
+			log.Infof("wlavm/start:Start() VM %s has a standalone disk, not associating it with image %s", vmUUID, imageUUID)
+			log.Infof("wlavm/start:Start() VM %s started", vmUUID)
+			return nil
 		}
 
 		// Updating image-vm count association
 		log.Info("wlavm/start:Start() Associating VM with image in image-vm-count file")
 		iAssoc := ImageVMAssociation{imageUUID, imagePath}
+		unlockImage := imageLocks.Lock(imageUUID)
 		err = iAssoc.Create()
+		unlockImage()
 		if err != nil {
 			log.WithError(err).Error("wlavm/start:Start() Error while updating the image-instance count file")
-			return false
+			return newVMError(PhaseStart, AssociationFailed, err, "error while updating the image-vm association")
 		}
 	} else {
 		log.Info("wlavm/start:Start() Image is not encrypted, returning to the hook")
 	}
 
 	log.Infof("wlavm/start:Start() VM %s started", vmUUID)
-	return true
+	return nil
 }
 
-func CreateInstanceTrustReport(manifest instance.Manifest, flavor wlsModel.SignedImageFlavor) bool {
+// CreateInstanceTrustReport verifies the VM manifest against the image flavor, signs the resulting
+// instance trust report with the TPM signing key and posts it to the workload service
+func CreateInstanceTrustReport(ctx context.Context, manifest instance.Manifest, flavor wlsModel.SignedImageFlavor) error {
 	log.Trace("wlavm/start:CreateInstanceTrustReport() Entering")
 	defer log.Trace("wlavm/start:CreateInstanceTrustReport() Leaving")
 
@@ -124,7 +164,7 @@ func CreateInstanceTrustReport(manifest instance.Manifest, flavor wlsModel.Signe
 	if err != nil {
 		log.WithError(err).Error("wlavm/start:CreateInstanceTrustReport() Error creating image trust report")
 		log.Tracef("%+v", err)
-		return false
+		return newVMError(PhaseStart, TrustReportFailed, err, "error verifying the VM manifest against the image flavor")
 	}
 	trustreport, _ := json.Marshal(instanceTrustReport)
 	log.Infof("wlavm/start:CreateInstanceTrustReport() trustreport: %s", string(trustreport))
@@ -135,7 +175,7 @@ func CreateInstanceTrustReport(manifest instance.Manifest, flavor wlsModel.Signe
 	if err != nil {
 		log.WithError(err).Error("wlavm/start:CreateInstanceTrustReport() Could not sign image trust report using TPM")
 		log.Tracef("%+v", err)
-		return false
+		return newVMError(PhaseStart, TpmSignFailed, err, "could not sign the instance trust report using the TPM")
 	}
 
 	//post VM trust report on to workload service
@@ -143,12 +183,12 @@ func CreateInstanceTrustReport(manifest instance.Manifest, flavor wlsModel.Signe
 	report, _ := json.Marshal(*signedInstanceTrustReport)
 	log.Debugf("wlavm/start:CreateInstanceTrustReport() Report: %s", string(report))
 
-	err = wlsclient.PostVMReport(report)
+	err = wlsclient.PostVMReport(ctx, report)
 	if err != nil {
 		secLog.WithError(err).Error("wlavm/start:CreateInstanceTrustReport() Failed to post the instance trust report on to workload service")
-		return false
+		return newVMError(PhaseStart, WlsUnreachable, err, "failed to post the instance trust report to WLS")
 	}
-	return true
+	return nil
 }
 
 //Using SHA256 signing algorithm as TPM2.0 supports SHA256
@@ -207,6 +247,8 @@ func createSignatureWithTPM(data []byte, alg crypto.Hash) ([]byte, error) {
 	// }
 
 	// Before we compute the hash, we need to check the version of TPM as TPM 1.2 only supports SHA1
+	util.TpmMtx.Lock()
+	defer util.TpmMtx.Unlock()
 	t, err := util.GetTpmInstance()
 	if err != nil {
 		return nil, errors.Wrap(err, "wlavm/start:createSignatureWithTPM() Error attempting to create signature - could not open TPM")
@@ -219,7 +261,9 @@ func createSignatureWithTPM(data []byte, alg crypto.Hash) ([]byte, error) {
 	}
 
 	secLog.Infof("wlavm/start:createSignatureWithTPM() %s, Using TPM to sign the hash", message.SU)
+	start := time.Now()
 	signature, err := t.Sign(&signingKey, config.Configuration.SigningKeySecret, h)
+	metrics.ObserveTPMOperation("sign", start, err)
 	if err != nil {
 		return nil, errors.Wrap(err, "wlavm/start:createSignatureWithTPM() Error while creating tpm signature")
 	}
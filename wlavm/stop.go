@@ -8,73 +8,119 @@
 package wlavm
 
 import (
-	"intel/isecl/lib/common/v4/exec"
+	"context"
 	"intel/isecl/lib/common/v4/log/message"
-	"intel/isecl/lib/vml/v4"
 	"intel/isecl/wlagent/v4/consts"
 	"intel/isecl/wlagent/v4/filewatch"
 	"intel/isecl/wlagent/v4/libvirt"
 	"os"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-var (
-	mtx sync.Mutex
-)
-
 // Stop is called from the libvirt hook. Everytime stop cycle is called
 // in any of the VM lifecycle events, this method will be called.
 // e.g. shutdown, reboot, stop etc.
 // Input Parameters: domainXML content string
-// Return : Returns a boolean value to the main method.
-// true if the vm is stopped successfully, else returns false.
-func Stop(domainXMLContent string, filewatcher *filewatch.Watcher) bool {
+// Return : Returns nil if the vm is stopped successfully, else returns a *VMError
+// describing the reason of the failure.
+func Stop(ctx context.Context, domainXMLContent string, filewatcher *filewatch.Watcher) (err error) {
 	log.Trace("wlavm/stop:Stop() Entering")
 	defer log.Trace("wlavm/stop:Stop() Leaving")
+	defer func() {
+		err = contextError(ctx, PhaseStop, err)
+	}()
 	log.Info("wlavm/stop:Stop() Parsing domain XML to get image UUID, VM UUID and VM path")
 
-	d, err := libvirt.NewDomainParser(domainXMLContent, libvirt.Stop)
+	d, err := parseDomain(domainXMLContent, libvirt.Stop)
 	if err != nil {
 		log.Error("wlavm/stop:Stop() Parsing error")
-		return false
+		return newVMError(PhaseStop, InvalidDomainXML, err, "error parsing domain XML")
 	}
+	lifecycleMtx.RLock()
+	defer lifecycleMtx.RUnlock()
+	unlockVM := vmLocks.Lock(d.GetVMUUID())
+	defer unlockVM()
+	clearPending(d.GetVMUUID())
+	defer func() {
+		updateVMState(d.GetVMUUID(), false, func(state *VMState) {
+			state.Phase = PhaseStop
+			state.Error = vmErrorMessage(contextError(ctx, PhaseStop, err))
+			if err == nil {
+				state.StoppedAt = time.Now()
+			}
+		})
+	}()
 
 	// check if vm exists at given path
 	log.Infof("Checking if VM exists in %s", d.GetVMPath())
 	if _, err := os.Stat(d.GetVMPath()); os.IsNotExist(err) {
 		log.Error("wlavm/stop:Stop() VM does not exist")
-		return false
+		return newVMError(PhaseStop, VMNotFound, err, "VM does not exist at "+d.GetVMPath())
 	}
 
-	// check if the vm volume is encrypted
+	// check if the vm volume is encrypted, from the state of the VM, or from the status of its device mapper
+	// for the VMs prepared without a state record
 	log.Info("wlavm/stop:Stop() Checking if a dm-crypt volume for the image is created")
-	isVmVolume, err := isVmVolumeEncrypted(d.GetVMUUID())
+	volume := vmVolumeState(d.GetVMUUID(), d.GetVMPath())
+	var isVmVolume bool
+	state, err := LoadVMState(d.GetVMUUID())
 	if err != nil {
-		log.Error("wlavm/stop:Stop() Error while checking if a dm-crypt volume is created for the VM and is active")
-		log.Tracef("%+v", err)
-		return false
+		log.WithError(err).Warnf("wlavm/stop:Stop() Error loading the state of VM %s", d.GetVMUUID())
+	}
+	if state != nil {
+		// the volume is already closed if the VM was stopped successfully
+		isVmVolume = state.VMVolume != nil && (state.Phase != PhaseStop || state.Error != "")
+		if state.VMVolume != nil {
+			volume = state.VMVolume
+		}
+	} else {
+		isVmVolume, err = isVmVolumeEncrypted(ctx, d.GetVMUUID())
+		if err != nil {
+			log.Error("wlavm/stop:Stop() Error while checking if a dm-crypt volume is created for the VM and is active")
+			log.Tracef("%+v", err)
+			return newVMError(PhaseStop, VolumeFailed, err, "error checking the status of the VM dm-crypt volume")
+		}
 	}
-	// if vm volume is encrypted, close the volume
-	if isVmVolume {
-		var vmMountPath = consts.MountPath + d.GetVMUUID()
+	// the volume of a VM disk on a block device is closed once the VM disk is pointed back at the device
+	if isBlockVolumeLink(d.GetVMUUID(), d.GetVMPath()) || (isVmVolume && volume.BlockDevice != "") {
+		err = closeBlockVolume(ctx, d.GetVMUUID(), d.GetVMPath(), volume.BlockDevice)
+		if err != nil {
+			log.WithError(err).Errorf("wlavm/stop:Stop() Failed to close the volume over the block device of VM instance: %s", d.GetVMUUID())
+		}
+	} else if isVmVolume {
+		// if vm volume is encrypted, close the volume
 		// Unmount the image
 		secLog.Infof("wlavm/stop:Stop() %s, A dm-crypt volume for the image is created, deleting the vm volume", message.SU)
-		err = vml.Unmount(vmMountPath)
+		err = unmountVolume(ctx, volume.MountPath)
 		if err != nil {
 			log.Errorf("wlavm/stop:Stop() Failed to unmount volume for VM instance: %s", d.GetVMUUID())
 		}
-		err = vml.DeleteVolume(consts.DevMapperDirPath + d.GetVMUUID())
+		err = closeVolume(ctx, volume.DeviceMapperPath)
 		if err != nil {
 			log.Errorf("wlavm/stop:Stop() Failed to delete volume for VM instance: %s", d.GetVMUUID())
 		}
 	}
 
+	// the volume is left open when its teardown took longer than the timeout of Stop
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	// a VM on a standalone copy of the image does not share the image volume
+	if state != nil && state.StandaloneDisk {
+		log.Infof("wlavm/stop:Stop() VM %s has a standalone disk, VM stopped", d.GetVMUUID())
+		return nil
+	}
+
 	// check if this is the last vm associated with the image
 	// if so, unmount image decrypted volume
 	log.Info("wlavm/stop:Stop() Checking if this is the last vm using the image...")
+	// the image volume must not be closed while another VM is preparing to use it
+	unlockImage := imageLocks.Lock(d.GetImageUUID())
+	defer unlockImage()
 	iAssoc := ImageVMAssociation{d.GetImageUUID(), ""}
 	isLastVm, imagePath, err := iAssoc.Delete()
 	if err != nil {
@@ -85,37 +131,54 @@ func Stop(domainXMLContent string, filewatcher *filewatch.Watcher) bool {
 	// check if the original image was encrypted
 	if imagePath == "" {
 		log.Info("wlavm/stop:Stop() The base image is not encrypted, returning to hook...")
-		return true
+		return nil
 	}
 
 	// check if this is the last vm associated with the image
 	if !isLastVm {
 		log.Infof("wlavm/stop:Stop() Not deleting the image volume as this is not the last vm using the image, VM %s stopped", d.GetVMUUID())
-		return true
+		return nil
+	}
+	if isImagePending(d.GetImageUUID()) {
+		log.Infof("wlavm/stop:Stop() Not deleting the image volume as a VM using the image is being started, VM %s stopped", d.GetVMUUID())
+		return nil
 	}
 
 	log.Info("wlavm/stop:Stop() Unmounting and deleting the image volume as this is the last vm using the image")
 
-	mtx.Lock()
-	defer mtx.Unlock()
 	secLog.Infof("wlavm/stop:Stop() %s, Unmounting the image volume: %s", message.SU, imagePath)
 
 	// Unmount the image
-	err = vml.Unmount(imagePath)
+	err = unmountVolume(ctx, imagePath)
 	if err != nil {
 		log.Errorf("wlavm/stop:Stop() Failed to unmount volume for VM image: %s", d.GetImageUUID())
 	}
 	secLog.Infof("wlavm/stop:Stop() %s, Deleting the image volume: %s", message.SU, d.GetImageUUID())
 	// Close the image volume
-	err = vml.DeleteVolume(consts.DevMapperDirPath + d.GetImageUUID())
+	err = closeVolume(ctx, consts.DevMapperDirPath+d.GetImageUUID())
 	if err != nil {
 		log.Errorf("wlavm/stop:Stop() Failed to delete volume for VM image: %s", d.GetImageUUID())
 	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 	log.Infof("wlavm/stop:Stop() VM %s stopped", d.GetVMUUID())
-	return true
+	return nil
+}
+
+// unmountVolume unmounts the volume mounted at mountPath, umount is killed when ctx is done
+func unmountVolume(ctx context.Context, mountPath string) error {
+	_, err := executeCommand(ctx, "umount", []string{mountPath})
+	return err
+}
+
+// closeVolume closes the dm-crypt volume at deviceMapperPath, cryptsetup is killed when ctx is done
+func closeVolume(ctx context.Context, deviceMapperPath string) error {
+	_, err := executeCommand(ctx, "cryptsetup", []string{"luksClose", deviceMapperPath})
+	return err
 }
 
-func isVmVolumeEncrypted(vmUUID string) (bool, error) {
+func isVmVolumeEncrypted(ctx context.Context, vmUUID string) (bool, error) {
 	log.Trace("wlavm/stop:isVmVolumeEncrypted() Entering")
 	defer log.Trace("wlavm/stop:isVmVolumeEncrypted() Leaving")
 
@@ -126,7 +189,7 @@ func isVmVolumeEncrypted(vmUUID string) (bool, error) {
 	args := []string{"status", deviceMapperLocation}
 
 	secLog.Infof("wlavm/stop:isVmVolumeEncrypted() %s, Checking for volume with UUID:%s is encrypted", message.SU, vmUUID)
-	cmdOutput, err := exec.ExecuteCommand("cryptsetup", args)
+	cmdOutput, err := executeCommand(ctx, "cryptsetup", args)
 
 	if cmdOutput != "" && strings.Contains(cmdOutput, "inactive") {
 		log.Debug("wlavm/stop:isVmVolumeEncrypted() The device mapper is inactive")
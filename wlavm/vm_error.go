@@ -0,0 +1,83 @@
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import "fmt"
+
+// Phase identifies the VM lifecycle hook in which a failure occurred
+type Phase string
+
+const (
+	PhasePrepare Phase = "prepare"
+	PhaseStart   Phase = "start"
+	PhaseStop    Phase = "stop"
+)
+
+// ErrorCode classifies the cause of a VM lifecycle failure so that it can be reported back to the libvirt hook
+type ErrorCode string
+
+const (
+	// InvalidDomainXML is reported when the domain XML can not be validated or parsed
+	InvalidDomainXML ErrorCode = "INVALID_DOMAIN_XML"
+	// HardwareUUIDFailed is reported when the host hardware UUID can not be read
+	HardwareUUIDFailed ErrorCode = "HARDWARE_UUID_FAILED"
+	// WlsUnreachable is reported when a request to the workload service fails
+	WlsUnreachable ErrorCode = "WLS_UNREACHABLE"
+	// FlavorNotFound is reported when there is no image flavor associated with an image
+	FlavorNotFound ErrorCode = "FLAVOR_NOT_FOUND"
+	// KeyDenied is reported when the workload service does not release the image key, e.g. the host is untrusted
+	KeyDenied ErrorCode = "KEY_DENIED"
+	// TpmUnbindFailed is reported when the TPM wrapped image key can not be unbound with the binding key
+	TpmUnbindFailed ErrorCode = "TPM_UNBIND_FAILED"
+	// TpmSignFailed is reported when the instance trust report can not be signed with the signing key
+	TpmSignFailed ErrorCode = "TPM_SIGN_FAILED"
+	// DecryptFailed is reported when the encrypted image can not be decrypted
+	DecryptFailed ErrorCode = "DECRYPT_FAILED"
+	// QemuImgFailed is reported when a qemu-img operation on the VM disk or image fails
+	QemuImgFailed ErrorCode = "QEMU_IMG_FAILED"
+	// VolumeFailed is reported when a dm-crypt volume can not be created, mounted, unmounted or closed
+	VolumeFailed ErrorCode = "VOLUME_FAILED"
+	// TrustReportFailed is reported when the instance trust report can not be created
+	TrustReportFailed ErrorCode = "TRUST_REPORT_FAILED"
+	// AssociationFailed is reported when the image-vm association can not be read or updated
+	AssociationFailed ErrorCode = "ASSOCIATION_FAILED"
+	// ImageUnidentified is reported when the UUID of an encrypted image is neither in the domain XML nor resolved
+	ImageUnidentified ErrorCode = "IMAGE_UNIDENTIFIED"
+	// VMNotFound is reported when the VM disk referred to by the domain XML does not exist
+	VMNotFound ErrorCode = "VM_NOT_FOUND"
+	// Timeout is reported when the operation did not finish within the timeout of its phase or was cancelled
+	Timeout ErrorCode = "TIMEOUT"
+	// InternalError is reported for any failure that does not fit in one of the other codes
+	InternalError ErrorCode = "INTERNAL_ERROR"
+)
+
+// VMError is returned by Prepare, Start and Stop and describes why a VM lifecycle operation failed
+type VMError struct {
+	Phase   Phase
+	Code    ErrorCode
+	Message string
+	Err     error
+}
+
+func (e *VMError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s (%s): %s: %s", e.Code, e.Phase, e.Message, e.Err.Error())
+	}
+	return fmt.Sprintf("%s (%s): %s", e.Code, e.Phase, e.Message)
+}
+
+// Cause returns the underlying error so that errors.Cause can walk through a VMError
+func (e *VMError) Cause() error {
+	return e.Err
+}
+
+func newVMError(phase Phase, code ErrorCode, err error, message string) *VMError {
+	return &VMError{
+		Phase:   phase,
+		Code:    code,
+		Message: message,
+		Err:     err,
+	}
+}
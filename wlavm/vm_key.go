@@ -0,0 +1,106 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"context"
+	"crypto/rand"
+	"intel/isecl/lib/common/v4/log/message"
+	"intel/isecl/wlagent/v4/config"
+	"intel/isecl/wlagent/v4/consts"
+	"intel/isecl/wlagent/v4/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	vmKeyDirPath = consts.VMKeyDirPath
+	// wrapVMKey and unwrapVMKey wrap and unwrap the key of a VM volume with the TPM binding key
+	wrapVMKey   = util.WrapKey
+	unwrapVMKey = util.UnwrapKey
+)
+
+// vmKeySize is the size of the key of a VM volume, as the size of an AES-256 image key
+const vmKeySize = 32
+
+// vmKeyFilePath returns the path of the wrapped key of the volume of the VM
+func vmKeyFilePath(vmUUID string) string {
+	return filepath.Join(vmKeyDirPath, vmUUID+".key")
+}
+
+// hasVMKey returns true if the volume of the VM has a key of its own
+func hasVMKey(vmUUID string) bool {
+	_, err := os.Stat(vmKeyFilePath(vmUUID))
+	return err == nil
+}
+
+// vmVolumeKey returns the key the volume of the VM is opened with: its own key, unwrapped by the TPM, if it has one,
+// else the image key. A volume about to be created is given a key of its own when PerVMKey is set.
+func vmVolumeKey(ctx context.Context, rb *rollback, vmUUID string, imageKey []byte, newVolume bool) ([]byte, error) {
+	log.Trace("wlavm/vm_key:vmVolumeKey() Entering")
+	defer log.Trace("wlavm/vm_key:vmVolumeKey() Leaving")
+
+	keyFilePath := vmKeyFilePath(vmUUID)
+	wrappedKey, err := ioutil.ReadFile(keyFilePath)
+	if err == nil {
+		log.Infof("wlavm/vm_key:vmVolumeKey() Unwrapping the key of the volume of VM %s", vmUUID)
+		util.TpmMtx.Lock()
+		key, err := unwrapVMKey(ctx, wrappedKey)
+		util.TpmMtx.Unlock()
+		if err != nil {
+			secLog.WithError(err).Errorf("wlavm/vm_key:vmVolumeKey() Error unwrapping the key of the volume of VM %s", vmUUID)
+			return nil, newVMError(PhasePrepare, TpmUnbindFailed, err, "error unwrapping the VM volume key with the TPM binding key")
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, newVMError(PhasePrepare, VolumeFailed, err, "error reading the VM volume key "+keyFilePath)
+	}
+	if !newVolume || !config.Configuration.PerVMKey {
+		return imageKey, nil
+	}
+
+	key := make([]byte, vmKeySize)
+	if _, err = rand.Read(key); err != nil {
+		return nil, newVMError(PhasePrepare, InternalError, err, "error generating the VM volume key")
+	}
+	wrappedKey, err = wrapVMKey(key)
+	if err != nil {
+		log.WithError(err).Errorf("wlavm/vm_key:vmVolumeKey() Error wrapping the key of the volume of VM %s", vmUUID)
+		return nil, newVMError(PhasePrepare, VolumeFailed, err, "error wrapping the VM volume key with the TPM binding key")
+	}
+	err = os.MkdirAll(vmKeyDirPath, 0700)
+	if err == nil {
+		err = ioutil.WriteFile(keyFilePath, wrappedKey, 0600)
+	}
+	if err != nil {
+		return nil, newVMError(PhasePrepare, VolumeFailed, err, "error saving the VM volume key "+keyFilePath)
+	}
+	secLog.Infof("wlavm/vm_key:vmVolumeKey() %s, Created the key of the volume of VM %s", message.SU, vmUUID)
+	rb.add("remove VM volume key "+keyFilePath, func() error {
+		return removeVMKey(vmUUID)
+	})
+	return key, nil
+}
+
+// removeVMKey removes the wrapped key of the volume of the VM, if it has one
+func removeVMKey(vmUUID string) error {
+	if !hasVMKey(vmUUID) {
+		return nil
+	}
+	keyFilePath := vmKeyFilePath(vmUUID)
+	secLog.Infof("wlavm/vm_key:removeVMKey() %s, Removing the VM volume key %s", message.SU, keyFilePath)
+	err := os.Remove(keyFilePath)
+	if err != nil {
+		return errors.Wrapf(err, "wlavm/vm_key:removeVMKey() error removing the VM volume key %s", keyFilePath)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"bytes"
+	"context"
+	"intel/isecl/wlagent/v4/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVMVolumeKeyDefaultsToImageKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vm-keys")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(keyDirPath string, perVMKey bool) {
+		vmKeyDirPath = keyDirPath
+		config.Configuration.PerVMKey = perVMKey
+	}(vmKeyDirPath, config.Configuration.PerVMKey)
+	vmKeyDirPath = filepath.Join(dir, "vm-keys")
+
+	imageKey := []byte("0123456789abcdef0123456789abcdef")
+	rb := &rollback{}
+	config.Configuration.PerVMKey = false
+	key, err := vmVolumeKey(context.Background(), rb, testVMUUID, imageKey, true)
+	assert.NoError(t, err)
+	assert.Equal(t, imageKey, key)
+	assert.False(t, hasVMKey(testVMUUID))
+
+	// a volume created with the image key keeps it when the option is turned on
+	config.Configuration.PerVMKey = true
+	key, err = vmVolumeKey(context.Background(), rb, testVMUUID, imageKey, false)
+	assert.NoError(t, err)
+	assert.Equal(t, imageKey, key)
+	assert.False(t, hasVMKey(testVMUUID))
+	assert.NoError(t, rb.run())
+
+	assert.NoError(t, os.MkdirAll(vmKeyDirPath, 0700))
+	assert.NoError(t, ioutil.WriteFile(vmKeyFilePath(testVMUUID), []byte("wrapped key"), 0600))
+	assert.True(t, hasVMKey(testVMUUID))
+	assert.False(t, hasVMKey(testOtherVMUUID))
+	assert.NoError(t, removeVMKey(testVMUUID))
+	assert.False(t, hasVMKey(testVMUUID))
+	assert.NoError(t, removeVMKey(testVMUUID))
+}
+
+func TestVMVolumeKeyPerVM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vm-keys")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(keyDirPath string, perVMKey bool, wrap func([]byte) ([]byte, error),
+		unwrap func(context.Context, []byte) ([]byte, error)) {
+		vmKeyDirPath = keyDirPath
+		config.Configuration.PerVMKey = perVMKey
+		wrapVMKey = wrap
+		unwrapVMKey = unwrap
+	}(vmKeyDirPath, config.Configuration.PerVMKey, wrapVMKey, unwrapVMKey)
+	vmKeyDirPath = filepath.Join(dir, "vm-keys")
+	config.Configuration.PerVMKey = true
+
+	// the TPM binding key is replaced by a prefix that the unwrapping checks and removes
+	wrapPrefix := []byte("wrapped:")
+	wrapVMKey = func(key []byte) ([]byte, error) {
+		return append(append([]byte{}, wrapPrefix...), key...), nil
+	}
+	unwrapVMKey = func(ctx context.Context, wrappedKey []byte) ([]byte, error) {
+		if !bytes.HasPrefix(wrappedKey, wrapPrefix) {
+			return nil, errors.New("not a wrapped key")
+		}
+		return wrappedKey[len(wrapPrefix):], nil
+	}
+
+	imageKey := []byte("0123456789abcdef0123456789abcdef")
+	rb := &rollback{}
+	key, err := vmVolumeKey(context.Background(), rb, testVMUUID, imageKey, true)
+	assert.NoError(t, err)
+	assert.Len(t, key, vmKeySize)
+	assert.NotEqual(t, imageKey, key)
+	wrappedKey, err := ioutil.ReadFile(vmKeyFilePath(testVMUUID))
+	assert.NoError(t, err)
+	assert.Equal(t, append(append([]byte{}, wrapPrefix...), key...), wrappedKey)
+	rb.commit()
+
+	// the next launches unwrap the key of the volume, even with the option turned off
+	config.Configuration.PerVMKey = false
+	unwrappedKey, err := vmVolumeKey(context.Background(), &rollback{}, testVMUUID, imageKey, false)
+	assert.NoError(t, err)
+	assert.Equal(t, key, unwrappedKey)
+
+	assert.NoError(t, ioutil.WriteFile(vmKeyFilePath(testVMUUID), []byte("corrupted"), 0600))
+	_, err = vmVolumeKey(context.Background(), &rollback{}, testVMUUID, imageKey, false)
+	vmErr, ok := err.(*VMError)
+	assert.True(t, ok)
+	assert.Equal(t, TpmUnbindFailed, vmErr.Code)
+
+	// a key created for a volume that is not prepared is removed on rollback
+	config.Configuration.PerVMKey = true
+	rb = &rollback{}
+	_, err = vmVolumeKey(context.Background(), rb, testOtherVMUUID, imageKey, true)
+	assert.NoError(t, err)
+	assert.True(t, hasVMKey(testOtherVMUUID))
+	assert.NoError(t, rb.run())
+	assert.False(t, hasVMKey(testOtherVMUUID))
+
+	// no key is saved when it can not be wrapped
+	wrapVMKey = func(key []byte) ([]byte, error) {
+		return nil, errors.New("no binding key")
+	}
+	_, err = vmVolumeKey(context.Background(), &rollback{}, testOtherVMUUID, imageKey, true)
+	assert.Error(t, err)
+	assert.False(t, hasVMKey(testOtherVMUUID))
+}
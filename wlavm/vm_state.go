@@ -0,0 +1,268 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import (
+	"encoding/json"
+	"intel/isecl/wlagent/v4/consts"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	vmStateDirPath = consts.VMStateDirPath
+	vmStateMtx     sync.Mutex
+	// libvirt accepts UUIDs of any version, the UUID names the record file so it is validated
+	vmUUIDRegex = regexp.MustCompile("^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}$")
+)
+
+// VolumeState describes a dm-crypt volume used by a VM
+type VolumeState struct {
+	DeviceMapperPath string `json:"device_mapper_path"`
+	MountPath        string `json:"mount_path"`
+	SparseFilePath   string `json:"sparse_file_path"`
+	// BlockDevice is the device under a volume opened directly over a block device, such a volume has neither
+	// a sparse file nor a mount path
+	BlockDevice string `json:"block_device,omitempty"`
+}
+
+// VMState is the record of the lifecycle of a VM, kept under the agent library directory so that it survives a reboot.
+// VMs prepared before the records were introduced have none, the lifecycle then inspects the host for them.
+type VMState struct {
+	VMUUID    string `json:"vm_uuid"`
+	ImageUUID string `json:"image_uuid"`
+	VMPath    string `json:"vm_path"`
+	// ImagePath is the path of the encrypted image the VM was launched from
+	ImagePath string `json:"image_path,omitempty"`
+	// Encrypted is true if the VM was launched from an encrypted image
+	Encrypted bool   `json:"encrypted"`
+	KeyID     string `json:"key_id,omitempty"`
+	// StandaloneDisk is true if the VM disk was a copy of the encrypted image rather than an overlay on it, the VM
+	// disk is then decrypted with the image key and the VM uses neither the image volume nor the image association
+	StandaloneDisk bool `json:"standalone_disk,omitempty"`
+	// PerVMKey is true if the VM volume is opened with a key of its own rather than the image key
+	PerVMKey    bool         `json:"per_vm_key,omitempty"`
+	VMVolume    *VolumeState `json:"vm_volume,omitempty"`
+	ImageVolume *VolumeState `json:"image_volume,omitempty"`
+	// Phase is the last lifecycle phase of the VM, and Error the reason it failed if it did
+	Phase      Phase     `json:"phase"`
+	Error      string    `json:"error,omitempty"`
+	PreparedAt time.Time `json:"prepared_at"`
+	StartedAt  time.Time `json:"started_at"`
+	StoppedAt  time.Time `json:"stopped_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	// ReportPostedAt is when the instance trust report of the VM was last posted to WLS
+	ReportPostedAt time.Time `json:"report_posted_at"`
+}
+
+// LoadVMState returns the record of the VM, or nil if the VM has none
+func LoadVMState(vmUUID string) (*VMState, error) {
+	log.Trace("wlavm/vm_state:LoadVMState() Entering")
+	defer log.Trace("wlavm/vm_state:LoadVMState() Leaving")
+
+	if !vmUUIDRegex.MatchString(vmUUID) {
+		return nil, errors.Errorf("wlavm/vm_state:LoadVMState() Invalid VM UUID %s", vmUUID)
+	}
+	vmStateMtx.Lock()
+	defer vmStateMtx.Unlock()
+	state, err := readVMStateFile(vmStateFilePath(vmUUID))
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, nil
+	}
+	return state, err
+}
+
+// ListVMStates returns the records of all the VMs, sorted by VM UUID. Records that can not be read are skipped.
+func ListVMStates() ([]VMState, error) {
+	log.Trace("wlavm/vm_state:ListVMStates() Entering")
+	defer log.Trace("wlavm/vm_state:ListVMStates() Leaving")
+
+	vmStateMtx.Lock()
+	defer vmStateMtx.Unlock()
+	files, err := filepath.Glob(filepath.Join(vmStateDirPath, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "wlavm/vm_state:ListVMStates() Error listing VM state records")
+	}
+	states := make([]VMState, 0, len(files))
+	for _, file := range files {
+		state, err := readVMStateFile(file)
+		if err != nil {
+			log.WithError(err).Warnf("wlavm/vm_state:ListVMStates() Skipping VM state record %s", file)
+			continue
+		}
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].VMUUID < states[j].VMUUID
+	})
+	return states, nil
+}
+
+// updateVMState applies update to the record of the VM and saves it. A VM without a record is only given one when
+// create is true. The lifecycle does not depend on the record being saved, a failure is logged.
+func updateVMState(vmUUID string, create bool, update func(state *VMState)) {
+	log.Trace("wlavm/vm_state:updateVMState() Entering")
+	defer log.Trace("wlavm/vm_state:updateVMState() Leaving")
+
+	if !vmUUIDRegex.MatchString(vmUUID) {
+		log.Errorf("wlavm/vm_state:updateVMState() Not recording the state of VM with invalid UUID %s", vmUUID)
+		return
+	}
+	vmStateMtx.Lock()
+	defer vmStateMtx.Unlock()
+
+	statePath := vmStateFilePath(vmUUID)
+	state, err := readVMStateFile(statePath)
+	if err != nil {
+		if !create && os.IsNotExist(errors.Cause(err)) {
+			return
+		}
+		if !os.IsNotExist(errors.Cause(err)) {
+			log.WithError(err).Warnf("wlavm/vm_state:updateVMState() Replacing the VM state record %s", statePath)
+		}
+		state = &VMState{VMUUID: vmUUID}
+	}
+	update(state)
+	state.UpdatedAt = time.Now()
+	if err = writeVMStateFile(statePath, state); err != nil {
+		log.WithError(err).Errorf("wlavm/vm_state:updateVMState() Error saving the state of VM %s", vmUUID)
+	}
+}
+
+// removeVMState removes the record of a deleted VM
+func removeVMState(vmUUID string) {
+	log.Trace("wlavm/vm_state:removeVMState() Entering")
+	defer log.Trace("wlavm/vm_state:removeVMState() Leaving")
+
+	if !vmUUIDRegex.MatchString(vmUUID) {
+		return
+	}
+	vmStateMtx.Lock()
+	defer vmStateMtx.Unlock()
+	if err := os.Remove(vmStateFilePath(vmUUID)); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Errorf("wlavm/vm_state:removeVMState() Error removing the state of VM %s", vmUUID)
+	}
+}
+
+// vmVolumeState returns the volume holding the disk of the VM
+func vmVolumeState(vmUUID, vmPath string) *VolumeState {
+	return &VolumeState{
+		DeviceMapperPath: consts.DevMapperDirPath + vmUUID,
+		MountPath:        consts.MountPath + vmUUID,
+		SparseFilePath:   vmSparseFilePath(vmUUID, vmPath),
+	}
+}
+
+// vmSparseFilePath returns the sparse file of the VM volume, next to the VM disk. Nova names the VM disk "disk"
+// in the directory of the VM, the disks of the other VMs can have any name.
+func vmSparseFilePath(vmUUID, vmPath string) string {
+	if filepath.Base(vmPath) == "disk" {
+		return strings.Replace(vmPath, "disk", vmUUID+"_sparse", -1)
+	}
+	return filepath.Join(filepath.Dir(vmPath), vmUUID+"_sparse")
+}
+
+// vmDeleteWatchPath returns the file whose removal means the VM is deleted, the disk.info file Nova keeps next to
+// the VM disk, or the VM disk itself for the other VMs
+func vmDeleteWatchPath(vmPath string) string {
+	if filepath.Base(vmPath) == "disk" {
+		return strings.Replace(vmPath, "disk", "disk.info", -1)
+	}
+	return vmPath
+}
+
+// imageVolumeState returns the volume holding the decrypted image
+func imageVolumeState(imageUUID, imagePath string) *VolumeState {
+	return &VolumeState{
+		DeviceMapperPath: consts.DevMapperDirPath + imageUUID,
+		MountPath:        consts.MountPath + imageUUID,
+		SparseFilePath:   imageSparseFilePath(imageUUID, imagePath),
+	}
+}
+
+// imageSparseFilePath returns the sparse file of the image volume, next to the encrypted image, or in the image
+// volume directory of the agent for an image on a block device
+func imageSparseFilePath(imageUUID, imagePath string) string {
+	if strings.HasPrefix(imagePath, blockDeviceDirPath) {
+		return consts.ImageVolumeDirPath + imageUUID + imageSparseFileSuffix
+	}
+	return imagePath + imageSparseFileSuffix
+}
+
+// blockVolumeState returns the volume of the VM opened directly over the block device holding the VM disk
+func blockVolumeState(vmUUID, device string) *VolumeState {
+	return &VolumeState{
+		DeviceMapperPath: consts.DevMapperDirPath + vmUUID,
+		BlockDevice:      device,
+	}
+}
+
+// vmErrorMessage returns the message recorded for a failed lifecycle phase
+func vmErrorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	if vmErr, ok := err.(*VMError); ok {
+		return string(vmErr.Code) + ": " + vmErr.Message
+	}
+	return err.Error()
+}
+
+func vmStateFilePath(vmUUID string) string {
+	return filepath.Join(vmStateDirPath, strings.ToLower(vmUUID)+".json")
+}
+
+func readVMStateFile(statePath string) (*VMState, error) {
+	content, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "wlavm/vm_state:readVMStateFile() Error reading %s", statePath)
+	}
+	var state VMState
+	if err = json.Unmarshal(content, &state); err != nil {
+		return nil, errors.Wrapf(err, "wlavm/vm_state:readVMStateFile() Error parsing %s", statePath)
+	}
+	if vmStateFilePath(state.VMUUID) != statePath {
+		return nil, errors.Errorf("wlavm/vm_state:readVMStateFile() %s is not the record of VM %s", statePath, state.VMUUID)
+	}
+	return &state, nil
+}
+
+func writeVMStateFile(statePath string, state *VMState) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "wlavm/vm_state:writeVMStateFile() Error marshalling VM state")
+	}
+	if err = os.MkdirAll(vmStateDirPath, 0700); err != nil {
+		return errors.Wrapf(err, "wlavm/vm_state:writeVMStateFile() Error creating directory %s", vmStateDirPath)
+	}
+
+	// write to a temporary file and rename it so that a reader never sees a partially written record
+	tmpFile, err := ioutil.TempFile(vmStateDirPath, ".tmp-")
+	if err != nil {
+		return errors.Wrap(err, "wlavm/vm_state:writeVMStateFile() Error creating VM state record")
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "wlavm/vm_state:writeVMStateFile() Error writing VM state record")
+	}
+	if err = os.Rename(tmpFile.Name(), statePath); err != nil {
+		return errors.Wrap(err, "wlavm/vm_state:writeVMStateFile() Error saving VM state record")
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+package wlavm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const testOtherVMUUID = "1b4a3ac8-0b3e-11ec-9a03-0242ac130003"
+
+func setupTestVMStateDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "vm-state")
+	assert.NoError(t, err)
+	previousDirPath := vmStateDirPath
+	vmStateDirPath = filepath.Join(dir, "vm-state")
+	return func() {
+		vmStateDirPath = previousDirPath
+		os.RemoveAll(dir)
+	}
+}
+
+func TestVMStateUpdateAndLoad(t *testing.T) {
+	defer setupTestVMStateDir(t)()
+
+	// a VM without a record is only given one on create
+	updateVMState(testVMUUID, false, func(state *VMState) {
+		state.Phase = PhaseStart
+	})
+	state, err := LoadVMState(testVMUUID)
+	assert.NoError(t, err)
+	assert.Nil(t, state)
+
+	updateVMState(testVMUUID, true, func(state *VMState) {
+		state.ImageUUID = testImageUUID
+		state.Encrypted = true
+		state.VMVolume = vmVolumeState(testVMUUID, "/var/lib/nova/instances/"+testVMUUID+"/disk")
+		state.Phase = PhasePrepare
+	})
+	updateVMState(testVMUUID, false, func(state *VMState) {
+		state.Phase = PhaseStart
+		state.Error = vmErrorMessage(newVMError(PhaseStart, WlsUnreachable, errors.New("refused"), "error posting the report"))
+	})
+
+	state, err = LoadVMState(testVMUUID)
+	assert.NoError(t, err)
+	assert.NotNil(t, state)
+	assert.Equal(t, testVMUUID, state.VMUUID)
+	assert.Equal(t, testImageUUID, state.ImageUUID)
+	assert.True(t, state.Encrypted)
+	assert.Equal(t, "/var/lib/nova/instances/"+testVMUUID+"/"+testVMUUID+"_sparse", state.VMVolume.SparseFilePath)
+	assert.Equal(t, PhaseStart, state.Phase)
+	assert.Equal(t, "WLS_UNREACHABLE: error posting the report", state.Error)
+	assert.False(t, state.UpdatedAt.IsZero())
+
+	fInfo, err := os.Stat(vmStateFilePath(testVMUUID))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fInfo.Mode().Perm())
+
+	removeVMState(testVMUUID)
+	state, err = LoadVMState(testVMUUID)
+	assert.NoError(t, err)
+	assert.Nil(t, state)
+
+	_, err = LoadVMState("../" + testVMUUID)
+	assert.Error(t, err)
+}
+
+func TestListVMStates(t *testing.T) {
+	defer setupTestVMStateDir(t)()
+
+	states, err := ListVMStates()
+	assert.NoError(t, err)
+	assert.Empty(t, states)
+
+	for _, vmUUID := range []string{testVMUUID, testOtherVMUUID} {
+		updateVMState(vmUUID, true, func(state *VMState) {
+			state.Phase = PhasePrepare
+		})
+	}
+	// a record that does not belong to the VM it is named after is skipped
+	content, err := ioutil.ReadFile(vmStateFilePath(testVMUUID))
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(vmStateDirPath, testImageUUID+".json"), content, 0600))
+
+	states, err = ListVMStates()
+	assert.NoError(t, err)
+	if assert.Len(t, states, 2) {
+		assert.Equal(t, testOtherVMUUID, states[0].VMUUID)
+		assert.Equal(t, testVMUUID, states[1].VMUUID)
+	}
+}
@@ -0,0 +1,74 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// VolumeKind tells whether a dm-crypt volume holds a VM disk or a decrypted image
+type VolumeKind string
+
+const (
+	VolumeKindVM    VolumeKind = "vm"
+	VolumeKindImage VolumeKind = "image"
+
+	// imageSparseFileSuffix is appended to the encrypted image path to name the sparse file of its volume
+	imageSparseFileSuffix = "_sparseFile"
+)
+
+// Volume is a dm-crypt volume opened by the agent
+type Volume struct {
+	UUID        string
+	Kind        VolumeKind
+	BackingFile string
+	MountPoint  string
+}
+
+// ListVolumes returns the dm-crypt volumes opened by the agent, sorted by UUID
+func ListVolumes() ([]Volume, error) {
+	log.Trace("wlavm/volumes:ListVolumes() Entering")
+	defer log.Trace("wlavm/volumes:ListVolumes() Leaving")
+
+	backingFiles, err := agentVolumeBackingFiles()
+	if err != nil {
+		return nil, err
+	}
+	mountPoints, err := listAgentMounts()
+	if err != nil {
+		return nil, err
+	}
+	mounted := make(map[string]string, len(mountPoints))
+	for _, mountPoint := range mountPoints {
+		mounted[filepath.Base(mountPoint)] = mountPoint
+	}
+
+	volumes := make([]Volume, 0, len(backingFiles))
+	for uuid, backingFile := range backingFiles {
+		volumes = append(volumes, newVolume(uuid, backingFile, mounted[uuid]))
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].UUID < volumes[j].UUID
+	})
+	return volumes, nil
+}
+
+func newVolume(uuid, backingFile, mountPoint string) Volume {
+	kind := VolumeKindVM
+	if strings.HasSuffix(backingFile, imageSparseFileSuffix) {
+		kind = VolumeKindImage
+	}
+	return Volume{
+		UUID:        uuid,
+		Kind:        kind,
+		BackingFile: backingFile,
+		MountPoint:  mountPoint,
+	}
+}
@@ -0,0 +1,29 @@
+// +build linux
+
+/*
+ * Copyright (C) 2021 Intel Corporation
+ * SPDX-License-Identifier: BSD-3-Clause
+ */
+
+package wlavm
+
+import (
+	"intel/isecl/wlagent/v4/consts"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewVolume(t *testing.T) {
+	image := newVolume(testImageUUID, "/var/lib/nova/instances/_base/image_sparseFile", consts.MountPath+testImageUUID)
+	assert.Equal(t, Volume{
+		UUID:        testImageUUID,
+		Kind:        VolumeKindImage,
+		BackingFile: "/var/lib/nova/instances/_base/image_sparseFile",
+		MountPoint:  consts.MountPath + testImageUUID,
+	}, image)
+
+	vm := newVolume(testVMUUID, "/var/lib/nova/instances/"+testVMUUID+"/"+testVMUUID+"_sparse", "")
+	assert.Equal(t, VolumeKindVM, vm.Kind)
+	assert.Empty(t, vm.MountPoint)
+}